@@ -0,0 +1,159 @@
+// Package audit writes a record of each admission decision to a dedicated sink, separate from the operational logs of
+// the webhook, so that it may be proven which objects were admitted against which licensed capacity.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Record represents the audit record of a single admission decision.
+type Record struct {
+	// Sequence and PrevHash chain the record to the record before it when records are chained.  The sequence of the
+	// first record of a chain is 1 and it has no previous hash.
+	Sequence uint64 `json:"sequence,omitempty"`
+	PrevHash string `json:"prevHash,omitempty"`
+
+	Time time.Time `json:"time"`
+	UID  string    `json:"uid"`
+
+	User   User   `json:"user"`
+	Object Object `json:"object"`
+
+	// ChargedVCPUs is the number of vCPUs that the object is charged against the licensed capacity, or zero if the
+	// capacity was not evaluated for the object.
+	ChargedVCPUs int `json:"chargedVCPUs"`
+
+	// Capacity is the licensed capacity at the time of the decision, or nil if it was not evaluated.
+	Capacity *Capacity `json:"capacity,omitempty"`
+
+	// Reasons are the reasons that the object was detected as a windows instance, if it was one.
+	Reasons []string `json:"reasons"`
+
+	Policy  Policy  `json:"policy"`
+	Outcome Outcome `json:"outcome"`
+
+	// Hash is the hash of the record when records are chained.  It must remain the last field, as it is appended to
+	// the record that it is the hash of.
+	Hash string `json:"hash,omitempty"`
+}
+
+// User represents the user who made the request.
+type User struct {
+	Username string   `json:"username"`
+	UID      string   `json:"uid,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// Object represents the object of the request.
+type Object struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Operation string `json:"operation"`
+	DryRun    bool   `json:"dryRun"`
+}
+
+// Capacity represents the licensed capacity, in vCPUs, at the time of a decision.
+type Capacity struct {
+	Total     int   `json:"total"`
+	Used      int   `json:"used"`
+	Reserved  int   `json:"reserved"`
+	Available int   `json:"available"`
+	Priority  int32 `json:"priority"`
+}
+
+// Policy represents the policy that a decision was made under.  The hash covers the effective configuration of the
+// policy, so that a change in configuration is visible even when the version is not changed.
+type Policy struct {
+	Version string `json:"version,omitempty"`
+	Hash    string `json:"hash"`
+}
+
+// Outcome represents the response which was sent for a request.
+type Outcome struct {
+	Allowed bool   `json:"allowed"`
+	Code    int32  `json:"code"`
+	Message string `json:"message"`
+
+	// Cached is set when the response replays the decision of an earlier request with the same UID.
+	Cached bool `json:"cached,omitempty"`
+}
+
+// Logger writes audit records as JSON, one record per line.
+type Logger struct {
+	mu     sync.Mutex
+	writer io.Writer
+
+	// chained and head are set when each record is chained to the record before it.  The head is the last record
+	// which was written.
+	chained bool
+	head    Head
+}
+
+// NewLogger returns a new instance of a logger which writes audit records to a writer.
+func NewLogger(writer io.Writer) *Logger {
+	return &Logger{writer: writer}
+}
+
+// NewChainedLogger returns a new instance of a logger which writes audit records to a writer, chaining each record to
+// the record before it, starting from the head of an existing chain.  A zero head starts a new chain.
+func NewChainedLogger(writer io.Writer, head Head) *Logger {
+	return &Logger{writer: writer, chained: true, head: head}
+}
+
+// Write writes an audit record.  Records are written whole, so that concurrent decisions never interleave.  When
+// records are chained, the sequence, previous hash and hash of the record are set.
+func (l *Logger) Write(record *Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var (
+		data []byte
+		err  error
+	)
+
+	if l.chained {
+		record.Sequence, record.PrevHash = l.head.Sequence+1, l.head.Hash
+		data, err = chain(record)
+	} else {
+		data, err = json.Marshal(record)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record; %w", err)
+	}
+
+	if _, err := l.writer.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record; %w", err)
+	}
+
+	if l.chained {
+		l.head = Head{Sequence: record.Sequence, Hash: record.Hash, Time: record.Time}
+	}
+
+	return nil
+}
+
+// Head returns the head of the chain, which is the last record that was written, or false if records are not chained.
+func (l *Logger) Head() (Head, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.head, l.chained
+}
+
+// Close closes the writer of the logger, if it may be closed.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if closer, ok := l.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
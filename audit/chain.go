@@ -0,0 +1,147 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// hashPrefix is the prefix of the hash of a record within its encoded form.  The hash is always the last field of a
+// record, so that the record it is the hash of is the encoded record with the hash removed.
+const hashPrefix = `,"hash":"`
+
+// maxLineBytes is the maximum size of a single audit record which is read.
+const maxLineBytes = 1024 * 1024
+
+// Head represents the head of a chain of audit records, which is the last record in the chain.
+type Head struct {
+	Sequence uint64    `json:"sequence"`
+	Hash     string    `json:"hash"`
+	Time     time.Time `json:"time"`
+}
+
+// chain sets the hash of a record, whose sequence and previous hash are already set, and returns its encoded form.
+func chain(record *Record) ([]byte, error) {
+	record.Hash = ""
+
+	body, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	record.Hash = hashOf(body)
+
+	return append(body[:len(body)-1], []byte(hashPrefix+record.Hash+`"}`)...), nil
+}
+
+// hashOf returns the hash of an encoded record, without its hash.
+func hashOf(body []byte) string {
+	sum := sha256.Sum256(body)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// splitHash splits an encoded record into the record without its hash and its hash.  It returns false if the record
+// does not end with a hash.
+func splitHash(line []byte) ([]byte, string, bool) {
+	index := bytes.LastIndex(line, []byte(hashPrefix))
+	if index < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return nil, "", false
+	}
+
+	hash := string(line[index+len(hashPrefix) : len(line)-2])
+	if len(hash) != sha256.Size*2 {
+		return nil, "", false
+	}
+
+	body := append(append([]byte{}, line[:index]...), '}')
+
+	return body, hash, true
+}
+
+// Files returns the files of a rotating file which exist, oldest first, ending with the file itself.
+func Files(path string) ([]string, error) {
+	files := []string{}
+
+	for index := 1; ; index++ {
+		if _, err := os.Stat(BackupPath(path, index)); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+
+			return nil, fmt.Errorf("failed to stat audit file [%s]; %w", BackupPath(path, index), err)
+		}
+
+		files = append([]string{BackupPath(path, index)}, files...)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to stat audit file [%s]; %w", path, err)
+	}
+
+	return files, nil
+}
+
+// LastHead returns the head of the chain of audit records in a rotating file, so that the chain may be continued when
+// the file is reopened.  The newest file with a chained record is used.  A zero head is returned if there are none.
+func LastHead(path string) (Head, error) {
+	files, err := Files(path)
+	if err != nil {
+		return Head{}, err
+	}
+
+	for i := len(files) - 1; i >= 0; i-- {
+		head, found, err := lastHeadOf(files[i])
+		if err != nil || found {
+			return head, err
+		}
+	}
+
+	return Head{}, nil
+}
+
+// lastHeadOf returns the last chained record of a file, or false if it has none.
+func lastHeadOf(path string) (Head, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Head{}, false, fmt.Errorf("failed to open audit file [%s]; %w", path, err)
+	}
+	defer file.Close()
+
+	var (
+		head  Head
+		found bool
+	)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+
+	for scanner.Scan() {
+		body, hash, ok := splitHash(scanner.Bytes())
+		if !ok {
+			continue
+		}
+
+		record := Record{}
+		if err := json.Unmarshal(body, &record); err != nil {
+			continue
+		}
+
+		head, found = Head{Sequence: record.Sequence, Hash: hash, Time: record.Time}, true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return Head{}, false, fmt.Errorf("failed to read audit file [%s]; %w", path, err)
+	}
+
+	return head, found, nil
+}
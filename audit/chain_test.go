@@ -0,0 +1,239 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testChain returns the lines of a chain of records starting from a head.
+func testChain(t *testing.T, head Head, count int) []string {
+	t.Helper()
+
+	output := &bytes.Buffer{}
+	logger := NewChainedLogger(output, head)
+
+	for i := 0; i < count; i++ {
+		record := &Record{
+			Time:         time.Date(2024, 5, 1, 12, 0, i, 0, time.UTC),
+			UID:          fmt.Sprintf("uid-%d", i),
+			User:         User{Username: "alice"},
+			ChargedVCPUs: 4,
+			Outcome:      Outcome{Allowed: true, Code: 200, Message: "request success"},
+		}
+
+		if err := logger.Write(record); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	return strings.Split(strings.TrimSpace(output.String()), "\n")
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	t.Parallel()
+
+	lines := testChain(t, Head{}, 4)
+
+	// the records of a chain which continues from a rotated file, which is no longer available
+	rotated := testChain(t, Head{Sequence: 10, Hash: strings.Repeat("a", 64)}, 2)
+
+	tests := []struct {
+		name         string
+		lines        []string
+		checkpoint   *Head
+		wantFirst    uint64
+		wantProblems []string
+	}{
+		{
+			name:      "ensure an intact chain is verified",
+			lines:     lines,
+			wantFirst: 1,
+		},
+		{
+			name:       "ensure an intact chain is verified against its checkpoint",
+			lines:      lines,
+			checkpoint: &Head{Sequence: 3, Hash: hashOfLine(t, lines[2])},
+			wantFirst:  1,
+		},
+		{
+			name:      "ensure a chain which continues from rotated records is verified",
+			lines:     rotated,
+			wantFirst: 11,
+		},
+		{
+			name:         "ensure an edited record is detected",
+			lines:        []string{lines[0], strings.Replace(lines[1], `"allowed":true`, `"allowed":false`, 1), lines[2], lines[3]},
+			wantFirst:    1,
+			wantProblems: []string{"record was modified"},
+		},
+		{
+			name:         "ensure a deleted record is detected",
+			lines:        []string{lines[0], lines[2], lines[3]},
+			wantFirst:    1,
+			wantProblems: []string{"records are missing or out of order"},
+		},
+		{
+			name:         "ensure a deleted first record is detected",
+			lines:        lines[1:],
+			wantFirst:    2,
+			checkpoint:   &Head{Sequence: 1, Hash: hashOfLine(t, lines[0])},
+			wantProblems: []string{"the checkpointed record was deleted"},
+		},
+		{
+			name:         "ensure a record with a recomputed hash is detected",
+			lines:        []string{lines[0], rehash(t, strings.Replace(lines[1], `"allowed":true`, `"allowed":false`, 1)), lines[2]},
+			wantFirst:    1,
+			wantProblems: []string{"does not follow the record before it"},
+		},
+		{
+			name:         "ensure a record without a hash is detected",
+			lines:        []string{lines[0], `{"uid":"forged"}`},
+			wantFirst:    1,
+			wantProblems: []string{"record has no hash"},
+		},
+		{
+			name:         "ensure truncation beyond the checkpoint is detected",
+			lines:        lines[:2],
+			checkpoint:   &Head{Sequence: 3, Hash: hashOfLine(t, lines[2])},
+			wantFirst:    1,
+			wantProblems: []string{"the log was truncated"},
+		},
+		{
+			name:         "ensure a deleted log is detected",
+			checkpoint:   &Head{Sequence: 3, Hash: hashOfLine(t, lines[2])},
+			wantProblems: []string{"the log was deleted"},
+		},
+		{
+			name:         "ensure a record which does not match the checkpoint is detected",
+			lines:        lines,
+			checkpoint:   &Head{Sequence: 2, Hash: strings.Repeat("b", 64)},
+			wantFirst:    1,
+			wantProblems: []string{"does not match the checkpoint"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			verifier := NewVerifier(tt.checkpoint)
+
+			content := strings.Join(tt.lines, "\n")
+			if err := verifier.Verify("audit.jsonl", strings.NewReader(content)); err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+
+			got := verifier.Result()
+
+			if len(got.Problems) != len(tt.wantProblems) {
+				t.Fatalf("Result() problems = %v, want %v", got.Problems, tt.wantProblems)
+			}
+
+			for i := range tt.wantProblems {
+				if !strings.Contains(got.Problems[i], tt.wantProblems[i]) {
+					t.Errorf("Result() problem = %s, want %s", got.Problems[i], tt.wantProblems[i])
+				}
+			}
+
+			if got.First != tt.wantFirst {
+				t.Errorf("Result() first = %d, want %d", got.First, tt.wantFirst)
+			}
+		})
+	}
+}
+
+func TestLastHead(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	head, err := LastHead(path)
+	if err != nil || head != (Head{}) {
+		t.Fatalf("LastHead() = %+v, %v, want an empty head without a file", head, err)
+	}
+
+	// write enough records that the file is rotated, so that the chain spans files
+	file, err := NewRotatingFile(path, 600, 2)
+	if err != nil {
+		t.Fatalf("NewRotatingFile() error = %v", err)
+	}
+
+	logger := NewChainedLogger(file, Head{})
+	for i := 0; i < 5; i++ {
+		if err := logger.Write(&Record{UID: fmt.Sprintf("uid-%d", i)}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	written, _ := logger.Head()
+	logger.Close()
+
+	if head, err = LastHead(path); err != nil || head.Sequence != 5 || head.Hash != written.Hash {
+		t.Fatalf("LastHead() = %+v, %v, want %+v", head, err, written)
+	}
+
+	// continue the chain, as the webhook does when it restarts
+	file, err = NewRotatingFile(path, 600, 2)
+	if err != nil {
+		t.Fatalf("NewRotatingFile() error = %v", err)
+	}
+
+	logger = NewChainedLogger(file, head)
+	if err := logger.Write(&Record{UID: "uid-5"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	logger.Close()
+
+	files, err := Files(path)
+	if err != nil || len(files) < 2 {
+		t.Fatalf("Files() = %v, %v, want the rotated files", files, err)
+	}
+
+	verifier := NewVerifier(&head)
+	if err := verifier.VerifyFiles(files...); err != nil {
+		t.Fatalf("VerifyFiles() error = %v", err)
+	}
+
+	if result := verifier.Result(); !result.Intact() || result.Head.Sequence != 6 {
+		t.Errorf("Result() = %+v, want an intact chain of 6 records", result)
+	}
+
+	// remove the current file so that the newest backup holds the head
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("failed to remove file; %v", err)
+	}
+
+	if head, err = LastHead(path); err != nil || head.Sequence == 0 || head.Sequence == 6 {
+		t.Errorf("LastHead() = %+v, %v, want the head of the newest backup", head, err)
+	}
+}
+
+// hashOfLine returns the hash of an encoded record.
+func hashOfLine(t *testing.T, line string) string {
+	t.Helper()
+
+	_, hash, ok := splitHash([]byte(line))
+	if !ok {
+		t.Fatalf("record has no hash: %s", line)
+	}
+
+	return hash
+}
+
+// rehash recomputes the hash of an encoded record, as someone editing it would.
+func rehash(t *testing.T, line string) string {
+	t.Helper()
+
+	body, _, ok := splitHash([]byte(line))
+	if !ok {
+		t.Fatalf("record has no hash: %s", line)
+	}
+
+	return string(body[:len(body)-1]) + hashPrefix + hashOf(body) + `"}`
+}
@@ -0,0 +1,147 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a file which is rotated once it reaches a maximum size.  Rotated files are kept alongside the file
+// with a numbered suffix, where .1 is the most recent, up to a maximum number of backups.
+type RotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFile returns a new instance of a rotating file, appending to the file if it already exists.
+func NewRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("invalid maximum size [%d]; must be a positive number", maxBytes)
+	}
+
+	if maxBackups < 0 {
+		return nil, fmt.Errorf("invalid maximum backups [%d]; must be a non-negative number", maxBackups)
+	}
+
+	f := &RotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Path returns the path of the file.
+func (f *RotatingFile) Path() string {
+	return f.path
+}
+
+// Write writes data to the file, rotating the file first if the data would take it beyond its maximum size.  Data is
+// never split across files, so a single write which is larger than the maximum size is written to a file on its own.
+func (f *RotatingFile) Write(data []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// the file is not open if it could not be reopened when it was last rotated
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if f.size > 0 && f.size+int64(len(data)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	written, err := f.file.Write(data)
+	f.size += int64(written)
+
+	return written, err
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	return f.file.Close()
+}
+
+// open opens the file for appending.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file [%s]; %w", f.path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+
+		return fmt.Errorf("failed to stat audit file [%s]; %w", f.path, err)
+	}
+
+	f.file, f.size = file, info.Size()
+
+	return nil
+}
+
+// rotate closes the file, shifts each backup along by one, removing the oldest, and opens a new file.  If the backups
+// cannot be shifted, the file is reopened so that later writes may still be made and rotate again.
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+
+	if err != nil {
+		err = fmt.Errorf("failed to close audit file [%s]; %w", f.path, err)
+	} else {
+		err = f.shift()
+	}
+
+	if openErr := f.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+
+	return err
+}
+
+// shift shifts each backup along by one, removing the oldest, or removes the file if backups are not kept.
+func (f *RotatingFile) shift() error {
+	if f.maxBackups == 0 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove audit file [%s]; %w", f.path, err)
+		}
+
+		return nil
+	}
+
+	for i := f.maxBackups - 1; i >= 0; i-- {
+		if err := os.Rename(BackupPath(f.path, i), BackupPath(f.path, i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to rotate audit file [%s]; %w", f.path, err)
+		}
+	}
+
+	return nil
+}
+
+// BackupPath returns the path of a numbered backup of a rotating file, or the path of the file itself for zero.
+func BackupPath(path string, index int) string {
+	if index == 0 {
+		return path
+	}
+
+	return fmt.Sprintf("%s.%d", path, index)
+}
@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile_Write(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		maxBackups int
+		writes     []string
+		want       []string
+	}{
+		{
+			name:       "ensure writes within the maximum size are not rotated",
+			maxBackups: 2,
+			writes:     []string{"aaaa\n", "bbbb\n"},
+			want:       []string{"aaaa\nbbbb\n"},
+		},
+		{
+			name:       "ensure the file is rotated once it would exceed the maximum size",
+			maxBackups: 2,
+			writes:     []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n"},
+			want:       []string{"eeee\n", "cccc\ndddd\n", "aaaa\nbbbb\n"},
+		},
+		{
+			name:       "ensure the oldest backups are removed",
+			maxBackups: 1,
+			writes:     []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n"},
+			want:       []string{"eeee\n", "cccc\ndddd\n"},
+		},
+		{
+			name:       "ensure no backups are kept when disabled",
+			maxBackups: 0,
+			writes:     []string{"aaaa\n", "bbbb\n", "cccc\n"},
+			want:       []string{"cccc\n"},
+		},
+		{
+			name:       "ensure a write larger than the maximum size is not split",
+			maxBackups: 1,
+			writes:     []string{"aaaa\n", "bbbbbbbbbbbbbbb\n"},
+			want:       []string{"bbbbbbbbbbbbbbb\n", "aaaa\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+			f, err := NewRotatingFile(path, 10, tt.maxBackups)
+			if err != nil {
+				t.Fatalf("NewRotatingFile() error = %v", err)
+			}
+
+			for _, data := range tt.writes {
+				if _, err := f.Write([]byte(data)); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			for i, want := range tt.want {
+				got, err := os.ReadFile(BackupPath(path, i))
+				if err != nil {
+					t.Fatalf("failed to read [%s]; %v", BackupPath(path, i), err)
+				}
+
+				if string(got) != want {
+					t.Errorf("file %d = %q, want %q", i, got, want)
+				}
+			}
+
+			if _, err := os.Stat(BackupPath(path, len(tt.want))); err == nil {
+				t.Errorf("backup %d exists, want at most %d files", len(tt.want), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestRotatingFile_rotateFailure(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	f, err := NewRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatalf("NewRotatingFile() error = %v", err)
+	}
+	defer f.Close()
+
+	// a directory in place of the backup fails the rotation
+	if err := os.MkdirAll(filepath.Join(BackupPath(path, 1), "blocked"), 0o700); err != nil {
+		t.Fatalf("failed to create directory; %v", err)
+	}
+
+	if _, err := f.Write([]byte("aaaa\nbbbb\n")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if _, err := f.Write([]byte("cccc\n")); err == nil {
+		t.Fatalf("Write() error = nil, want the rotation to fail")
+	}
+
+	// once the rotation may succeed, the file is still open and is rotated by the next write
+	if err := os.RemoveAll(BackupPath(path, 1)); err != nil {
+		t.Fatalf("failed to remove directory; %v", err)
+	}
+
+	if _, err := f.Write([]byte("dddd\n")); err != nil {
+		t.Fatalf("Write() error = %v, want the file to be reopened after a failed rotation", err)
+	}
+
+	for i, want := range []string{"dddd\n", "aaaa\nbbbb\n"} {
+		got, err := os.ReadFile(BackupPath(path, i))
+		if err != nil {
+			t.Fatalf("failed to read [%s]; %v", BackupPath(path, i), err)
+		}
+
+		if string(got) != want {
+			t.Errorf("file %d = %q, want %q", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Verification represents the result of verifying a chain of audit records.
+type Verification struct {
+	// Records is the number of records which were verified.
+	Records int `json:"records"`
+
+	// First is the sequence of the first record.  It is greater than 1 if older records were rotated away.
+	First uint64 `json:"first"`
+
+	// Head is the last record of the chain.
+	Head Head `json:"head"`
+
+	// Checkpoint is the checkpoint that the chain was verified against, if any.
+	Checkpoint *Head `json:"checkpoint,omitempty"`
+
+	// Problems are the edits, deletions and truncations which were found.  The chain is intact if there are none.
+	Problems []string `json:"problems"`
+}
+
+// Intact returns if no problems were found with the chain.
+func (v *Verification) Intact() bool {
+	return len(v.Problems) == 0
+}
+
+// Verifier verifies a chain of audit records which may be spread across several files.
+type Verifier struct {
+	result     Verification
+	checkpoint *Head
+	previous   *Head
+}
+
+// NewVerifier returns a new instance of a verifier which verifies that each record is unmodified and follows the record
+// before it.  When a checkpoint of the chain is given, the chain must also contain the checkpointed record, so that a
+// truncated or deleted log is detected.
+func NewVerifier(checkpoint *Head) *Verifier {
+	return &Verifier{checkpoint: checkpoint, result: Verification{Checkpoint: checkpoint, Problems: []string{}}}
+}
+
+// VerifyFiles verifies the chain of audit records in a list of files, oldest first.
+func (v *Verifier) VerifyFiles(files ...string) error {
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open audit file [%s]; %w", path, err)
+		}
+
+		err = v.Verify(path, file)
+		file.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Verify verifies the records read from a reader, continuing the chain of any records which were verified before them.
+// The name identifies the reader in any problems.
+func (v *Verifier) Verify(name string, reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		v.verifyRecord(fmt.Sprintf("%s:%d", name, line), scanner.Bytes())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read [%s]; %w", name, err)
+	}
+
+	return nil
+}
+
+// verifyRecord verifies a single encoded record against the record before it.
+func (v *Verifier) verifyRecord(location string, line []byte) {
+	body, hash, ok := splitHash(line)
+	if !ok {
+		v.problem("%s: record has no hash", location)
+
+		return
+	}
+
+	if actual := hashOf(body); actual != hash {
+		v.problem("%s: record was modified; hash [%s] does not match its contents [%s]", location, hash, actual)
+	}
+
+	record := Record{}
+	if err := json.Unmarshal(body, &record); err != nil {
+		v.problem("%s: record could not be decoded; %v", location, err)
+
+		return
+	}
+
+	switch {
+	case v.previous == nil && record.PrevHash == "" && record.Sequence != 1:
+		v.problem("%s: record [%d] has no previous hash but is not the first record", location, record.Sequence)
+	case v.previous == nil:
+		v.result.First = record.Sequence
+	case record.Sequence != v.previous.Sequence+1:
+		v.problem("%s: record [%d] follows record [%d]; records are missing or out of order",
+			location, record.Sequence, v.previous.Sequence)
+	case record.PrevHash != v.previous.Hash:
+		v.problem("%s: record [%d] does not follow the record before it; previous hash [%s] does not match [%s]",
+			location, record.Sequence, record.PrevHash, v.previous.Hash)
+	}
+
+	if v.checkpoint != nil && record.Sequence == v.checkpoint.Sequence && hash != v.checkpoint.Hash {
+		v.problem("%s: record [%d] does not match the checkpoint; hash [%s] does not match [%s]",
+			location, record.Sequence, hash, v.checkpoint.Hash)
+	}
+
+	v.previous = &Head{Sequence: record.Sequence, Hash: hash, Time: record.Time}
+	v.result.Records++
+	v.result.Head = *v.previous
+}
+
+// Result returns the result of verifying every record so far, including whether the checkpointed record was found.
+func (v *Verifier) Result() *Verification {
+	result := v.result
+	result.Problems = append([]string{}, v.result.Problems...)
+
+	if v.checkpoint == nil {
+		return &result
+	}
+
+	switch {
+	case result.Records == 0:
+		result.Problems = append(result.Problems, fmt.Sprintf(
+			"log is empty but was checkpointed at record [%d]; the log was deleted", v.checkpoint.Sequence))
+	case v.checkpoint.Sequence > result.Head.Sequence:
+		result.Problems = append(result.Problems, fmt.Sprintf(
+			"log ends at record [%d] but was checkpointed at record [%d]; the log was truncated",
+			result.Head.Sequence, v.checkpoint.Sequence))
+	case v.checkpoint.Sequence < result.First:
+		result.Problems = append(result.Problems, fmt.Sprintf(
+			"log starts at record [%d] after the checkpointed record [%d]; the checkpointed record was deleted",
+			result.First, v.checkpoint.Sequence))
+	}
+
+	return &result
+}
+
+// problem records a problem with the chain.
+func (v *Verifier) problem(format string, args ...any) {
+	v.result.Problems = append(v.result.Problems, fmt.Sprintf(format, args...))
+}
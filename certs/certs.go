@@ -0,0 +1,257 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	// secret data keys.  the server keys match the standard kubernetes.io/tls secret keys.
+	SecretKeyCACert     = "ca.crt"
+	SecretKeyCAKey      = "ca.key"
+	SecretKeyServerCert = "tls.crt"
+	SecretKeyServerKey  = "tls.key"
+
+	caCommonName = "windows-overcommit-webhook-ca"
+)
+
+// bundle represents the full set of certificates used by the webhook.  The CA certificate may contain multiple
+// PEM encoded certificates during a CA rotation, with the first certificate being the active signing certificate.
+type bundle struct {
+	caCert     []byte
+	caKey      []byte
+	serverCert []byte
+	serverKey  []byte
+}
+
+// newBundleFromData returns a bundle object from the data stored in a secret.
+func newBundleFromData(data map[string][]byte) *bundle {
+	return &bundle{
+		caCert:     data[SecretKeyCACert],
+		caKey:      data[SecretKeyCAKey],
+		serverCert: data[SecretKeyServerCert],
+		serverKey:  data[SecretKeyServerKey],
+	}
+}
+
+// data returns the bundle as data which may be stored in a secret.
+func (b *bundle) data() map[string][]byte {
+	return map[string][]byte{
+		SecretKeyCACert:     b.caCert,
+		SecretKeyCAKey:      b.caKey,
+		SecretKeyServerCert: b.serverCert,
+		SecretKeyServerKey:  b.serverKey,
+	}
+}
+
+// keyPair returns the server key pair from the bundle.
+func (b *bundle) keyPair() (*tls.Certificate, error) {
+	keyPair, err := tls.X509KeyPair(b.serverCert, b.serverKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse server key pair; %w", err)
+	}
+
+	return &keyPair, nil
+}
+
+// signer returns the active signing certificate and key for the certificate authority.
+func (b *bundle) signer() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	caCerts, err := parseCertificates(b.caCert)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse ca certificate; %w", err)
+	}
+
+	block, _ := pem.Decode(b.caKey)
+	if block == nil {
+		return nil, nil, errors.New("failed to decode ca key")
+	}
+
+	caKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse ca key; %w", err)
+	}
+
+	return caCerts[0], caKey, nil
+}
+
+// validate ensures that the bundle is complete, that the server certificate is signed by the certificate
+// authority and that it is valid for each of the requested dns names.  It returns the expiration time of both the
+// active certificate authority and the server certificate.
+func (b *bundle) validate(dnsNames []string, now time.Time) (caNotAfter, serverNotAfter time.Time, err error) {
+	caCert, _, err := b.signer()
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	keyPair, err := b.keyPair()
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	serverCert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to parse server certificate; %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	for _, dnsName := range dnsNames {
+		if _, err := serverCert.Verify(x509.VerifyOptions{
+			DNSName:     dnsName,
+			Roots:       pool,
+			CurrentTime: now,
+		}); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("server certificate invalid for [%s]; %w", dnsName, err)
+		}
+	}
+
+	return caCert.NotAfter, serverCert.NotAfter, nil
+}
+
+// generateCA generates a new certificate authority.  The existing CA bundle is passed so that any certificate
+// authorities which have not yet expired are retained in the bundle, allowing clients which have cached the previous
+// bundle to continue to trust the webhook throughout the rotation.
+func generateCA(existing []byte, validity time.Duration, now time.Time) (caCert, caKey []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate ca key; %w", err)
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: caCommonName},
+		NotBefore:             now.Add(-1 * time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create ca certificate; %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal ca key; %w", err)
+	}
+
+	// the new certificate authority is always first so that it is used as the signer
+	var caBuffer bytes.Buffer
+	if err := pem.Encode(&caBuffer, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode ca certificate; %w", err)
+	}
+
+	// retain the unexpired certificate authorities from the existing bundle
+	previous, _ := parseCertificates(existing)
+	for _, cert := range previous {
+		if cert.NotAfter.Before(now) {
+			continue
+		}
+
+		if err := pem.Encode(&caBuffer, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+			return nil, nil, fmt.Errorf("failed to encode previous ca certificate; %w", err)
+		}
+	}
+
+	return caBuffer.Bytes(), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// generateServerCert generates a new server certificate signed by the active certificate authority of the bundle.
+func generateServerCert(b *bundle, dnsNames []string, validity time.Duration, now time.Time) (serverCert, serverKey []byte, err error) {
+	caCert, caKey, err := b.signer()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate server key; %w", err)
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// never outlive the certificate authority that signed us
+	notAfter := now.Add(validity)
+	if caCert.NotAfter.Before(notAfter) {
+		notAfter = caCert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-1 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create server certificate; %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal server key; %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil
+}
+
+// parseCertificates parses all PEM encoded certificates from a set of bytes.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+
+	return certs, nil
+}
+
+// serialNumber returns a random serial number for a certificate.
+func serialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number; %w", err)
+	}
+
+	return serial, nil
+}
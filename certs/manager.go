@@ -0,0 +1,508 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/clients"
+)
+
+const (
+	EnvServiceName       = "WEBHOOK_SERVICE_NAME"
+	EnvSecretName        = "WEBHOOK_CERT_SECRET_NAME"
+	EnvWebhookConfigName = "WEBHOOK_CONFIGURATION_NAME"
+
+	DefaultServiceName       = "windows-overcommit-webhook"
+	DefaultSecretName        = "webhook-certs"
+	DefaultWebhookConfigName = "windows-overcommit-webhook"
+
+	caValidity     = 5 * 365 * 24 * time.Hour
+	serverValidity = 365 * 24 * time.Hour
+
+	// rotateBefore is the amount of time prior to expiration of the server certificate that it is rotated.  The
+	// certificate authority is rotated at twice this amount, which also rotates the server certificate, so that it is
+	// replaced well before it expires.  A new certificate authority signs a new server certificate in the same pass,
+	// so the ca bundle is patched with it, alongside the previous certificate authority, before either is stored for
+	// the replicas to serve.
+	rotateBefore = 30 * 24 * time.Hour
+
+	// checkInterval is how often the leader checks the certificates for rotation and ensures the ca bundle.
+	checkInterval = 1 * time.Hour
+
+	// reloadInterval is how often each replica reloads the certificates from the secret.
+	reloadInterval = 1 * time.Minute
+
+	// bootstrapInterval is how often each replica checks the secret for certificates while it waits for the leader
+	// to store them on startup.
+	bootstrapInterval = 1 * time.Second
+
+	leaseName          = "windows-overcommit-webhook-certs"
+	leaseDuration      = 15 * time.Second
+	leaseRenewDeadline = 10 * time.Second
+	leaseRetryPeriod   = 2 * time.Second
+)
+
+// Manager manages the certificates used by the webhook.  It generates or loads the certificate authority and server
+// certificate from a secret, keeps the ca bundle of the webhook configuration up-to-date and rotates the certificates
+// prior to their expiration.  Only the elected leader among the webhook replicas writes to the secret and the webhook
+// configuration while all replicas reload the certificates from the secret.
+type Manager struct {
+	client   kubernetes.Interface
+	logger   zerolog.Logger
+	identity string
+
+	namespace         string
+	secretName        string
+	webhookConfigName string
+	dnsNames          []string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+}
+
+// NewManager returns a new instance of a certificate manager object with sane defaults.
+func NewManager(client kubernetes.Interface, logger zerolog.Logger) *Manager {
+	namespace := clients.Namespace()
+	service := envOrDefault(EnvServiceName, DefaultServiceName)
+
+	return &Manager{
+		client:            client,
+		logger:            logger,
+		identity:          clients.Identity(),
+		namespace:         namespace,
+		secretName:        envOrDefault(EnvSecretName, DefaultSecretName),
+		webhookConfigName: envOrDefault(EnvWebhookConfigName, DefaultWebhookConfigName),
+		dnsNames: []string{
+			fmt.Sprintf("%s.%s.svc", service, namespace),
+			service,
+			fmt.Sprintf("%s.%s", service, namespace),
+			fmt.Sprintf("%s.%s.svc.cluster.local", service, namespace),
+		},
+	}
+}
+
+// Start starts the background processes which are responsible for rotating and reloading the certificates, and
+// loads the certificates once they are available.  Only the leader generates the certificates when they do not yet
+// exist, so that replicas starting together do not each generate and distribute their own certificate authority.  It
+// returns once a serving certificate is available.
+func (m *Manager) Start(ctx context.Context) error {
+	go m.leaderLoop(ctx)
+
+	b, err := m.waitForBundle(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to bootstrap certificates; %w", err)
+	}
+
+	if err := m.set(b); err != nil {
+		return err
+	}
+
+	go m.reloadLoop(ctx)
+
+	return nil
+}
+
+// waitForBundle waits until the secret contains a valid certificate bundle, either because it already exists or
+// because the leader has stored it, and returns it.
+func (m *Manager) waitForBundle(ctx context.Context) (*bundle, error) {
+	ticker := time.NewTicker(bootstrapInterval)
+	defer ticker.Stop()
+
+	for {
+		b, err := m.load(ctx)
+		if err == nil && m.valid(b) {
+			return b, nil
+		}
+
+		m.logger.Info().Str("secret", m.secretName).Msg("waiting for the leader to store certificates")
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetCertificate returns the current server certificate.  It is used to satisfy the tls.Config GetCertificate
+// function so that rotated certificates are served without restarting the server.
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.certificate == nil {
+		return nil, errors.New("certificate has not been loaded")
+	}
+
+	return m.certificate, nil
+}
+
+// Ready returns an error if the manager does not currently have a valid server certificate to serve.
+func (m *Manager) Ready() error {
+	certificate, err := m.GetCertificate(nil)
+	if err != nil {
+		return err
+	}
+
+	leaf := certificate.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse server certificate; %w", err)
+		}
+	}
+
+	now := time.Now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return fmt.Errorf("server certificate is not valid between [%s] and [%s]", leaf.NotBefore, leaf.NotAfter)
+	}
+
+	return nil
+}
+
+// load loads the certificate bundle from the secret.
+func (m *Manager) load(ctx context.Context) (*bundle, error) {
+	secret, err := m.client.CoreV1().Secrets(m.namespace).Get(ctx, m.secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret [%s/%s]; %w", m.namespace, m.secretName, err)
+	}
+
+	return newBundleFromData(secret.Data), nil
+}
+
+// set sets the current certificate bundle as the served certificate.
+func (m *Manager) set(b *bundle) error {
+	keyPair, err := b.keyPair()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.certificate = keyPair
+
+	return nil
+}
+
+// valid returns if a certificate bundle is currently usable for serving.
+func (m *Manager) valid(b *bundle) bool {
+	_, _, err := b.validate(m.dnsNames, time.Now())
+
+	return err == nil
+}
+
+// reconcile ensures that the secret contains a valid certificate bundle which does not need rotation, generating
+// and storing a new certificate authority and/or server certificate as needed.
+func (m *Manager) reconcile(ctx context.Context) (*bundle, error) {
+	var result *bundle
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := m.client.CoreV1().Secrets(m.namespace).Get(ctx, m.secretName, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get secret [%s/%s]; %w", m.namespace, m.secretName, err)
+		}
+
+		exists := err == nil
+		if !exists {
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      m.secretName,
+					Namespace: m.namespace,
+				},
+				Type: corev1.SecretTypeTLS,
+			}
+		}
+
+		current := newBundleFromData(secret.Data)
+
+		desired, rotated, err := m.rotate(current, time.Now())
+		if err != nil {
+			return err
+		}
+
+		result = desired
+
+		if !rotated {
+			return nil
+		}
+
+		// distribute a new certificate authority before storing it so that the webhook configuration already
+		// trusts it by the time any replica serves a certificate signed by it
+		if !bytes.Equal(current.caCert, desired.caCert) {
+			if err := m.patchCABundle(ctx, desired.caCert); err != nil {
+				return err
+			}
+		}
+
+		secret.Data = desired.data()
+
+		if !exists {
+			_, err = m.client.CoreV1().Secrets(m.namespace).Create(ctx, secret, metav1.CreateOptions{})
+
+			// a previous leader beat us to it; treat it as a conflict so that we reload the secret
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("secrets"), m.secretName, err)
+			}
+		} else {
+			_, err = m.client.CoreV1().Secrets(m.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		}
+
+		if err != nil {
+			return err
+		}
+
+		m.logger.Info().Str("secret", m.secretName).Msg("stored rotated certificates")
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile secret [%s/%s]; %w", m.namespace, m.secretName, err)
+	}
+
+	return result, nil
+}
+
+// rotate returns the desired certificate bundle given the current bundle, and whether the desired bundle differs from
+// the current bundle.
+func (m *Manager) rotate(current *bundle, now time.Time) (*bundle, bool, error) {
+	caNotAfter, serverNotAfter, err := current.validate(m.dnsNames, now)
+
+	rotateCA := err != nil || caNotAfter.Sub(now) < 2*rotateBefore
+	rotateServer := rotateCA || serverNotAfter.Sub(now) < rotateBefore
+
+	if !rotateServer {
+		return current, false, nil
+	}
+
+	desired := &bundle{caCert: current.caCert, caKey: current.caKey}
+
+	if rotateCA {
+		m.logger.Info().Msg("generating certificate authority")
+
+		if desired.caCert, desired.caKey, err = generateCA(current.caCert, caValidity, now); err != nil {
+			return nil, false, err
+		}
+	}
+
+	m.logger.Info().Msg("generating server certificate")
+
+	if desired.serverCert, desired.serverKey, err = generateServerCert(desired, m.dnsNames, serverValidity, now); err != nil {
+		return nil, false, err
+	}
+
+	return desired, true, nil
+}
+
+// patchCABundle ensures that each webhook in the validating and mutating webhook configurations trusts the given
+// ca bundle.
+func (m *Manager) patchCABundle(ctx context.Context, caBundle []byte) error {
+	if err := m.patchValidatingCABundle(ctx, caBundle); err != nil {
+		return err
+	}
+
+	return m.patchMutatingCABundle(ctx, caBundle)
+}
+
+// patchValidatingCABundle ensures that each webhook in the validating webhook configuration trusts the given
+// ca bundle.
+func (m *Manager) patchValidatingCABundle(ctx context.Context, caBundle []byte) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		webhooks := m.client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+
+		config, err := webhooks.Get(ctx, m.webhookConfigName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			m.logger.Warn().Str("configuration", m.webhookConfigName).Msg("validating webhook configuration not found; skipping ca bundle")
+
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to get validating webhook configuration [%s]; %w", m.webhookConfigName, err)
+		}
+
+		var changed bool
+
+		for i := range config.Webhooks {
+			changed = setCABundle(&config.Webhooks[i].ClientConfig, caBundle) || changed
+		}
+
+		if !changed {
+			return nil
+		}
+
+		if _, err := webhooks.Update(ctx, config, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+
+		m.logger.Info().Str("configuration", m.webhookConfigName).Msg("patched validating webhook configuration ca bundle")
+
+		return nil
+	})
+}
+
+// patchMutatingCABundle ensures that each webhook in the mutating webhook configuration trusts the given
+// ca bundle.
+func (m *Manager) patchMutatingCABundle(ctx context.Context, caBundle []byte) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		webhooks := m.client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+
+		config, err := webhooks.Get(ctx, m.webhookConfigName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			m.logger.Warn().Str("configuration", m.webhookConfigName).Msg("mutating webhook configuration not found; skipping ca bundle")
+
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to get mutating webhook configuration [%s]; %w", m.webhookConfigName, err)
+		}
+
+		var changed bool
+
+		for i := range config.Webhooks {
+			changed = setCABundle(&config.Webhooks[i].ClientConfig, caBundle) || changed
+		}
+
+		if !changed {
+			return nil
+		}
+
+		if _, err := webhooks.Update(ctx, config, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+
+		m.logger.Info().Str("configuration", m.webhookConfigName).Msg("patched mutating webhook configuration ca bundle")
+
+		return nil
+	})
+}
+
+// setCABundle sets the ca bundle of a webhook client config, returning if it was changed.
+func setCABundle(clientConfig *admissionregistrationv1.WebhookClientConfig, caBundle []byte) bool {
+	if bytes.Equal(clientConfig.CABundle, caBundle) {
+		return false
+	}
+
+	clientConfig.CABundle = caBundle
+
+	return true
+}
+
+// reloadLoop periodically reloads the certificates from the secret so that each replica serves the certificates
+// which were rotated by the leader.
+func (m *Manager) reloadLoop(ctx context.Context) {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b, err := m.load(ctx)
+			if err != nil {
+				m.logger.Error().Err(err).Msg("failed to reload certificates")
+
+				continue
+			}
+
+			if !m.valid(b) {
+				m.logger.Error().Str("secret", m.secretName).Msg("refusing to reload invalid certificates")
+
+				continue
+			}
+
+			if err := m.set(b); err != nil {
+				m.logger.Error().Err(err).Msg("failed to set certificates")
+			}
+		}
+	}
+}
+
+// leaderLoop participates in leader election with the other replicas until the context is cancelled.  While leading,
+// it rotates the certificates and keeps the webhook configuration ca bundle up-to-date.
+func (m *Manager) leaderLoop(ctx context.Context) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: m.namespace,
+		},
+		Client:     m.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: m.identity},
+	}
+
+	// run the election again after losing the lease, until we are told to stop
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   leaseRenewDeadline,
+			RetryPeriod:     leaseRetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            leaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: m.lead,
+				OnStoppedLeading: func() {
+					m.logger.Info().Str("identity", m.identity).Msg("stopped leading certificate management")
+				},
+			},
+		})
+	}
+}
+
+// lead runs the certificate management duties of the leader until the context is cancelled.
+func (m *Manager) lead(ctx context.Context) {
+	m.logger.Info().Str("identity", m.identity).Msg("started leading certificate management")
+
+	for {
+		interval := checkInterval
+
+		b, err := m.reconcile(ctx)
+		if err == nil {
+			err = m.set(b)
+		}
+
+		if err == nil {
+			err = m.patchCABundle(ctx, b.caCert)
+		}
+
+		// retry sooner on failure
+		if err != nil {
+			m.logger.Error().Err(err).Msg("failed to manage certificates")
+
+			interval = reloadInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// envOrDefault returns the value of an environment variable or a default value if it is unset.
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
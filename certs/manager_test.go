@@ -0,0 +1,238 @@
+package certs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/clients"
+)
+
+func TestManager_reconcile(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset(
+		&admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultWebhookConfigName},
+			Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "test"}},
+		},
+		&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultWebhookConfigName},
+			Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "test"}},
+		},
+	)
+
+	m := NewManager(client, zerolog.Nop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("Manager.Start() error = %v", err)
+	}
+
+	if _, err := m.GetCertificate(nil); err != nil {
+		t.Fatalf("Manager.GetCertificate() error = %v", err)
+	}
+
+	if err := m.Ready(); err != nil {
+		t.Fatalf("Manager.Ready() error = %v", err)
+	}
+
+	first, err := m.load(ctx)
+	if err != nil {
+		t.Fatalf("Manager.load() error = %v", err)
+	}
+
+	// ensure the ca bundle was distributed to the webhook configuration
+	config, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, DefaultWebhookConfigName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get webhook configuration; %v", err)
+	}
+
+	if string(config.Webhooks[0].ClientConfig.CABundle) != string(first.caCert) {
+		t.Errorf("validating webhook configuration ca bundle does not match the generated certificate authority")
+	}
+
+	mutating, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, DefaultWebhookConfigName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get mutating webhook configuration; %v", err)
+	}
+
+	if string(mutating.Webhooks[0].ClientConfig.CABundle) != string(first.caCert) {
+		t.Errorf("mutating webhook configuration ca bundle does not match the generated certificate authority")
+	}
+
+	// ensure a valid bundle is not rotated
+	second, err := m.reconcile(ctx)
+	if err != nil {
+		t.Fatalf("Manager.reconcile() error = %v", err)
+	}
+
+	if string(second.serverCert) != string(first.serverCert) {
+		t.Errorf("Manager.reconcile() rotated a valid server certificate")
+	}
+}
+
+func TestManager_Start_follower(t *testing.T) {
+	t.Parallel()
+
+	holder, duration, now := "other-replica", int32(3600), metav1.NewMicroTime(time.Now())
+
+	client := fake.NewSimpleClientset(
+		&admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultWebhookConfigName},
+			Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "test"}},
+		},
+		&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: leaseName, Namespace: clients.Namespace()},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		},
+	)
+
+	m := NewManager(client, zerolog.Nop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan error, 1)
+	go func() { started <- m.Start(ctx) }()
+
+	// ensure a replica which does not hold the lease neither generates certificates nor distributes a ca bundle
+	time.Sleep(2 * bootstrapInterval)
+
+	select {
+	case err := <-started:
+		t.Fatalf("Manager.Start() returned before the certificates were stored; error = %v", err)
+	default:
+	}
+
+	if _, err := m.load(ctx); err == nil {
+		t.Errorf("Manager.Start() stored certificates without holding the lease")
+	}
+
+	config, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, DefaultWebhookConfigName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get webhook configuration; %v", err)
+	}
+
+	if len(config.Webhooks[0].ClientConfig.CABundle) != 0 {
+		t.Errorf("Manager.Start() patched the ca bundle without holding the lease")
+	}
+
+	// ensure the replica serves the certificates once the leader has stored them
+	stored, _, err := m.rotate(&bundle{}, time.Now())
+	if err != nil {
+		t.Fatalf("Manager.rotate() error = %v", err)
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: m.secretName, Namespace: m.namespace}, Data: stored.data()}
+	if _, err := client.CoreV1().Secrets(m.namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create secret; %v", err)
+	}
+
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("Manager.Start() error = %v", err)
+		}
+	case <-time.After(5 * bootstrapInterval):
+		t.Fatalf("Manager.Start() did not return once the certificates were stored")
+	}
+
+	certificate, err := m.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("Manager.GetCertificate() error = %v", err)
+	}
+
+	if keyPair, _ := stored.keyPair(); string(certificate.Certificate[0]) != string(keyPair.Certificate[0]) {
+		t.Errorf("Manager.GetCertificate() did not return the stored certificate")
+	}
+}
+
+func TestManager_rotate(t *testing.T) {
+	t.Parallel()
+
+	m := NewManager(fake.NewSimpleClientset(), zerolog.Nop())
+	now := time.Now()
+
+	initial, rotated, err := m.rotate(&bundle{}, now)
+	if err != nil || !rotated {
+		t.Fatalf("Manager.rotate() rotated = %v, error = %v; want rotated empty bundle", rotated, err)
+	}
+
+	tests := []struct {
+		name          string
+		now           time.Time
+		wantRotated   bool
+		wantRotatedCA bool
+	}{
+		{
+			name:        "ensure a fresh bundle is not rotated",
+			now:         now,
+			wantRotated: false,
+		},
+		{
+			name:        "ensure a server certificate near expiration is rotated",
+			now:         now.Add(serverValidity - rotateBefore + time.Hour),
+			wantRotated: true,
+		},
+		{
+			name:          "ensure a certificate authority near expiration is rotated",
+			now:           now.Add(caValidity - 2*rotateBefore + time.Hour),
+			wantRotated:   true,
+			wantRotatedCA: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// validate relative to the start time of the server certificate for the certificate authority test
+			current := initial
+			if tt.wantRotatedCA {
+				current = &bundle{caCert: initial.caCert, caKey: initial.caKey}
+				current.serverCert, current.serverKey, _ = generateServerCert(current, m.dnsNames, serverValidity, tt.now)
+			}
+
+			desired, rotated, err := m.rotate(current, tt.now)
+			if err != nil {
+				t.Fatalf("Manager.rotate() error = %v", err)
+			}
+
+			if rotated != tt.wantRotated {
+				t.Errorf("Manager.rotate() rotated = %v, want %v", rotated, tt.wantRotated)
+			}
+
+			if gotRotatedCA := string(desired.caKey) != string(current.caKey); gotRotatedCA != tt.wantRotatedCA {
+				t.Errorf("Manager.rotate() rotated ca = %v, want %v", gotRotatedCA, tt.wantRotatedCA)
+			}
+
+			// a rotated certificate authority must retain the previous certificate authority in the bundle
+			if tt.wantRotatedCA {
+				certs, err := parseCertificates(desired.caCert)
+				if err != nil || len(certs) != 2 {
+					t.Errorf("Manager.rotate() ca bundle has %d certificates, want 2", len(certs))
+				}
+			}
+
+			if _, _, err := desired.validate(m.dnsNames, tt.now); err != nil {
+				t.Errorf("Manager.rotate() produced invalid bundle; %v", err)
+			}
+		})
+	}
+}
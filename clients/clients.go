@@ -0,0 +1,73 @@
+package clients
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"kubevirt.io/client-go/kubecli"
+)
+
+const (
+	EnvNamespace = "POD_NAMESPACE"
+	EnvPodName   = "POD_NAME"
+
+	DefaultNamespace = "windows-overcommit-webhook"
+)
+
+// Clients represents the set of clients used to communicate with the cluster.
+type Clients struct {
+	KubeClient *kubernetes.Clientset
+	VirtClient kubecli.KubevirtClient
+}
+
+// NewInCluster returns a new set of clients using the in-cluster configuration.
+func NewInCluster() (*Clients, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create in-cluster config; %w", err)
+	}
+
+	return NewForConfig(config)
+}
+
+// NewForConfig returns a new set of clients using the given configuration.
+func NewForConfig(config *rest.Config) (*Clients, error) {
+	// create the kubernetes client alongside the virtualization client
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client; %w", err)
+	}
+
+	virtClient, err := kubecli.GetKubevirtClientFromRESTConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubevirt client; %w", err)
+	}
+
+	return &Clients{
+		KubeClient: kubeClient,
+		VirtClient: virtClient,
+	}, nil
+}
+
+// Namespace returns the namespace that we are running in.
+func Namespace() string {
+	if namespace := os.Getenv(EnvNamespace); namespace != "" {
+		return namespace
+	}
+
+	return DefaultNamespace
+}
+
+// Identity returns a unique identity for this process, used for leader election.  The pod name is used when
+// available, falling back to the hostname.
+func Identity() string {
+	if identity := os.Getenv(EnvPodName); identity != "" {
+		return identity
+	}
+
+	identity, _ := os.Hostname()
+
+	return identity
+}
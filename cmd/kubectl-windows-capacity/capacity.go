@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/clients"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+// capacityReport represents the licensed capacity of the cluster and of each licensed node.
+type capacityReport struct {
+	Total     int            `json:"total"`
+	Used      int            `json:"used"`
+	Available int            `json:"available"`
+	Nodes     []nodeCapacity `json:"nodes"`
+}
+
+// nodeCapacity represents the capacity of a single licensed node.
+type nodeCapacity struct {
+	Name          string `json:"name"`
+	Pool          string `json:"pool"`
+	Schedulable   bool   `json:"schedulable"`
+	Total         int    `json:"total"`
+	Used          int    `json:"used"`
+	Available     int    `json:"available"`
+	InstanceCount int    `json:"instanceCount"`
+}
+
+// capacity returns the capacity report of the cluster.
+func capacity(ctx context.Context, c *clients.Clients, opts *options) (*capacityReport, error) {
+	nodes, err := licensedNodes(ctx, c, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := countedInstances(ctx, c, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCapacityReport(nodes, instances, opts.labelKey), nil
+}
+
+// newCapacityReport returns the capacity report of the licensed nodes given the instances which are counted against
+// them.  The used capacity of a node is that of the counted instances which are running on it, while the total used
+// capacity also includes counted instances which are not yet running on any node, as the webhook does.
+func newCapacityReport(nodes resources.Nodes, instances resources.VirtualMachineInstances, labelKey string) *capacityReport {
+	report := &capacityReport{
+		Total: nodes.SumCPU(),
+		Used:  instances.SumCPU(),
+		Nodes: make([]nodeCapacity, len(nodes)),
+	}
+
+	report.Available = report.Total - report.Used
+
+	for i := range nodes {
+		node := nodeCapacity{
+			Name:        nodes[i].Name,
+			Pool:        nodes[i].GetLabels()[labelKey],
+			Schedulable: !nodes[i].Spec.Unschedulable,
+			Total:       nodes[i : i+1].SumCPU(),
+		}
+
+		for j := range instances {
+			if instances[j].Status.NodeName == node.Name {
+				node.Used += instances[j : j+1].SumCPU()
+				node.InstanceCount++
+			}
+		}
+
+		node.Available = node.Total - node.Used
+		report.Nodes[i] = node
+	}
+
+	return report
+}
+
+// writeTable writes the capacity report as a table, with a row for each node followed by the totals.
+func (report *capacityReport) writeTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+
+	fmt.Fprintln(w, "NODE\tPOOL\tSCHEDULABLE\tINSTANCES\tVCPUS\tUSED\tAVAILABLE")
+
+	for _, node := range report.Nodes {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%d\t%d\t%d\t%d\n",
+			node.Name, node.Pool, node.Schedulable, node.InstanceCount, node.Total, node.Used, node.Available,
+		)
+	}
+
+	fmt.Fprintf(w, "TOTAL\t\t\t\t%d\t%d\t%d\n", report.Total, report.Used, report.Available)
+
+	return w.Flush()
+}
+
+// licensedNodes returns the nodes which match the node filter.
+func licensedNodes(ctx context.Context, c *clients.Clients, opts *options) (resources.Nodes, error) {
+	nodeList, err := c.KubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes; %w", err)
+	}
+
+	return resources.Nodes(nodeList.Items).Filter(resources.NewNodeFilter(opts.labelKey, opts.labelValues)), nil
+}
+
+// countedInstances returns the virtual machine instances which are counted against the licensed capacity, in the same
+// way as the webhook counts them.
+func countedInstances(ctx context.Context, c *clients.Clients, opts *options) (resources.VirtualMachineInstances, error) {
+	listOptions := metav1.ListOptions{}
+	if opts.countByLabel {
+		listOptions.LabelSelector = fmt.Sprintf("%s=%s", resources.WindowsLabelKey, resources.WindowsLabelValue)
+	}
+
+	instanceList, err := c.VirtClient.VirtualMachineInstance(metav1.NamespaceAll).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list virtual machine instances; %w", err)
+	}
+
+	return resources.VirtualMachineInstances(instanceList.Items).Filter(
+		&resources.VirtualMachineInstancesFilter{ByLabel: opts.countByLabel},
+	).Unique(), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+func testNode(name string, cpus int64, unschedulable bool) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{resources.DefaultLabelKey: "windows"}},
+		Spec:       corev1.NodeSpec{Unschedulable: unschedulable},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{corev1.ResourceCPU: *resource.NewQuantity(cpus, resource.DecimalSI)},
+		},
+	}
+}
+
+func testInstance(namespace, name, node string, cores uint32) kubevirtv1.VirtualMachineInstance {
+	return kubevirtv1.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: kubevirtv1.VirtualMachineInstanceSpec{
+			Domain: kubevirtv1.DomainSpec{CPU: &kubevirtv1.CPU{Sockets: 1, Cores: cores, Threads: 1}},
+			Volumes: []kubevirtv1.Volume{
+				{Name: "sysprep", VolumeSource: kubevirtv1.VolumeSource{Sysprep: &kubevirtv1.SysprepSource{}}},
+			},
+		},
+		Status: kubevirtv1.VirtualMachineInstanceStatus{NodeName: node},
+	}
+}
+
+func Test_newCapacityReport(t *testing.T) {
+	t.Parallel()
+
+	nodes := resources.Nodes{testNode("node-1", 16, false), testNode("node-2", 8, true)}
+	instances := resources.VirtualMachineInstances{
+		testInstance("team-a", "vm-1", "node-1", 4),
+		testInstance("team-a", "vm-2", "node-1", 2),
+		testInstance("team-b", "vm-1", "", 2),
+	}
+
+	want := &capacityReport{
+		Total:     24,
+		Used:      8,
+		Available: 16,
+		Nodes: []nodeCapacity{
+			{Name: "node-1", Pool: "windows", Schedulable: true, Total: 16, Used: 6, Available: 10, InstanceCount: 2},
+			{Name: "node-2", Pool: "windows", Schedulable: false, Total: 8, Used: 0, Available: 8},
+		},
+	}
+
+	got := newCapacityReport(nodes, instances, resources.DefaultLabelKey)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newCapacityReport() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_write(t *testing.T) {
+	t.Parallel()
+
+	report := &capacityReport{
+		Total:     16,
+		Used:      4,
+		Available: 12,
+		Nodes:     []nodeCapacity{{Name: "node-1", Pool: "windows", Schedulable: true, Total: 16, Used: 4, Available: 12}},
+	}
+
+	tests := []struct {
+		name   string
+		format outputFormat
+		want   []string
+	}{
+		{
+			name:   "ensure a table has a row for each node and the totals",
+			format: outputTable,
+			want:   []string{"NODE", "node-1", "TOTAL"},
+		},
+		{
+			name:   "ensure json is written",
+			format: outputJSON,
+			want:   []string{`"available": 12`, `"name": "node-1"`},
+		},
+		{
+			name:   "ensure yaml is written",
+			format: outputYAML,
+			want:   []string{"available: 12", "- available: 12"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			out := &bytes.Buffer{}
+			if err := write(out, tt.format, report); err != nil {
+				t.Fatalf("write() error = %v", err)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("write() = %s, want to contain %s", out.String(), want)
+				}
+			}
+		})
+	}
+}
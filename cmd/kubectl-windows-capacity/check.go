@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/clients"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+// checkReport represents whether a manifest fits within the available licensed capacity.
+type checkReport struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Windows   bool   `json:"windows"`
+	Reason    string `json:"reason"`
+	Requested int    `json:"requested"`
+	Total     int    `json:"total"`
+	Used      int    `json:"used"`
+	Available int    `json:"available"`
+	Fits      bool   `json:"fits"`
+}
+
+// check returns the check report of the manifest given by the filename against the live capacity of the cluster.
+func check(ctx context.Context, c *clients.Clients, opts *options) (*checkReport, error) {
+	if opts.filename == "" {
+		return nil, errors.New("a manifest must be given with --filename")
+	}
+
+	var (
+		manifest []byte
+		err      error
+	)
+
+	if opts.filename == "-" {
+		manifest, err = io.ReadAll(os.Stdin)
+	} else {
+		manifest, err = os.ReadFile(opts.filename)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest [%s]; %w", opts.filename, err)
+	}
+
+	object, err := decodeManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := licensedNodes(ctx, c, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := countedInstances(ctx, c, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCheckReport(object, nodes, instances), nil
+}
+
+// decodeManifest decodes a YAML or JSON manifest of a virtual machine or virtual machine instance.
+func decodeManifest(manifest []byte) (resources.WindowsInstanceValidator, error) {
+	data, err := yaml.YAMLToJSON(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode manifest; %w", err)
+	}
+
+	typeMeta := runtime.TypeMeta{}
+	if err := json.Unmarshal(data, &typeMeta); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest; %w", err)
+	}
+
+	var validator resources.WindowsInstanceValidator
+
+	switch typeMeta.Kind {
+	case resources.VirtualMachineType:
+		validator = resources.NewVirtualMachine()
+	case resources.VirtualMachineInstanceType:
+		validator = resources.NewVirtualMachineInstance()
+	default:
+		return nil, fmt.Errorf(
+			"unsupported kind [%s]; only [%s %s] supported",
+			typeMeta.Kind,
+			resources.VirtualMachineType,
+			resources.VirtualMachineInstanceType,
+		)
+	}
+
+	return validator.Extract(&admissionv1.AdmissionRequest{Object: runtime.RawExtension{Raw: data}})
+}
+
+// newCheckReport returns whether an object fits within the capacity of the licensed nodes which is not used by the
+// counted instances.  Objects which are not windows instances always fit.  The reserved capacity and priority of the
+// webhook are not taken into account, so the webhook remains the authority on whether the object is admitted.
+func newCheckReport(
+	object resources.WindowsInstanceValidator,
+	nodes resources.Nodes,
+	instances resources.VirtualMachineInstances,
+) *checkReport {
+	result := object.NeedsValidation()
+
+	report := &checkReport{
+		Kind:      object.GetObjectKind().GroupVersionKind().Kind,
+		Namespace: object.GetNamespace(),
+		Name:      object.GetName(),
+		Windows:   result.NeedsValidation,
+		Reason:    result.Reason,
+		Requested: object.SumCPU(),
+		Total:     nodes.SumCPU(),
+		Used:      instances.SumCPU(),
+	}
+
+	report.Available = report.Total - report.Used
+	report.Fits = !report.Windows || report.Requested <= report.Available
+
+	return report
+}
+
+// writeTable writes the check report as a table.
+func (report *checkReport) writeTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+
+	fmt.Fprintln(w, "KIND\tNAMESPACE\tNAME\tWINDOWS\tREQUESTED\tAVAILABLE\tFITS\tREASON")
+	fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%d\t%d\t%t\t%s\n",
+		report.Kind, report.Namespace, report.Name, report.Windows, report.Requested, report.Available, report.Fits, report.Reason,
+	)
+
+	return w.Flush()
+}
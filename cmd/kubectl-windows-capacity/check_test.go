@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+func Test_newCheckReport(t *testing.T) {
+	t.Parallel()
+
+	nodes := resources.Nodes{testNode("node-1", 16, false)}
+	instances := resources.VirtualMachineInstances{testInstance("team-a", "vm-1", "node-1", 8)}
+
+	tests := []struct {
+		name     string
+		manifest string
+		want     *checkReport
+		wantErr  bool
+	}{
+		{
+			name: "ensure a windows virtual machine which fits is reported as fitting",
+			manifest: `
+apiVersion: kubevirt.io/v1
+kind: VirtualMachine
+metadata:
+  name: vm-2
+  namespace: team-a
+spec:
+  template:
+    spec:
+      domain:
+        cpu:
+          cores: 8
+      volumes:
+        - name: sysprep
+          sysprep: {}
+`,
+			want: &checkReport{
+				Kind: "VirtualMachine", Namespace: "team-a", Name: "vm-2", Windows: true, Reason: "has sysprep volume",
+				Requested: 8, Total: 16, Used: 8, Available: 8, Fits: true,
+			},
+		},
+		{
+			name:     "ensure a windows virtual machine instance which does not fit is reported as not fitting",
+			manifest: `{"apiVersion":"kubevirt.io/v1","kind":"VirtualMachineInstance","metadata":{"name":"vmi","namespace":"team-a"},"spec":{"domain":{"cpu":{"cores":10},"features":{"hyperv":{}}}}}`,
+			want: &checkReport{
+				Kind: "VirtualMachineInstance", Namespace: "team-a", Name: "vmi", Windows: true, Reason: "has hyper-v features",
+				Requested: 10, Total: 16, Used: 8, Available: 8, Fits: false,
+			},
+		},
+		{
+			name:     "ensure an unsupported kind returns an error",
+			manifest: `{"apiVersion":"v1","kind":"Pod"}`,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			object, err := decodeManifest([]byte(tt.manifest))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if got := newCheckReport(object, nodes, instances); *got != *tt.want {
+				t.Errorf("newCheckReport() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/clients"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+// instancesReport represents the windows virtual machine instances of the cluster.
+type instancesReport struct {
+	Instances []instance `json:"instances"`
+}
+
+// instance represents a windows virtual machine instance, why it was detected as one and whether it is counted against
+// the licensed capacity.
+type instance struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Node      string   `json:"node"`
+	Phase     string   `json:"phase"`
+	VCPUs     int      `json:"vcpus"`
+	Counted   bool     `json:"counted"`
+	Reasons   []string `json:"reasons"`
+}
+
+// instances returns the windows virtual machine instances report of the cluster.
+func instances(ctx context.Context, c *clients.Clients, opts *options) (*instancesReport, error) {
+	instanceList, err := c.VirtClient.VirtualMachineInstance(opts.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list virtual machine instances; %w", err)
+	}
+
+	all := resources.VirtualMachineInstances(instanceList.Items)
+
+	return newInstancesReport(all, all.Filter(&resources.VirtualMachineInstancesFilter{ByLabel: opts.countByLabel})), nil
+}
+
+// newInstancesReport returns the report of each windows instance, noting whether it is one of the counted instances.
+// Instances are detected as windows if they have any windows identifier, which may include instances which the
+// webhook does not count.
+func newInstancesReport(all, counted resources.VirtualMachineInstances) *instancesReport {
+	isCounted := map[string]bool{}
+	for i := range counted {
+		isCounted[counted[i].Namespace+"/"+counted[i].Name] = true
+	}
+
+	report := &instancesReport{Instances: []instance{}}
+
+	for i := range all {
+		reasons := resources.WindowsReasons(&all[i])
+		if len(reasons) == 0 {
+			continue
+		}
+
+		report.Instances = append(report.Instances, instance{
+			Namespace: all[i].Namespace,
+			Name:      all[i].Name,
+			Node:      all[i].Status.NodeName,
+			Phase:     string(all[i].Status.Phase),
+			VCPUs:     all[i : i+1].SumCPU(),
+			Counted:   isCounted[all[i].Namespace+"/"+all[i].Name],
+			Reasons:   reasons,
+		})
+	}
+
+	return report
+}
+
+// writeTable writes the instances report as a table.
+func (report *instancesReport) writeTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tNODE\tPHASE\tVCPUS\tCOUNTED\tREASONS")
+
+	for _, i := range report.Instances {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%t\t%s\n",
+			i.Namespace, i.Name, i.Node, i.Phase, i.VCPUs, i.Counted, strings.Join(i.Reasons, ", "),
+		)
+	}
+
+	return w.Flush()
+}
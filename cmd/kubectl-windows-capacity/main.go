@@ -0,0 +1,154 @@
+// Command kubectl-windows-capacity is a kubectl (and oc) plugin which reports the licensed windows capacity of a
+// cluster, lists the windows virtual machine instances which use it and checks whether a manifest would fit within
+// it.  It is invoked as `kubectl windows-capacity` once it is on the PATH.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/clients"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+const (
+	commandCapacity  = "capacity"
+	commandInstances = "instances"
+	commandCheck     = "check"
+)
+
+const usage = `Report the licensed windows capacity of a cluster.
+
+Usage:
+  kubectl windows-capacity [capacity] [flags]       print the capacity of each licensed node
+  kubectl windows-capacity instances [flags]        list windows virtual machine instances with detection reasons
+  kubectl windows-capacity check -f FILE [flags]    check whether a manifest fits within the available capacity
+
+Flags:
+`
+
+// options represents the options which are shared by every command.
+type options struct {
+	kubeconfig   string
+	context      string
+	output       string
+	labelKey     string
+	labelValues  string
+	countByLabel bool
+	namespace    string
+	filename     string
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command given by the arguments, writing its output to the writer.
+func run(ctx context.Context, args []string, out io.Writer) error {
+	command := commandCapacity
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+
+	opts, err := parseOptions(command, args)
+	if err != nil {
+		return err
+	}
+
+	format, err := newOutputFormat(opts.output)
+	if err != nil {
+		return err
+	}
+
+	c, err := newClients(opts)
+	if err != nil {
+		return err
+	}
+
+	var report any
+
+	switch command {
+	case commandCapacity:
+		report, err = capacity(ctx, c, opts)
+	case commandInstances:
+		report, err = instances(ctx, c, opts)
+	case commandCheck:
+		report, err = check(ctx, c, opts)
+	default:
+		return fmt.Errorf("unknown command [%s]; must be one of [%s %s %s]", command, commandCapacity, commandInstances, commandCheck)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return write(out, format, report)
+}
+
+// parseOptions parses the flags of a command.
+func parseOptions(command string, args []string) (*options, error) {
+	opts := &options{}
+
+	flags := flag.NewFlagSet("kubectl-windows-capacity "+command, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to the kubeconfig file")
+	flags.StringVar(&opts.context, "context", "", "name of the kubeconfig context to use")
+	flags.StringVar(&opts.output, "output", string(outputTable), "output format: table, json or yaml")
+	flags.StringVar(&opts.output, "o", string(outputTable), "shorthand for --output")
+	flags.StringVar(&opts.labelKey, "label-key", resources.DefaultLabelKey, "label key of the licensed nodes")
+	flags.StringVar(&opts.labelValues, "label-values", resources.DefaultLabelValues, "comma-separated label values of the licensed nodes")
+	flags.BoolVar(&opts.countByLabel, "count-by-label", false, "only count instances labeled as windows, as the webhook does with WEBHOOK_COUNT_BY_LABEL")
+	flags.StringVar(&opts.namespace, "namespace", metav1.NamespaceAll, "only list instances in this namespace")
+	flags.StringVar(&opts.namespace, "n", metav1.NamespaceAll, "shorthand for --namespace")
+	flags.StringVar(&opts.filename, "filename", "", "manifest of a VirtualMachine or VirtualMachineInstance to check, or - for stdin")
+	flags.StringVar(&opts.filename, "f", "", "shorthand for --filename")
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %v", flags.Args())
+	}
+
+	return opts, nil
+}
+
+// newClients returns the clients for the cluster of the kubeconfig, following the same loading rules as kubectl.
+func newClients(opts *options) (*clients.Clients, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = opts.kubeconfig
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		loadingRules,
+		&clientcmd.ConfigOverrides{CurrentContext: opts.context},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig; %w", err)
+	}
+
+	return clients.NewForConfig(config)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"sigs.k8s.io/yaml"
+)
+
+// outputFormat represents the format that a report is written in.
+type outputFormat string
+
+const (
+	outputTable outputFormat = "table"
+	outputJSON  outputFormat = "json"
+	outputYAML  outputFormat = "yaml"
+)
+
+// tableWriter represents a report which may be written as a table.
+type tableWriter interface {
+	writeTable(io.Writer) error
+}
+
+// newOutputFormat returns the output format given its name.
+func newOutputFormat(name string) (outputFormat, error) {
+	switch format := outputFormat(name); format {
+	case outputTable, outputJSON, outputYAML:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid output format [%s]; must be one of [%s %s %s]", name, outputTable, outputJSON, outputYAML)
+	}
+}
+
+// write writes a report in the given output format.
+func write(out io.Writer, format outputFormat, report any) error {
+	switch format {
+	case outputJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(report)
+	case outputYAML:
+		data, err := yaml.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("failed to encode report; %w", err)
+		}
+
+		_, err = out.Write(data)
+
+		return err
+	default:
+		table, ok := report.(tableWriter)
+		if !ok {
+			return fmt.Errorf("report of type [%T] may not be written as a table", report)
+		}
+
+		return table.writeTable(out)
+	}
+}
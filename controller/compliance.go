@@ -0,0 +1,505 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/clients"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/metrics"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/webhook"
+)
+
+const (
+	// EnvStopViolations enables stopping windows instances which are found running on unlicensed nodes.
+	EnvStopViolations = "WEBHOOK_COMPLIANCE_STOP_VIOLATIONS"
+
+	// EnvResyncInterval is how often the compliance of all windows instances is reconciled, regardless of whether
+	// any instances or nodes have changed.
+	EnvResyncInterval = "WEBHOOK_COMPLIANCE_RESYNC_INTERVAL"
+
+	DefaultResyncInterval = 5 * time.Minute
+
+	// ReasonUnlicensedNode is the event reason for a windows instance which is running on an unlicensed node.
+	ReasonUnlicensedNode = "UnlicensedNode"
+
+	// ReasonStoppedUnlicensedNode is the event reason for a windows instance which was stopped for running on an
+	// unlicensed node.
+	ReasonStoppedUnlicensedNode = "StoppedUnlicensedNode"
+
+	componentName = "windows-overcommit-compliance"
+
+	leaseName          = "windows-overcommit-webhook-compliance"
+	leaseDuration      = 15 * time.Second
+	leaseRenewDeadline = 10 * time.Second
+	leaseRetryPeriod   = 2 * time.Second
+)
+
+// Violation represents a windows instance which is running on a node outside of the licensed node pool.
+type Violation struct {
+	Namespace string      `json:"namespace"`
+	Name      string      `json:"name"`
+	Node      string      `json:"node"`
+	Since     metav1.Time `json:"since"`
+	Stopped   bool        `json:"stopped,omitempty"`
+}
+
+// key returns the key which uniquely identifies the instance of a violation.
+func (v Violation) key() string {
+	return v.Namespace + "/" + v.Name
+}
+
+// Compliance is a controller which watches virtual machine instances and flags windows instances which are running
+// on nodes outside of the licensed node pool, optionally stopping them.  Without this, instances which were created
+// before the webhook or which bypassed it may run on unlicensed nodes unnoticed.  Only the elected leader among the
+// controller replicas reports violations.
+type Compliance struct {
+	kubeClient kubernetes.Interface
+	virtClient kubecli.KubevirtClient
+	nodeFilter resources.NodeFilter
+	recorder   record.EventRecorder
+	logger     zerolog.Logger
+	identity   string
+	namespace  string
+	statusName string
+
+	stopViolations bool
+	countByLabel   bool
+	queue          bool
+	queueOrder     resources.QueueOrder
+	resyncInterval time.Duration
+
+	instances       cache.Store
+	nodes           cache.Store
+	vms             cache.Store
+	priorityClasses cache.Store
+	synced          atomic.Bool
+	changed         chan struct{}
+
+	// violations are the violations found by the most recent reconcile, keyed by namespace and name.  They are only
+	// accessed by the leader.
+	violations map[string]Violation
+	capacity   Capacity
+	conditions []metav1.Condition
+	starting   map[string]starting
+	status     string
+
+	// queuePositions are the queued virtual machines whose positions are reported in metrics.
+	queuePositions map[types.NamespacedName]bool
+}
+
+// NewCompliance returns a new instance of a compliance controller object.
+func NewCompliance() (*Compliance, error) {
+	c, err := clients.NewInCluster()
+	if err != nil {
+		return nil, err
+	}
+
+	stopViolations := false
+	if value := os.Getenv(EnvStopViolations); value != "" {
+		if stopViolations, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid value [%s] for [%s]; %w", value, EnvStopViolations, err)
+		}
+	}
+
+	resyncInterval := DefaultResyncInterval
+	if value := os.Getenv(EnvResyncInterval); value != "" {
+		if resyncInterval, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid value [%s] for [%s]; %w", value, EnvResyncInterval, err)
+		}
+	}
+
+	queueOrder, err := resources.NewQueueOrder(os.Getenv(resources.EnvQueueOrder))
+	if err != nil {
+		return nil, err
+	}
+
+	logLevel := zerolog.InfoLevel
+	if os.Getenv("DEBUG") == "true" {
+		logLevel = zerolog.DebugLevel
+	}
+
+	// events reference virtual machine instances so the scheme must know about the kubevirt types
+	eventScheme := runtime.NewScheme()
+	if err := scheme.AddToScheme(eventScheme); err != nil {
+		return nil, fmt.Errorf("failed to add kubernetes types to event scheme; %w", err)
+	}
+
+	if err := kubevirtv1.AddToScheme(eventScheme); err != nil {
+		return nil, fmt.Errorf("failed to add kubevirt types to event scheme; %w", err)
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: c.KubeClient.CoreV1().Events("")})
+
+	return &Compliance{
+		kubeClient:     c.KubeClient,
+		virtClient:     c.VirtClient,
+		nodeFilter:     resources.NewNodeFilter(os.Getenv(resources.EnvLabelKey), os.Getenv(resources.EnvLabelValues)),
+		recorder:       broadcaster.NewRecorder(eventScheme, corev1.EventSource{Component: componentName}),
+		logger:         zerolog.New(os.Stdout).Level(logLevel),
+		identity:       clients.Identity(),
+		namespace:      clients.Namespace(),
+		statusName:     envOrDefault(EnvStatusName, DefaultStatusName),
+		stopViolations: stopViolations,
+		countByLabel:   os.Getenv(webhook.EnvCountByLabel) == "true",
+		queue:          os.Getenv(webhook.EnvQueueVirtualMachines) == "true",
+		queueOrder:     queueOrder,
+		resyncInterval: resyncInterval,
+		changed:        make(chan struct{}, 1),
+		violations:     map[string]Violation{},
+		starting:       map[string]starting{},
+	}, nil
+}
+
+// Run starts watching virtual machine instances and nodes and reconciles their compliance while we are the leader,
+// blocking until the context is cancelled.
+func (c *Compliance) Run(ctx context.Context) error {
+	instanceInformer := cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return c.virtClient.VirtualMachineInstance(metav1.NamespaceAll).List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return c.virtClient.VirtualMachineInstance(metav1.NamespaceAll).Watch(ctx, options)
+		},
+	}, &kubevirtv1.VirtualMachineInstance{}, 0, cache.Indexers{})
+
+	nodeInformer := cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return c.kubeClient.CoreV1().Nodes().List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return c.kubeClient.CoreV1().Nodes().Watch(ctx, options)
+		},
+	}, &corev1.Node{}, 0, cache.Indexers{})
+
+	// any change to an instance or node triggers a reconcile of all instances, as a node label change may affect
+	// many instances at once
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { c.trigger() },
+		UpdateFunc: func(any, any) { c.trigger() },
+		DeleteFunc: func(any) { c.trigger() },
+	}
+
+	informers := []cache.SharedIndexInformer{instanceInformer, nodeInformer}
+
+	// virtual machines are only needed to start those which are queued
+	vmInformer := cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = fmt.Sprintf("%s=%s", resources.QueuedLabelKey, resources.QueuedLabelValue)
+
+			return c.virtClient.VirtualMachine(metav1.NamespaceAll).List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = fmt.Sprintf("%s=%s", resources.QueuedLabelKey, resources.QueuedLabelValue)
+
+			return c.virtClient.VirtualMachine(metav1.NamespaceAll).Watch(ctx, options)
+		},
+	}, &kubevirtv1.VirtualMachine{}, 0, cache.Indexers{})
+
+	// priority classes are only needed to order the queue by priority
+	priorityClassInformer := cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return c.kubeClient.SchedulingV1().PriorityClasses().List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return c.kubeClient.SchedulingV1().PriorityClasses().Watch(ctx, options)
+		},
+	}, &schedulingv1.PriorityClass{}, 0, cache.Indexers{})
+
+	if c.queue {
+		informers = append(informers, vmInformer)
+
+		if c.queueOrder == resources.QueueOrderPriority {
+			informers = append(informers, priorityClassInformer)
+		}
+	}
+
+	for _, informer := range informers {
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			return fmt.Errorf("failed to add event handler; %w", err)
+		}
+
+		go informer.Run(ctx.Done())
+	}
+
+	c.instances, c.nodes, c.vms = instanceInformer.GetStore(), nodeInformer.GetStore(), vmInformer.GetStore()
+	c.priorityClasses = priorityClassInformer.GetStore()
+
+	c.logger.Info().Msg("waiting for caches to sync")
+
+	synced := make([]cache.InformerSynced, len(informers))
+	for i := range informers {
+		synced[i] = informers[i].HasSynced
+	}
+
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("failed to sync caches; %w", ctx.Err())
+	}
+
+	c.synced.Store(true)
+
+	c.leaderLoop(ctx)
+
+	return nil
+}
+
+// Ready returns an error if the caches of virtual machine instances and nodes have not yet synced.
+func (c *Compliance) Ready() error {
+	if !c.synced.Load() {
+		return errors.New("virtual machine instance and node caches have not synced")
+	}
+
+	return nil
+}
+
+// trigger requests a reconcile.  Multiple requests prior to the reconcile are coalesced into a single reconcile.
+func (c *Compliance) trigger() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// leaderLoop runs leader election until the context is cancelled, reconciling while we are the leader.
+func (c *Compliance) leaderLoop(ctx context.Context) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: c.namespace,
+		},
+		Client:     c.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: c.identity},
+	}
+
+	// run the election again after losing the lease, until we are told to stop
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   leaseRenewDeadline,
+			RetryPeriod:     leaseRetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            leaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: c.lead,
+				OnStoppedLeading: func() {
+					// the new leader reports violations from now on
+					metrics.ComplianceViolations.Reset()
+					metrics.QueuePosition.Reset()
+
+					c.logger.Info().Str("identity", c.identity).Msg("stopped leading compliance")
+				},
+			},
+		})
+	}
+}
+
+// lead reconciles the compliance of windows instances whenever an instance or node changes, and at the resync
+// interval, until the context is cancelled.
+func (c *Compliance) lead(ctx context.Context) {
+	c.logger.Info().Str("identity", c.identity).Msg("started leading compliance")
+
+	// forget any state from a previous term as we may have missed changes while not leading
+	c.violations = map[string]Violation{}
+	c.capacity = Capacity{}
+	c.conditions = nil
+	c.starting = map[string]starting{}
+	c.queuePositions = nil
+	c.status = ""
+
+	ticker := time.NewTicker(c.resyncInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.reconcile(ctx); err != nil {
+			c.logger.Error().Err(err).Msg("failed to reconcile compliance")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcile finds the windows instances which are running on unlicensed nodes and reports them as metrics, events
+// and in the status config map, stopping them if requested.  The licensed capacity is also checked for overcommit.
+func (c *Compliance) reconcile(ctx context.Context) error {
+	instances := make([]*kubevirtv1.VirtualMachineInstance, 0, len(c.instances.List()))
+	for _, object := range c.instances.List() {
+		instances = append(instances, object.(*kubevirtv1.VirtualMachineInstance))
+	}
+
+	nodes := map[string]*corev1.Node{}
+	for _, object := range c.nodes.List() {
+		node := object.(*corev1.Node)
+		nodes[node.Name] = node
+	}
+
+	now := metav1.Now()
+	current := map[string]Violation{}
+
+	metrics.ComplianceViolations.Reset()
+
+	for _, instance := range findViolations(instances, nodes, c.nodeFilter) {
+		violation := Violation{Namespace: instance.Namespace, Name: instance.Name, Node: instance.Status.NodeName, Since: now}
+
+		// only report new violations, or violations which have moved to another unlicensed node
+		previous, found := c.violations[violation.key()]
+		if found && previous.Node == violation.Node {
+			violation = previous
+		} else {
+			c.logger.Warn().
+				Str("namespace", violation.Namespace).
+				Str("name", violation.Name).
+				Str("node", violation.Node).
+				Msg("windows instance is running on an unlicensed node")
+
+			c.recorder.Eventf(instance, corev1.EventTypeWarning, ReasonUnlicensedNode,
+				"windows instance is running on node [%s] which does not match licensed nodes [%s in %v]",
+				violation.Node, c.nodeFilter.LabelKey(), c.nodeFilter.LabelValues(),
+			)
+		}
+
+		if c.stopViolations && !violation.Stopped {
+			violation.Stopped = c.stop(ctx, instance)
+		}
+
+		metrics.ComplianceViolations.WithLabelValues(violation.Namespace, violation.Name, violation.Node).Set(1)
+
+		current[violation.key()] = violation
+	}
+
+	c.violations = current
+
+	compliant := metav1.Condition{
+		Type:    ConditionCompliant,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonNoViolations,
+		Message: "no windows instances are running on unlicensed nodes",
+	}
+
+	if len(current) > 0 {
+		compliant.Status = metav1.ConditionFalse
+		compliant.Reason = ReasonUnlicensedNode
+		compliant.Message = fmt.Sprintf("[%d] windows instances are running on unlicensed nodes", len(current))
+	}
+
+	meta.SetStatusCondition(&c.conditions, compliant)
+
+	c.reconcileCapacity(instances, nodes)
+
+	// a failure to reconcile the queue must not prevent the status from being reported
+	var queueErr error
+	if c.queue {
+		queueErr = c.reconcileQueue(ctx, instances)
+	}
+
+	return errors.Join(queueErr, c.updateStatus(ctx))
+}
+
+// stop stops a windows instance which is running on an unlicensed node, returning if it was stopped.  An instance
+// owned by a virtual machine is stopped through the virtual machine so that it is not restarted, otherwise the
+// instance is deleted.
+func (c *Compliance) stop(ctx context.Context, instance *kubevirtv1.VirtualMachineInstance) bool {
+	var err error
+
+	owner := metav1.GetControllerOf(instance)
+	if owner != nil && owner.Kind == resources.VirtualMachineType {
+		err = c.virtClient.VirtualMachine(instance.Namespace).Stop(ctx, owner.Name, &kubevirtv1.StopOptions{})
+	} else {
+		err = c.virtClient.VirtualMachineInstance(instance.Namespace).Delete(ctx, instance.Name, metav1.DeleteOptions{})
+	}
+
+	if err != nil {
+		metrics.ComplianceStops.WithLabelValues("failure").Inc()
+
+		c.logger.Error().
+			Err(err).
+			Str("namespace", instance.Namespace).
+			Str("name", instance.Name).
+			Msg("failed to stop windows instance running on an unlicensed node")
+
+		return false
+	}
+
+	metrics.ComplianceStops.WithLabelValues("success").Inc()
+
+	c.recorder.Eventf(instance, corev1.EventTypeWarning, ReasonStoppedUnlicensedNode,
+		"stopped windows instance running on unlicensed node [%s]", instance.Status.NodeName,
+	)
+
+	return true
+}
+
+// findViolations returns the windows instances which are running on a node that does not match the node filter,
+// sorted by namespace and name.  Instances on nodes which are not known are not considered, as the node may simply
+// not have been observed yet.  Windows instances are detected with every signal that the mutating webhook labels,
+// which is broader than the capacity filter, as any windows instance on an unlicensed node breaks compliance whether
+// or not it is counted against the licensed capacity.
+func findViolations(
+	instances []*kubevirtv1.VirtualMachineInstance,
+	nodes map[string]*corev1.Node,
+	filter resources.NodeFilter,
+) []*kubevirtv1.VirtualMachineInstance {
+	violations := []*kubevirtv1.VirtualMachineInstance{}
+
+	for _, instance := range instances {
+		if instance.Status.NodeName == "" || instance.IsFinal() || !resources.IsWindows(instance) {
+			continue
+		}
+
+		node, found := nodes[instance.Status.NodeName]
+		if !found || resources.MatchesNodeFilter(node, filter) {
+			continue
+		}
+
+		violations = append(violations, instance)
+	}
+
+	sort.Slice(violations, func(i, j int) bool {
+		if violations[i].Namespace != violations[j].Namespace {
+			return violations[i].Namespace < violations[j].Namespace
+		}
+
+		return violations[i].Name < violations[j].Name
+	})
+
+	return violations
+}
+
+// envOrDefault returns the value of an environment variable or a default value if it is unset.
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
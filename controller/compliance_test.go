@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+func testNode(name, imageType string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"image_type": imageType}}}
+}
+
+func testInstance(name, node string, windows bool, phase kubevirtv1.VirtualMachineInstancePhase) *kubevirtv1.VirtualMachineInstance {
+	instance := &kubevirtv1.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+		Status:     kubevirtv1.VirtualMachineInstanceStatus{NodeName: node, Phase: phase},
+	}
+
+	if windows {
+		instance.Labels = map[string]string{resources.WindowsLabelKey: resources.WindowsLabelValue}
+	}
+
+	return instance
+}
+
+func Test_findViolations(t *testing.T) {
+	t.Parallel()
+
+	nodes := map[string]*corev1.Node{
+		"licensed":   testNode("licensed", "windows"),
+		"unlicensed": testNode("unlicensed", "linux"),
+	}
+
+	instances := []*kubevirtv1.VirtualMachineInstance{
+		testInstance("windows-unlicensed-b", "unlicensed", true, kubevirtv1.Running),
+		testInstance("windows-unlicensed-a", "unlicensed", true, kubevirtv1.Running),
+		testInstance("windows-licensed", "licensed", true, kubevirtv1.Running),
+		testInstance("windows-unscheduled", "", true, kubevirtv1.Pending),
+		testInstance("windows-unknown-node", "missing", true, kubevirtv1.Running),
+		testInstance("windows-succeeded", "unlicensed", true, kubevirtv1.Succeeded),
+		testInstance("linux-unlicensed", "unlicensed", false, kubevirtv1.Running),
+	}
+
+	violations := findViolations(instances, nodes, resources.NewNodeFilter("", ""))
+
+	got := []string{}
+	for _, violation := range violations {
+		got = append(got, violation.Name)
+	}
+
+	want := []string{"windows-unlicensed-a", "windows-unlicensed-b"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("findViolations() = %v, want %v", got, want)
+	}
+}
+
+func TestCompliance_reconcile(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	recorder := record.NewFakeRecorder(10)
+
+	c := &Compliance{
+		kubeClient: client,
+		nodeFilter: resources.NewNodeFilter("", ""),
+		recorder:   recorder,
+		logger:     zerolog.Nop(),
+		namespace:  "test-namespace",
+		statusName: DefaultStatusName,
+		instances:  cache.NewStore(cache.MetaNamespaceKeyFunc),
+		nodes:      cache.NewStore(cache.MetaNamespaceKeyFunc),
+		violations: map[string]Violation{},
+	}
+
+	for _, node := range []*corev1.Node{testNode("licensed", "windows"), testNode("unlicensed", "linux")} {
+		_ = c.nodes.Add(node)
+	}
+
+	_ = c.instances.Add(testInstance("windows", "unlicensed", true, kubevirtv1.Running))
+
+	// reconcile twice to ensure that an existing violation is only reported once
+	for i := 0; i < 2; i++ {
+		if err := c.reconcile(ctx); err != nil {
+			t.Fatalf("Compliance.reconcile() error = %v", err)
+		}
+	}
+
+	if len(recorder.Events) != 1 {
+		t.Errorf("Compliance.reconcile() recorded %d events, want 1", len(recorder.Events))
+	}
+
+	status := readStatus(ctx, t, c)
+	if status.Compliant || len(status.Violations) != 1 || status.Violations[0].Node != "unlicensed" {
+		t.Errorf("Compliance.reconcile() status = %+v, want violation on unlicensed node", status)
+	}
+
+	// ensure the status is cleared once the instance is moved to a licensed node
+	_ = c.instances.Update(testInstance("windows", "licensed", true, kubevirtv1.Running))
+
+	if err := c.reconcile(ctx); err != nil {
+		t.Fatalf("Compliance.reconcile() error = %v", err)
+	}
+
+	status = readStatus(ctx, t, c)
+	if !status.Compliant || len(status.Violations) != 0 {
+		t.Errorf("Compliance.reconcile() status = %+v, want compliant", status)
+	}
+}
+
+func readStatus(ctx context.Context, t *testing.T, c *Compliance) Status {
+	t.Helper()
+
+	configMap, err := c.kubeClient.CoreV1().ConfigMaps(c.namespace).Get(ctx, c.statusName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get status config map; %v", err)
+	}
+
+	status := Status{}
+	if err := json.Unmarshal([]byte(configMap.Data[StatusKey]), &status); err != nil {
+		t.Fatalf("failed to unmarshal status; %v", err)
+	}
+
+	return status
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/metrics"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+const (
+	// ConditionOvercommitted is the status condition which is true while the running windows instances use more
+	// vCPUs than the licensed nodes provide.
+	ConditionOvercommitted = "Overcommitted"
+
+	// ConditionCompliant is the status condition which is true while no windows instances are running on
+	// unlicensed nodes.
+	ConditionCompliant = "Compliant"
+
+	// ReasonOvercommitted is the event and condition reason while the licensed capacity is overcommitted.
+	ReasonOvercommitted = "Overcommitted"
+
+	// ReasonWithinCapacity is the event and condition reason while the licensed capacity is not overcommitted.
+	ReasonWithinCapacity = "WithinCapacity"
+
+	// ReasonNoViolations is the condition reason while no windows instances are running on unlicensed nodes.
+	ReasonNoViolations = "NoViolations"
+)
+
+// Capacity represents the licensed vCPU capacity and the vCPUs used by running windows instances.
+type Capacity struct {
+	Total         int `json:"total"`
+	Used          int `json:"used"`
+	Overcommitted int `json:"overcommitted"`
+}
+
+// calculateCapacity returns the vCPU capacity of the schedulable nodes matching the node filter and the vCPUs used by
+// running windows instances.  Instances are detected with the same filter as the webhook, but unlike the webhook,
+// unschedulable nodes are excluded as a cordoned or drained node is not able to run the instances counted against it,
+// and finished or duplicate instances are excluded as they no longer use any capacity.
+func calculateCapacity(
+	instances []*kubevirtv1.VirtualMachineInstance,
+	nodes map[string]*corev1.Node,
+	filter resources.NodeFilter,
+	countByLabel bool,
+) Capacity {
+	var licensed resources.Nodes
+
+	for _, node := range nodes {
+		if !node.Spec.Unschedulable && resources.MatchesNodeFilter(node, filter) {
+			licensed = append(licensed, *node)
+		}
+	}
+
+	running := resources.VirtualMachineInstances{}
+	for _, instance := range instances {
+		if !instance.IsFinal() {
+			running = append(running, *instance)
+		}
+	}
+
+	capacity := Capacity{
+		Total: licensed.SumCPU(),
+		Used:  running.Filter(&resources.VirtualMachineInstancesFilter{ByLabel: countByLabel}).Unique().SumCPU(),
+	}
+
+	if capacity.Used > capacity.Total {
+		capacity.Overcommitted = capacity.Used - capacity.Total
+	}
+
+	return capacity
+}
+
+// reconcileCapacity compares the licensed capacity against the vCPUs used by windows instances and reports any
+// overcommit as a metric, an event on the status config map and a status condition.  An event is recorded when the
+// cluster becomes overcommitted, whenever the amount of overcommit changes and once the overcommit is resolved.
+func (c *Compliance) reconcileCapacity(
+	instances []*kubevirtv1.VirtualMachineInstance,
+	nodes map[string]*corev1.Node,
+) {
+	previous := c.capacity
+	c.capacity = calculateCapacity(instances, nodes, c.nodeFilter, c.countByLabel)
+
+	metrics.CapacityTotal.Set(float64(c.capacity.Total))
+	metrics.CapacityUsed.Set(float64(c.capacity.Used))
+	metrics.CapacityOvercommitted.Set(float64(c.capacity.Overcommitted))
+
+	condition := metav1.Condition{
+		Type:    ConditionOvercommitted,
+		Status:  metav1.ConditionFalse,
+		Reason:  ReasonWithinCapacity,
+		Message: capacityMessage(c.capacity),
+	}
+
+	if c.capacity.Overcommitted > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonOvercommitted
+	}
+
+	meta.SetStatusCondition(&c.conditions, condition)
+
+	switch {
+	case c.capacity.Overcommitted > 0 && c.capacity.Overcommitted != previous.Overcommitted:
+		c.logger.Warn().
+			Int("total", c.capacity.Total).
+			Int("used", c.capacity.Used).
+			Int("overcommitted", c.capacity.Overcommitted).
+			Msg("windows instances exceed licensed capacity")
+
+		c.recorder.Event(c.statusReference(), corev1.EventTypeWarning, ReasonOvercommitted, condition.Message)
+	case c.capacity.Overcommitted == 0 && previous.Overcommitted > 0:
+		c.logger.Info().Msg("windows instances are within licensed capacity")
+
+		c.recorder.Event(c.statusReference(), corev1.EventTypeNormal, ReasonWithinCapacity, condition.Message)
+	}
+}
+
+// capacityMessage returns a human-readable message describing the capacity.
+func capacityMessage(capacity Capacity) string {
+	if capacity.Overcommitted > 0 {
+		return fmt.Sprintf(
+			"windows instances use [%d] vCPUs which exceeds licensed capacity [%d] by [%d] vCPUs",
+			capacity.Used, capacity.Total, capacity.Overcommitted,
+		)
+	}
+
+	return fmt.Sprintf("windows instances use [%d] of [%d] licensed vCPUs", capacity.Used, capacity.Total)
+}
+
+// statusReference returns a reference to the status config map, which cluster-wide events are recorded against.
+func (c *Compliance) statusReference() *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Namespace:  c.namespace,
+		Name:       c.statusName,
+	}
+}
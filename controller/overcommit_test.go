@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+func testNodeWithCPU(name, imageType string, cpu int64, unschedulable bool) *corev1.Node {
+	node := testNode(name, imageType)
+	node.Spec.Unschedulable = unschedulable
+	node.Status.Capacity = corev1.ResourceList{corev1.ResourceCPU: *resource.NewQuantity(cpu, resource.DecimalSI)}
+
+	return node
+}
+
+func testInstanceWithCPU(name string, cores uint32, phase kubevirtv1.VirtualMachineInstancePhase) *kubevirtv1.VirtualMachineInstance {
+	instance := testInstance(name, "licensed", true, phase)
+	instance.Spec.Domain.CPU = &kubevirtv1.CPU{Sockets: 1, Cores: cores, Threads: 1}
+
+	return instance
+}
+
+func Test_calculateCapacity(t *testing.T) {
+	t.Parallel()
+
+	nodes := map[string]*corev1.Node{
+		"licensed":   testNodeWithCPU("licensed", "windows", 8, false),
+		"cordoned":   testNodeWithCPU("cordoned", "windows", 8, true),
+		"unlicensed": testNodeWithCPU("unlicensed", "linux", 8, false),
+	}
+
+	instances := []*kubevirtv1.VirtualMachineInstance{
+		testInstanceWithCPU("running-a", 6, kubevirtv1.Running),
+		testInstanceWithCPU("running-b", 4, kubevirtv1.Running),
+		testInstanceWithCPU("failed", 4, kubevirtv1.Failed),
+	}
+
+	got := calculateCapacity(instances, nodes, resources.NewNodeFilter("", ""), true)
+
+	want := Capacity{Total: 8, Used: 10, Overcommitted: 2}
+	if got != want {
+		t.Errorf("calculateCapacity() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCompliance_reconcileCapacity(t *testing.T) {
+	t.Parallel()
+
+	recorder := record.NewFakeRecorder(10)
+
+	c := &Compliance{
+		kubeClient:   fake.NewSimpleClientset(),
+		nodeFilter:   resources.NewNodeFilter("", ""),
+		recorder:     recorder,
+		logger:       zerolog.Nop(),
+		namespace:    "test-namespace",
+		statusName:   DefaultStatusName,
+		countByLabel: true,
+		instances:    cache.NewStore(cache.MetaNamespaceKeyFunc),
+		nodes:        cache.NewStore(cache.MetaNamespaceKeyFunc),
+		violations:   map[string]Violation{},
+	}
+
+	_ = c.nodes.Add(testNodeWithCPU("licensed", "windows", 4, false))
+	_ = c.instances.Add(testInstanceWithCPU("windows", 6, kubevirtv1.Running))
+
+	// reconcile twice to ensure that an unchanged overcommit is only reported once
+	for i := 0; i < 2; i++ {
+		if err := c.reconcile(context.Background()); err != nil {
+			t.Fatalf("Compliance.reconcile() error = %v", err)
+		}
+	}
+
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Compliance.reconcile() recorded %d events, want 1", len(recorder.Events))
+	}
+
+	if event := <-recorder.Events; event != "Warning Overcommitted windows instances use [6] vCPUs which exceeds licensed capacity [4] by [2] vCPUs" {
+		t.Errorf("Compliance.reconcile() event = %s, want overcommitted by 2 vCPUs", event)
+	}
+
+	if !meta.IsStatusConditionTrue(c.conditions, ConditionOvercommitted) {
+		t.Errorf("Compliance.reconcile() conditions = %+v, want overcommitted", c.conditions)
+	}
+
+	// ensure the overcommit is resolved once capacity is added
+	_ = c.nodes.Add(testNodeWithCPU("added", "windows", 4, false))
+
+	if err := c.reconcile(context.Background()); err != nil {
+		t.Fatalf("Compliance.reconcile() error = %v", err)
+	}
+
+	if !meta.IsStatusConditionFalse(c.conditions, ConditionOvercommitted) {
+		t.Errorf("Compliance.reconcile() conditions = %+v, want not overcommitted", c.conditions)
+	}
+
+	if event := <-recorder.Events; event != "Normal WithinCapacity windows instances use [6] of [8] licensed vCPUs" {
+		t.Errorf("Compliance.reconcile() event = %s, want within capacity", event)
+	}
+
+	status := readStatus(context.Background(), t, c)
+	if status.Capacity != (Capacity{Total: 8, Used: 6}) || len(status.Conditions) != 2 {
+		t.Errorf("Compliance.reconcile() status = %+v, want capacity and conditions", status)
+	}
+}
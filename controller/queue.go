@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/metrics"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+const (
+	// ReasonStartedFromQueue is the event reason for a queued windows virtual machine which was started.
+	ReasonStartedFromQueue = "StartedFromQueue"
+
+	// startingTimeout is how long the capacity of a started virtual machine is reserved while waiting for its
+	// instance to be observed.
+	startingTimeout = 2 * time.Minute
+)
+
+// starting represents a virtual machine which was started from the queue but whose instance has not yet been
+// observed, so that its capacity is not given to another queued virtual machine.
+type starting struct {
+	vcpus int
+	at    time.Time
+}
+
+// reconcileQueue starts queued windows virtual machines in order while capacity is available.  The queue is strictly
+// ordered, so a virtual machine which does not fit blocks those behind it.  The position of each virtual machine which
+// remains queued is updated in its annotations and in metrics.
+func (c *Compliance) reconcileQueue(ctx context.Context, instances []*kubevirtv1.VirtualMachineInstance) error {
+	vms := make([]*kubevirtv1.VirtualMachine, 0, len(c.vms.List()))
+	for _, object := range c.vms.List() {
+		vms = append(vms, object.(*kubevirtv1.VirtualMachine))
+	}
+
+	// release the reserved capacity of started virtual machines once their instances are counted
+	running := map[string]bool{}
+	for _, instance := range instances {
+		running[instance.Namespace+"/"+instance.Name] = true
+	}
+
+	available := c.capacity.Total - c.capacity.Used
+
+	for key, start := range c.starting {
+		if running[key] || time.Since(start.at) > startingTimeout {
+			delete(c.starting, key)
+
+			continue
+		}
+
+		available -= start.vcpus
+	}
+
+	classes := map[string]int32{}
+	for _, object := range c.priorityClasses.List() {
+		class := object.(*schedulingv1.PriorityClass)
+		classes[class.Name] = class.Value
+	}
+
+	queued := resources.QueuedVirtualMachines(vms, c.queueOrder, classes)
+
+	position := 0
+	blocked := false
+	positions := map[types.NamespacedName]bool{}
+
+	for _, vm := range queued {
+		vcpus := resources.VirtualMachines{*vm}.SumCPU()
+
+		if !blocked && vcpus <= available {
+			if err := c.startQueued(ctx, vm); err != nil {
+				return err
+			}
+
+			available -= vcpus
+			c.starting[vm.Namespace+"/"+vm.Name] = starting{vcpus: vcpus, at: time.Now()}
+
+			continue
+		}
+
+		blocked = true
+		position++
+
+		key := types.NamespacedName{Namespace: vm.Namespace, Name: vm.Name}
+		positions[key] = true
+		metrics.QueuePosition.WithLabelValues(key.Namespace, key.Name).Set(float64(position))
+
+		if err := c.setQueuePosition(ctx, vm, position); err != nil {
+			return err
+		}
+	}
+
+	// remove the positions of the virtual machines which have left the queue
+	for key := range c.queuePositions {
+		if !positions[key] {
+			metrics.QueuePosition.DeleteLabelValues(key.Namespace, key.Name)
+		}
+	}
+
+	c.queuePositions = positions
+
+	metrics.QueueLength.Set(float64(position))
+
+	return nil
+}
+
+// startQueued starts a queued virtual machine with its original run strategy and removes its queue metadata.
+func (c *Compliance) startQueued(ctx context.Context, vm *kubevirtv1.VirtualMachine) error {
+	strategy := kubevirtv1.VirtualMachineRunStrategy(vm.Annotations[resources.QueuedRunStrategyAnnotation])
+	if !resources.StartsAutomatically(strategy) {
+		strategy = kubevirtv1.RunStrategyAlways
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"labels": map[string]any{resources.QueuedLabelKey: nil},
+			"annotations": map[string]any{
+				resources.QueuedAtAnnotation:          nil,
+				resources.QueuedRunStrategyAnnotation: nil,
+				resources.QueuePositionAnnotation:     nil,
+			},
+		},
+		"spec": map[string]any{"runStrategy": strategy},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal start patch; %w", err)
+	}
+
+	if _, err := c.virtClient.VirtualMachine(vm.Namespace).Patch(ctx, vm.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to start queued virtual machine [%s/%s]; %w", vm.Namespace, vm.Name, err)
+	}
+
+	metrics.QueueStarted.Inc()
+
+	c.logger.Info().Str("namespace", vm.Namespace).Str("name", vm.Name).Msg("started queued windows virtual machine")
+	c.recorder.Eventf(vm, corev1.EventTypeNormal, ReasonStartedFromQueue,
+		"licensed capacity is available; started with run strategy [%s]", strategy,
+	)
+
+	return nil
+}
+
+// setQueuePosition updates the queue position annotation of a queued virtual machine, if it has changed.
+func (c *Compliance) setQueuePosition(ctx context.Context, vm *kubevirtv1.VirtualMachine, position int) error {
+	value := strconv.Itoa(position)
+	if vm.Annotations[resources.QueuePositionAnnotation] == value {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"annotations": map[string]any{resources.QueuePositionAnnotation: value}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal queue position patch; %w", err)
+	}
+
+	if _, err := c.virtClient.VirtualMachine(vm.Namespace).Patch(ctx, vm.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to set queue position of virtual machine [%s/%s]; %w", vm.Namespace, vm.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+	kubevirtfake "kubevirt.io/client-go/kubevirt/fake"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/metrics"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+func testQueuedVM(name, queuedAt string) *kubevirtv1.VirtualMachine {
+	return &kubevirtv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "test",
+			Labels:      map[string]string{resources.QueuedLabelKey: resources.QueuedLabelValue},
+			Annotations: map[string]string{resources.QueuedAtAnnotation: queuedAt},
+		},
+		Spec: kubevirtv1.VirtualMachineSpec{Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{}},
+	}
+}
+
+func TestCompliance_reconcileQueue(t *testing.T) {
+	t.Parallel()
+
+	first := testQueuedVM("first", "2026-01-01T00:01:00Z")
+	second := testQueuedVM("second", "2026-01-01T00:02:00Z")
+
+	virtClient := kubevirtfake.NewSimpleClientset(first, second)
+
+	mockVirtClient := kubecli.NewMockKubevirtClient(gomock.NewController(t))
+	mockVirtClient.EXPECT().VirtualMachine(gomock.Any()).DoAndReturn(
+		func(namespace string) kubecli.VirtualMachineInterface {
+			return virtClient.KubevirtV1().VirtualMachines(namespace)
+		},
+	).AnyTimes()
+
+	// there is no capacity available, so both virtual machines remain queued
+	c := &Compliance{
+		virtClient:      mockVirtClient,
+		logger:          zerolog.Nop(),
+		queueOrder:      resources.QueueOrderFIFO,
+		vms:             cache.NewStore(cache.MetaNamespaceKeyFunc),
+		priorityClasses: cache.NewStore(cache.MetaNamespaceKeyFunc),
+		capacity:        Capacity{Total: 4, Used: 4},
+		starting:        map[string]starting{},
+	}
+
+	_ = c.vms.Add(first)
+	_ = c.vms.Add(second)
+
+	if err := c.reconcileQueue(context.Background(), nil); err != nil {
+		t.Fatalf("Compliance.reconcileQueue() error = %v", err)
+	}
+
+	for name, want := range map[string]float64{"first": 1, "second": 2} {
+		if got := testutil.ToFloat64(metrics.QueuePosition.WithLabelValues("test", name)); got != want {
+			t.Errorf("Compliance.reconcileQueue() position of [%s] = %v, want %v", name, got, want)
+		}
+	}
+
+	// ensure the position of a virtual machine which has left the queue is no longer reported
+	_ = c.vms.Delete(first)
+
+	if err := c.reconcileQueue(context.Background(), nil); err != nil {
+		t.Fatalf("Compliance.reconcileQueue() error = %v", err)
+	}
+
+	if got := testutil.CollectAndCount(metrics.QueuePosition); got != 1 {
+		t.Errorf("Compliance.reconcileQueue() reported %d queue positions, want 1", got)
+	}
+
+	if got := testutil.ToFloat64(metrics.QueuePosition.WithLabelValues("test", "second")); got != 1 {
+		t.Errorf("Compliance.reconcileQueue() position of [second] = %v, want 1", got)
+	}
+}
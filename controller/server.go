@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/metrics"
+)
+
+const (
+	// EnvServerAddress is the address on which the controller serves its health checks and metrics.
+	EnvServerAddress = "WEBHOOK_COMPLIANCE_ADDRESS"
+
+	DefaultServerAddress = ":8080"
+
+	readHeaderTimeout = 5 * time.Second
+	shutdownTimeout   = 15 * time.Second
+)
+
+// Serve serves the health checks and metrics of the controller until the context is cancelled.
+func (c *Compliance) Serve(ctx context.Context) error {
+	address := envOrDefault(EnvServerAddress, DefaultServerAddress)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if err := c.Ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.Handle("/metrics", metrics.Handler())
+
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: readHeaderTimeout}
+
+	errs := make(chan error, 1)
+
+	go func() {
+		c.logger.Info().Msgf("Starting controller server on %s", address)
+
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			errs <- err
+		}
+
+		close(errs)
+	}()
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("controller server failed; %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shutdown controller server; %w", err)
+	}
+
+	return <-errs
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// EnvStatusName is the name of the config map which holds the compliance status.
+	EnvStatusName = "WEBHOOK_COMPLIANCE_STATUS_NAME"
+
+	DefaultStatusName = "windows-overcommit-compliance"
+
+	// StatusKey is the key of the compliance status within the status config map.
+	StatusKey = "status.json"
+)
+
+// Status represents the compliance status which is stored in the status config map.
+type Status struct {
+	Compliant      bool               `json:"compliant"`
+	StopViolations bool               `json:"stopViolations"`
+	Violations     []Violation        `json:"violations"`
+	Capacity       Capacity           `json:"capacity"`
+	Conditions     []metav1.Condition `json:"conditions"`
+}
+
+// updateStatus writes the current violations, capacity and conditions to the status config map, if they have changed
+// since they were last written.
+func (c *Compliance) updateStatus(ctx context.Context) error {
+	status := Status{
+		Compliant:      len(c.violations) == 0,
+		StopViolations: c.stopViolations,
+		Violations:     make([]Violation, 0, len(c.violations)),
+		Capacity:       c.capacity,
+		Conditions:     c.conditions,
+	}
+
+	for _, violation := range c.violations {
+		status.Violations = append(status.Violations, violation)
+	}
+
+	sort.Slice(status.Violations, func(i, j int) bool {
+		return status.Violations[i].key() < status.Violations[j].key()
+	})
+
+	content, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to marshal compliance status; %w", err)
+	}
+
+	if string(content) == c.status {
+		return nil
+	}
+
+	configMaps := c.kubeClient.CoreV1().ConfigMaps(c.namespace)
+
+	configMap, err := configMaps.Get(ctx, c.statusName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: c.statusName, Namespace: c.namespace},
+			Data:       map[string]string{StatusKey: string(content)},
+		}, metav1.CreateOptions{})
+	case err == nil:
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+
+		configMap.Data[StatusKey] = string(content)
+
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to write compliance status to config map [%s/%s]; %w", c.namespace, c.statusName, err)
+	}
+
+	c.status = string(content)
+
+	return nil
+}
@@ -1,22 +1,89 @@
 package main
 
 import (
+	"context"
 	"log"
-	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/scottd018/rosa-windows-overcommit-webhook/certs"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/controller"
 	"github.com/scottd018/rosa-windows-overcommit-webhook/webhook"
 )
 
+const commandController = "controller"
+
 func main() {
+	// stop gracefully when we are asked to terminate
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// run the compliance controller or an offline command rather than the webhook when requested
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case commandController:
+			runController(ctx)
+
+			return
+		case commandEvaluate:
+			if err := runEvaluate(ctx, os.Args[2:]); err != nil {
+				log.Fatalf("failed to evaluate admission review: %v", err)
+			}
+
+			return
+		case commandReplay:
+			if err := runReplay(ctx, os.Args[2:]); err != nil {
+				log.Fatalf("failed to replay admission reviews: %v", err)
+			}
+
+			return
+		case commandVerifyAudit:
+			if err := runVerifyAudit(ctx, os.Args[2:]); err != nil {
+				log.Fatalf("failed to verify audit records: %v", err)
+			}
+
+			return
+		}
+	}
+
+	runWebhook(ctx)
+}
+
+// runWebhook runs the webhook server until the context is cancelled.
+func runWebhook(ctx context.Context) {
 	// create the webhook
 	w, err := webhook.NewWebhook()
 	if err != nil {
 		log.Fatalf("failed to create webhook: %v", err)
 	}
 
-	// set the handlers and start
-	http.HandleFunc("/validate", w.Validate)
-	http.HandleFunc("/healthz", w.HealthZ)
-	w.Logger.Info().Msg("Starting webhook server on :8443")
-	w.Logger.Fatal().Msg(http.ListenAndServeTLS(":8443", "/ssl_certs/tls.crt", "/ssl_certs/tls.key", nil).Error())
+	// start the certificate manager
+	certManager := certs.NewManager(w.KubeClient, w.Logger)
+	if err := certManager.Start(ctx); err != nil {
+		log.Fatalf("failed to start certificate manager: %v", err)
+	}
+
+	// run the server until we are asked to terminate
+	if err := webhook.NewServer(w, certManager).Run(ctx); err != nil {
+		w.Logger.Fatal().Msg(err.Error())
+	}
+}
+
+// runController runs the compliance controller until the context is cancelled.
+func runController(ctx context.Context) {
+	compliance, err := controller.NewCompliance()
+	if err != nil {
+		log.Fatalf("failed to create compliance controller: %v", err)
+	}
+
+	go func() {
+		if err := compliance.Serve(ctx); err != nil {
+			log.Fatalf("failed to serve compliance controller: %v", err)
+		}
+	}()
+
+	if err := compliance.Run(ctx); err != nil {
+		log.Fatalf("failed to run compliance controller: %v", err)
+	}
 }
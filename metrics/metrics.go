@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "windows_overcommit"
+
+// registry is the registry which all metrics for this project are registered to.
+var registry = prometheus.NewRegistry()
+
+var (
+	// DecisionCacheRequests counts lookups of the decision cache by result (hit or miss).  The hit rate of the cache
+	// is derived from this metric.
+	DecisionCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "webhook",
+		Name:      "decision_cache_requests_total",
+		Help:      "Total number of decision cache lookups by result.",
+	}, []string{"result"})
+
+	// DecisionCacheEntries is the number of decisions currently held in the decision cache.
+	DecisionCacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "webhook",
+		Name:      "decision_cache_entries",
+		Help:      "Number of decisions currently held in the decision cache.",
+	})
+
+	// ComplianceViolations is set for each windows virtual machine instance which is running on a node outside of
+	// the licensed node pool.
+	ComplianceViolations = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "compliance",
+		Name:      "violation",
+		Help:      "Windows virtual machine instances running on unlicensed nodes.",
+	}, []string{"namespace", "name", "node"})
+
+	// ComplianceStops counts the windows virtual machine instances which were stopped for running on unlicensed
+	// nodes, by result (success or failure).
+	ComplianceStops = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "compliance",
+		Name:      "stops_total",
+		Help:      "Total number of stops of windows virtual machine instances running on unlicensed nodes by result.",
+	}, []string{"result"})
+
+	// CapacityTotal is the vCPU capacity of the schedulable licensed nodes.
+	CapacityTotal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "capacity",
+		Name:      "total_vcpus",
+		Help:      "vCPU capacity of the schedulable licensed nodes.",
+	})
+
+	// CapacityUsed is the number of vCPUs used by running windows virtual machine instances.
+	CapacityUsed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "capacity",
+		Name:      "used_vcpus",
+		Help:      "vCPUs used by running windows virtual machine instances.",
+	})
+
+	// CapacityOvercommitted is the number of vCPUs by which running windows virtual machine instances exceed the
+	// licensed capacity, or zero if they do not.
+	CapacityOvercommitted = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "capacity",
+		Name:      "overcommitted_vcpus",
+		Help:      "vCPUs by which running windows virtual machine instances exceed the licensed capacity.",
+	})
+
+	// QueueLength is the number of windows virtual machines which are queued for capacity.
+	QueueLength = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "length",
+		Help:      "Number of windows virtual machines queued for capacity.",
+	})
+
+	// QueuePosition is the position of each queued windows virtual machine in the queue, starting at 1.  A series is
+	// only reported while its virtual machine is queued, so the number of series is bounded by the queue length.
+	QueuePosition = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "position",
+		Help:      "Position of each windows virtual machine queued for capacity.",
+	}, []string{"namespace", "name"})
+
+	// QueueStarted counts the queued windows virtual machines which were started once capacity was available.
+	QueueStarted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "started_total",
+		Help:      "Total number of queued windows virtual machines started once capacity was available.",
+	})
+
+	// RecorderRecords counts the admission requests seen by the recorder by result (recorded, skipped, dropped or
+	// failed).  Requests are skipped when they are not sampled and dropped when the recordings are full.
+	RecorderRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "recorder",
+		Name:      "records_total",
+		Help:      "Total number of admission requests seen by the recorder by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		DecisionCacheRequests,
+		DecisionCacheEntries,
+		ComplianceViolations,
+		ComplianceStops,
+		CapacityTotal,
+		CapacityUsed,
+		CapacityOvercommitted,
+		QueueLength,
+		QueuePosition,
+		QueueStarted,
+		RecorderRecords,
+	)
+}
+
+// Handler returns the http handler which serves the metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
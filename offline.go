@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/replay"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/snapshot"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/webhook"
+)
+
+const (
+	commandEvaluate = "evaluate"
+	commandReplay   = "replay"
+)
+
+// snapshotPaths represents the paths of a snapshot, given by a flag which may be repeated or comma-separated.
+type snapshotPaths []string
+
+// String returns the paths as they are given to the flag.
+func (paths *snapshotPaths) String() string {
+	return strings.Join(*paths, ",")
+}
+
+// Set adds the paths of a flag value.
+func (paths *snapshotPaths) Set(value string) error {
+	*paths = append(*paths, strings.Split(value, ",")...)
+
+	return nil
+}
+
+// runEvaluate runs the validation logic of the webhook against an AdmissionReview using the objects of a cluster
+// snapshot rather than a live cluster, writing the AdmissionReview response to stdout.  The webhook is configured
+// from the environment as it is when serving, so the configuration of the cluster may be reproduced.
+func runEvaluate(ctx context.Context, args []string) error {
+	var (
+		paths  snapshotPaths
+		review string
+	)
+
+	flags := flag.NewFlagSet(commandEvaluate, flag.ContinueOnError)
+	flags.Var(&paths, "snapshot", "snapshot files or directories of nodes, virtual machine instances, virtual machines and priority classes (repeatable)")
+	flags.StringVar(&review, "review", "-", "file containing the AdmissionReview to evaluate, or - for stdin")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+
+		return err
+	}
+
+	if len(paths) == 0 {
+		return errors.New("at least one snapshot path must be given with --snapshot")
+	}
+
+	w, err := newOfflineWebhook(paths, zerolog.InfoLevel)
+	if err != nil {
+		return err
+	}
+
+	body, err := readInput(review)
+	if err != nil {
+		return err
+	}
+
+	response, err := w.Evaluate(ctx, body)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(os.Stdout, string(response))
+
+	return err
+}
+
+// runReplay replays a JSONL file of AdmissionReviews through the validation logic of the webhook using the objects of
+// a cluster snapshot, writing the result of each as JSONL to stdout.  Differences from the recorded responses are also
+// written to stderr, and an error is returned if any review did not match or could not be replayed.
+func runReplay(ctx context.Context, args []string) error {
+	var (
+		paths   snapshotPaths
+		reviews string
+		opts    replay.Options
+	)
+
+	flags := flag.NewFlagSet(commandReplay, flag.ContinueOnError)
+	flags.Var(&paths, "snapshot", "snapshot files or directories of nodes, virtual machine instances, virtual machines and priority classes (repeatable)")
+	flags.StringVar(&reviews, "reviews", "-", "JSONL file of AdmissionReviews to replay, or - for stdin")
+	flags.BoolVar(&opts.CompareMessages, "compare-messages", false, "compare the messages of decisions in addition to whether they were allowed and their codes")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+
+		return err
+	}
+
+	if len(paths) == 0 {
+		return errors.New("at least one snapshot path must be given with --snapshot")
+	}
+
+	w, err := newOfflineWebhook(paths, zerolog.WarnLevel)
+	if err != nil {
+		return err
+	}
+
+	input := os.Stdin
+	if reviews != "-" {
+		if input, err = os.Open(reviews); err != nil {
+			return fmt.Errorf("failed to open [%s]; %w", reviews, err)
+		}
+		defer input.Close()
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+
+	summary, err := replay.Replay(ctx, w, input, opts, func(result *replay.Result) error {
+		switch {
+		case result.Error != "":
+			fmt.Fprintf(os.Stderr, "ERROR line %d [%s]: %s\n", result.Line, result.UID, result.Error)
+		case len(result.Differences) > 0:
+			fmt.Fprintf(os.Stderr, "DIFF  line %d [%s] %s %s/%s:\n", result.Line, result.UID, result.Kind, result.Namespace, result.Name)
+
+			for _, difference := range result.Differences {
+				fmt.Fprintf(os.Stderr, "        %s\n", difference)
+			}
+		}
+
+		return encoder.Encode(result)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "replayed %d reviews: %d matched, %d mismatched, %d without expected response, %d errors\n",
+		summary.Total, summary.Matched, summary.Mismatched, summary.Unexpected, summary.Errors,
+	)
+
+	if !summary.Passed() {
+		return fmt.Errorf("%d reviews did not match and %d could not be replayed", summary.Mismatched, summary.Errors)
+	}
+
+	return nil
+}
+
+// newOfflineWebhook returns a webhook which uses the clients of a snapshot loaded from the given paths.  The webhook
+// logs to stderr at the given level, or at debug level if debugging is enabled, so that its output may be separated
+// from the results.
+func newOfflineWebhook(paths []string, level zerolog.Level) (replay.Evaluator, error) {
+	s, err := snapshot.Load(paths...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load snapshot; %w", err)
+	}
+
+	logger := zerolog.New(os.Stderr)
+
+	for _, skipped := range s.Skipped {
+		logger.Warn().Str("file", skipped).Msg("skipped snapshot file which could not be decoded")
+	}
+
+	logger.Info().
+		Int("nodes", len(s.Nodes)).
+		Int("virtual_machine_instances", len(s.VirtualMachineInstances)).
+		Int("virtual_machines", len(s.VirtualMachines)).
+		Int("priority_classes", len(s.PriorityClasses)).
+		Msg("loaded snapshot")
+
+	// offline decisions must never be recorded or audited alongside those of the webhook, for example when run within
+	// its pod, as they are not real decisions and would break the chain of audit records
+	os.Unsetenv(webhook.EnvRecorder)
+	os.Unsetenv(webhook.EnvAudit)
+
+	w, err := webhook.NewWebhookForClients(s.Clients())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook; %w", err)
+	}
+
+	if w.Logger.GetLevel() > zerolog.DebugLevel {
+		w.Logger = zerolog.New(os.Stderr).Level(level)
+	} else {
+		w.Logger = zerolog.New(os.Stderr).Level(zerolog.DebugLevel)
+	}
+
+	return w, nil
+}
+
+// readInput reads a file, or stdin if the file is -.
+func readInput(file string) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read [%s]; %w", file, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,226 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CapacityPath = "/v1/capacity"
+	CheckPath    = "/v1/check"
+	ExplainPath  = "/v1/explain"
+
+	DefaultTimeout = 30 * time.Second
+
+	// maxResponseBytes is the maximum size of a response which is read from the API.
+	maxResponseBytes = 4 * 1024 * 1024
+)
+
+// Config represents the configuration of a client.
+type Config struct {
+	// Host is the base URL of the webhook, for example https://windows-overcommit-webhook.windows-overcommit-webhook.svc.
+	Host string
+
+	// BearerToken is the token which authenticates the caller.
+	BearerToken string
+
+	// BearerTokenFile is a file containing the token which authenticates the caller.  It is read for each request so
+	// that rotated tokens, such as projected service account tokens, are picked up.  It takes precedence over
+	// BearerToken if both are set.
+	BearerTokenFile string
+
+	// CAData is the PEM encoded CA which signed the serving certificate of the webhook.  The system roots are trusted
+	// if it is empty.
+	CAData []byte
+
+	// Timeout is the timeout of each request.  DefaultTimeout is used if it is zero.
+	Timeout time.Duration
+
+	// Transport overrides the transport of the client, in which case CAData is ignored.
+	Transport http.RoundTripper
+}
+
+// Client represents a client of the capacity API of the webhook.
+type Client struct {
+	baseURL    *url.URL
+	token      string
+	tokenFile  string
+	httpClient *http.Client
+}
+
+// Error represents an error which was returned by the API.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+// Error returns the error as a string.
+func (e *Error) Error() string {
+	return fmt.Sprintf("request failed with status [%d]; %s", e.StatusCode, e.Message)
+}
+
+// New returns a new instance of a client given its configuration.
+func New(config *Config) (*Client, error) {
+	baseURL, err := url.Parse(config.Host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host [%s]; %w", config.Host, err)
+	}
+
+	if baseURL.Scheme == "" || baseURL.Host == "" {
+		return nil, fmt.Errorf("invalid host [%s]; must be an absolute url", config.Host)
+	}
+
+	transport := config.Transport
+	if transport == nil {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if len(config.CAData) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(config.CAData) {
+				return nil, errors.New("invalid ca data; no certificates found")
+			}
+
+			tlsConfig.RootCAs = pool
+		}
+
+		defaultTransport, _ := http.DefaultTransport.(*http.Transport)
+
+		httpTransport := defaultTransport.Clone()
+		httpTransport.TLSClientConfig = tlsConfig
+		transport = httpTransport
+	}
+
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Client{
+		baseURL:    baseURL,
+		token:      config.BearerToken,
+		tokenFile:  config.BearerTokenFile,
+		httpClient: &http.Client{Transport: transport, Timeout: timeout},
+	}, nil
+}
+
+// Capacity returns the licensed capacity, broken down by pool and by the namespaces which the caller may see.
+func (c *Client) Capacity(ctx context.Context) (*CapacityResponse, error) {
+	capacity := &CapacityResponse{}
+	if err := c.do(ctx, http.MethodGet, CapacityPath, nil, nil, capacity); err != nil {
+		return nil, err
+	}
+
+	return capacity, nil
+}
+
+// Check returns the decision that the webhook would make for a virtual machine or virtual machine instance, without
+// reserving any capacity.  The object is encoded as JSON, so it may be a typed object, an unstructured object or an
+// already encoded manifest as a json.RawMessage.
+func (c *Client) Check(ctx context.Context, object any) (*Decision, error) {
+	manifest, err := json.Marshal(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode object; %w", err)
+	}
+
+	decision := &Decision{}
+	if err := c.do(ctx, http.MethodPost, CheckPath, nil, manifest, decision); err != nil {
+		return nil, err
+	}
+
+	return decision, nil
+}
+
+// Explain returns the top consumers of the licensed capacity which the caller may see.
+func (c *Client) Explain(ctx context.Context, request *ExplainRequest) (*ExplainResponse, error) {
+	query := url.Values{}
+	if request != nil && request.Top > 0 {
+		query.Set("top", strconv.Itoa(request.Top))
+	}
+
+	explanation := &ExplainResponse{}
+	if err := c.do(ctx, http.MethodGet, ExplainPath, query, nil, explanation); err != nil {
+		return nil, err
+	}
+
+	return explanation, nil
+}
+
+// do sends a request to the API and decodes the response into the result, returning an *Error if the API returned
+// an unsuccessful status.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body []byte, result any) error {
+	endpoint := c.baseURL.JoinPath(path)
+	endpoint.RawQuery = query.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, method, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request; %w", err)
+	}
+
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	token, err := c.bearerToken()
+	if err != nil {
+		return err
+	}
+
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to send request to [%s]; %w", path, err)
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(io.LimitReader(response.Body, maxResponseBytes))
+	if err != nil {
+		return fmt.Errorf("failed to read response from [%s]; %w", path, err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		apiErr := &Error{StatusCode: response.StatusCode, Message: http.StatusText(response.StatusCode)}
+
+		errorResponse := ErrorResponse{}
+		if json.Unmarshal(responseBody, &errorResponse) == nil && errorResponse.Error != "" {
+			apiErr.Message = errorResponse.Error
+		}
+
+		return apiErr
+	}
+
+	if err := json.Unmarshal(responseBody, result); err != nil {
+		return fmt.Errorf("failed to decode response from [%s]; %w", path, err)
+	}
+
+	return nil
+}
+
+// bearerToken returns the token which authenticates the caller, reading it from the token file if one is set.
+func (c *Client) bearerToken() (string, error) {
+	if c.tokenFile == "" {
+		return c.token, nil
+	}
+
+	token, err := os.ReadFile(c.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read bearer token file [%s]; %w", c.tokenFile, err)
+	}
+
+	return strings.TrimSpace(string(token)), nil
+}
@@ -0,0 +1,274 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+const testToken = "test-token"
+
+// newTestServer returns a tls server which serves the API for the test token, along with a client configuration which
+// trusts it.
+func newTestServer(t *testing.T) (*httptest.Server, *Config) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(CapacityPath, func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, CapacityResponse{
+			Total:      16,
+			Used:       8,
+			Available:  8,
+			Pools:      []PoolCapacity{{Pool: "windows", Nodes: 1, Total: 16, Used: 8}},
+			Namespaces: []NamespaceUsage{{Namespace: "team-a", Instances: 1, Used: 8}},
+		})
+	})
+	mux.HandleFunc(CheckPath, func(w http.ResponseWriter, r *http.Request) {
+		object := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&object); err != nil {
+			writeTestJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		if object["kind"] != "VirtualMachine" {
+			writeTestJSON(w, http.StatusUnprocessableEntity, ErrorResponse{Error: "unsupported kind"})
+			return
+		}
+
+		writeTestJSON(w, http.StatusOK, Decision{Allowed: true, Code: http.StatusOK, Message: "request success"})
+	})
+	mux.HandleFunc(ExplainPath, func(w http.ResponseWriter, r *http.Request) {
+		// echo the requested number of top consumers as others so that the query may be verified
+		top, _ := strconv.Atoi(r.URL.Query().Get("top"))
+
+		writeTestJSON(w, http.StatusOK, ExplainResponse{
+			Consumers: []Consumer{{Namespace: "team-a", VCPUs: 8}},
+			Others:    top,
+		})
+	})
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			writeTestJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthorized"})
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	caData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	return server, &Config{Host: server.URL, BearerToken: testToken, CAData: caData}
+}
+
+func writeTestJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{
+			name:   "ensure a client is created for an absolute url",
+			config: &Config{Host: "https://windows-overcommit-webhook.windows-overcommit-webhook.svc"},
+		},
+		{
+			name:    "ensure a relative url is rejected",
+			config:  &Config{Host: "windows-overcommit-webhook"},
+			wantErr: true,
+		},
+		{
+			name:    "ensure invalid ca data is rejected",
+			config:  &Config{Host: "https://localhost", CAData: []byte("not a certificate")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := New(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClient_Capacity(t *testing.T) {
+	t.Parallel()
+
+	_, config := newTestServer(t)
+
+	c, err := New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	got, err := c.Capacity(context.Background())
+	if err != nil {
+		t.Fatalf("Capacity() error = %v", err)
+	}
+
+	want := &CapacityResponse{
+		Total:      16,
+		Used:       8,
+		Available:  8,
+		Pools:      []PoolCapacity{{Pool: "windows", Nodes: 1, Total: 16, Used: 8}},
+		Namespaces: []NamespaceUsage{{Namespace: "team-a", Instances: 1, Used: 8}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Capacity() = %+v, want %+v", got, want)
+	}
+}
+
+func TestClient_Check(t *testing.T) {
+	t.Parallel()
+
+	_, config := newTestServer(t)
+
+	c, err := New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		object     any
+		wantStatus int
+	}{
+		{
+			name:   "ensure a typed object is checked",
+			object: map[string]any{"apiVersion": "kubevirt.io/v1", "kind": "VirtualMachine"},
+		},
+		{
+			name:   "ensure an encoded manifest is checked",
+			object: json.RawMessage(`{"apiVersion":"kubevirt.io/v1","kind":"VirtualMachine"}`),
+		},
+		{
+			name:       "ensure an api error is returned with its status and message",
+			object:     map[string]any{"apiVersion": "v1", "kind": "Pod"},
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := c.Check(context.Background(), tt.object)
+			if tt.wantStatus != 0 {
+				apiErr := &Error{}
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantStatus || apiErr.Message != "unsupported kind" {
+					t.Fatalf("Check() error = %v, want status %d", err, tt.wantStatus)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+
+			if !got.Allowed {
+				t.Errorf("Check() allowed = false, want true")
+			}
+		})
+	}
+}
+
+func TestClient_Explain(t *testing.T) {
+	t.Parallel()
+
+	_, config := newTestServer(t)
+
+	c, err := New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	got, err := c.Explain(context.Background(), &ExplainRequest{Top: 10})
+	if err != nil {
+		t.Fatalf("Explain() error = %v", err)
+	}
+
+	want := &ExplainResponse{Consumers: []Consumer{{Namespace: "team-a", VCPUs: 8}}, Others: 10}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Explain() = %+v, want %+v", got, want)
+	}
+}
+
+func TestClient_authentication(t *testing.T) {
+	t.Parallel()
+
+	server, config := newTestServer(t)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte(testToken+"\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token file; %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		config     *Config
+		wantStatus int
+		wantErr    bool
+	}{
+		{
+			name:   "ensure the token file is read for each request",
+			config: &Config{Host: server.URL, CAData: config.CAData, BearerTokenFile: tokenFile},
+		},
+		{
+			name:       "ensure an invalid token returns an unauthorized error",
+			config:     &Config{Host: server.URL, CAData: config.CAData, BearerToken: "invalid-token"},
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    true,
+		},
+		{
+			name:    "ensure a server which is not signed by the ca is not trusted",
+			config:  &Config{Host: server.URL, BearerToken: testToken},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c, err := New(tt.config)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			_, err = c.Capacity(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Capacity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			apiErr := &Error{}
+			if tt.wantStatus != 0 && (!errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantStatus) {
+				t.Errorf("Capacity() error = %v, want status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/certs"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/clients"
+)
+
+// DiscoveryOptions represents the names of the objects which the webhook is discovered from.  The names of the
+// default deployment are used for any which are unset.
+type DiscoveryOptions struct {
+	Namespace         string
+	ServiceName       string
+	WebhookConfigName string
+}
+
+// Discover returns the configuration of a client for the webhook service, trusting the CA bundle of the webhook
+// configuration which routes to it.  The returned configuration has no credentials.
+func Discover(ctx context.Context, kubeClient kubernetes.Interface, options *DiscoveryOptions) (*Config, error) {
+	opts := DiscoveryOptions{
+		Namespace:         clients.DefaultNamespace,
+		ServiceName:       certs.DefaultServiceName,
+		WebhookConfigName: certs.DefaultWebhookConfigName,
+	}
+
+	if options != nil {
+		if options.Namespace != "" {
+			opts.Namespace = options.Namespace
+		}
+
+		if options.ServiceName != "" {
+			opts.ServiceName = options.ServiceName
+		}
+
+		if options.WebhookConfigName != "" {
+			opts.WebhookConfigName = options.WebhookConfigName
+		}
+	}
+
+	service, err := kubeClient.CoreV1().Services(opts.Namespace).Get(ctx, opts.ServiceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook service [%s/%s]; %w", opts.Namespace, opts.ServiceName, err)
+	}
+
+	port, err := servicePort(service)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := kubeClient.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(
+		ctx,
+		opts.WebhookConfigName,
+		metav1.GetOptions{},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get validating webhook configuration [%s]; %w", opts.WebhookConfigName, err)
+	}
+
+	// trust the ca bundle of the first webhook which routes to the service
+	var caBundle []byte
+
+	for i := range config.Webhooks {
+		ref := config.Webhooks[i].ClientConfig.Service
+		if ref == nil || ref.Namespace != opts.Namespace || ref.Name != opts.ServiceName {
+			continue
+		}
+
+		if len(config.Webhooks[i].ClientConfig.CABundle) > 0 {
+			caBundle = config.Webhooks[i].ClientConfig.CABundle
+
+			break
+		}
+	}
+
+	if len(caBundle) == 0 {
+		return nil, fmt.Errorf(
+			"no ca bundle found for service [%s/%s] in validating webhook configuration [%s]",
+			opts.Namespace,
+			opts.ServiceName,
+			opts.WebhookConfigName,
+		)
+	}
+
+	return &Config{
+		Host:   fmt.Sprintf("https://%s.%s.svc:%d", opts.ServiceName, opts.Namespace, port),
+		CAData: caBundle,
+	}, nil
+}
+
+// NewInCluster returns a new instance of a client which discovers the webhook service from within the cluster and
+// authenticates with the service account token of the pod.
+func NewInCluster(ctx context.Context, options *DiscoveryOptions) (*Client, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create in-cluster config; %w", err)
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client; %w", err)
+	}
+
+	config, err := Discover(ctx, kubeClient, options)
+	if err != nil {
+		return nil, err
+	}
+
+	config.BearerToken = restConfig.BearerToken
+	config.BearerTokenFile = restConfig.BearerTokenFile
+
+	return New(config)
+}
+
+// servicePort returns the https port of the webhook service, which is the only port or the port named https or
+// 443.
+func servicePort(service *corev1.Service) (int32, error) {
+	if len(service.Spec.Ports) == 1 {
+		return service.Spec.Ports[0].Port, nil
+	}
+
+	for _, port := range service.Spec.Ports {
+		if port.Name == "https" || port.Port == 443 {
+			return port.Port, nil
+		}
+	}
+
+	return 0, errors.New("failed to find https port of webhook service")
+}
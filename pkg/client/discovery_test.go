@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiscover(t *testing.T) {
+	t.Parallel()
+
+	service := func(ports ...corev1.ServicePort) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "windows-overcommit-webhook", Namespace: "windows-overcommit-webhook"},
+			Spec:       corev1.ServiceSpec{Ports: ports},
+		}
+	}
+
+	webhookConfig := func(namespace string, caBundle []byte) *admissionregistrationv1.ValidatingWebhookConfiguration {
+		return &admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "windows-overcommit-webhook"},
+			Webhooks: []admissionregistrationv1.ValidatingWebhook{
+				{
+					Name: "windows-overcommit-webhook.mobb.redhat.com",
+					ClientConfig: admissionregistrationv1.WebhookClientConfig{
+						Service: &admissionregistrationv1.ServiceReference{
+							Name:      "windows-overcommit-webhook",
+							Namespace: namespace,
+						},
+						CABundle: caBundle,
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		objects  []runtime.Object
+		wantHost string
+		wantErr  bool
+	}{
+		{
+			name: "ensure the service and ca bundle are discovered",
+			objects: []runtime.Object{
+				service(corev1.ServicePort{Name: "http", Port: 443}),
+				webhookConfig("windows-overcommit-webhook", []byte("ca")),
+			},
+			wantHost: "https://windows-overcommit-webhook.windows-overcommit-webhook.svc:443",
+		},
+		{
+			name: "ensure the https port is discovered from multiple ports",
+			objects: []runtime.Object{
+				service(corev1.ServicePort{Name: "metrics", Port: 8080}, corev1.ServicePort{Name: "https", Port: 9443}),
+				webhookConfig("windows-overcommit-webhook", []byte("ca")),
+			},
+			wantHost: "https://windows-overcommit-webhook.windows-overcommit-webhook.svc:9443",
+		},
+		{
+			name:    "ensure a missing service returns an error",
+			objects: []runtime.Object{webhookConfig("windows-overcommit-webhook", []byte("ca"))},
+			wantErr: true,
+		},
+		{
+			name: "ensure a webhook configuration without a ca bundle for the service returns an error",
+			objects: []runtime.Object{
+				service(corev1.ServicePort{Name: "http", Port: 443}),
+				webhookConfig("other-namespace", []byte("ca")),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := Discover(context.Background(), fake.NewSimpleClientset(tt.objects...), nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Discover() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if got.Host != tt.wantHost {
+				t.Errorf("Discover() host = %s, want %s", got.Host, tt.wantHost)
+			}
+
+			if string(got.CAData) != "ca" {
+				t.Errorf("Discover() ca data = %s, want ca", got.CAData)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package client
+
+// CapacityResponse represents the response of the capacity API.
+type CapacityResponse struct {
+	Total      int              `json:"total"`
+	Used       int              `json:"used"`
+	Reserved   int              `json:"reserved"`
+	Available  int              `json:"available"`
+	Pools      []PoolCapacity   `json:"pools"`
+	Namespaces []NamespaceUsage `json:"namespaces"`
+}
+
+// PoolCapacity represents the capacity of the licensed nodes with a single value of the node label.
+type PoolCapacity struct {
+	Pool  string `json:"pool"`
+	Nodes int    `json:"nodes"`
+	Total int    `json:"total"`
+	Used  int    `json:"used"`
+}
+
+// NamespaceUsage represents the capacity used by the windows instances in a namespace.
+type NamespaceUsage struct {
+	Namespace string `json:"namespace"`
+	Instances int    `json:"instances"`
+	Used      int    `json:"used"`
+}
+
+// Decision represents the decision of whether a windows instance fits within the available capacity, along with the
+// capacity values that the decision was made with.  It is the response of the check API.
+type Decision struct {
+	Allowed   bool   `json:"allowed"`
+	Code      int32  `json:"code"`
+	Message   string `json:"message"`
+	Requested int    `json:"requested"`
+	Total     int    `json:"total"`
+	Used      int    `json:"used"`
+	Reserved  int    `json:"reserved"`
+	Available int    `json:"available"`
+	Priority  int32  `json:"priority"`
+}
+
+// ExplainRequest represents the options of a request to the explain API.
+type ExplainRequest struct {
+	// Top is the number of top consumers to return.  The webhook default is used if it is zero.
+	Top int
+}
+
+// ExplainResponse represents the response of the explain API.
+type ExplainResponse struct {
+	// Consumers are the top consumers of the licensed capacity which the caller may see, largest first.
+	Consumers []Consumer `json:"consumers"`
+
+	// Others is the capacity used by every other consumer, including those which the caller may not see.
+	Others int `json:"others"`
+}
+
+// Consumer represents the capacity used by a namespace, or by an instance if the name is set.
+type Consumer struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name,omitempty"`
+	VCPUs     int    `json:"vcpus"`
+}
+
+// ErrorResponse represents the response of the API when a request fails.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
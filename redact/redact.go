@@ -0,0 +1,185 @@
+// Package redact redacts sensitive fields, such as cloud-init user data and sysprep references, from kubernetes
+// objects before they are written anywhere outside of the cluster, for example to recordings or logs.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// Redacted is the value that redacted fields are replaced with.
+	Redacted = "[REDACTED]"
+
+	// DefaultRules are the rules which are used when no rules are configured.
+	DefaultRules = "cloudinit,sysprep"
+)
+
+// rule represents a field which is redacted wherever it appears within an object, optionally only when it is within
+// an object with a given parent key.
+type rule struct {
+	parent string
+	field  string
+}
+
+// builtinRules are the named sets of rules which may be configured by name.
+var builtinRules = map[string][]rule{
+	// cloudinit redacts the inline user and network data of cloud-init volumes, along with the secrets which they
+	// may reference instead.
+	"cloudinit": {
+		{parent: "cloudInitNoCloud", field: "userData"},
+		{parent: "cloudInitNoCloud", field: "userDataBase64"},
+		{parent: "cloudInitNoCloud", field: "networkData"},
+		{parent: "cloudInitNoCloud", field: "networkDataBase64"},
+		{parent: "cloudInitNoCloud", field: "secretRef"},
+		{parent: "cloudInitNoCloud", field: "networkDataSecretRef"},
+		{parent: "cloudInitConfigDrive", field: "userData"},
+		{parent: "cloudInitConfigDrive", field: "userDataBase64"},
+		{parent: "cloudInitConfigDrive", field: "networkData"},
+		{parent: "cloudInitConfigDrive", field: "networkDataBase64"},
+		{parent: "cloudInitConfigDrive", field: "secretRef"},
+		{parent: "cloudInitConfigDrive", field: "networkDataSecretRef"},
+	},
+
+	// sysprep redacts the config maps and secrets which hold the answer files of sysprep volumes.  The sysprep volume
+	// itself remains so that windows detection is unaffected.
+	"sysprep": {
+		{parent: "sysprep", field: "configMap"},
+		{parent: "sysprep", field: "secret"},
+	},
+
+	// secretrefs redacts the secrets which are referenced by volumes, environment variables and access credentials.
+	"secretrefs": {
+		{parent: "secret", field: "secretName"},
+		{parent: "secretKeyRef", field: "name"},
+		{parent: "sshPublicKey", field: "source"},
+		{parent: "userPassword", field: "source"},
+	},
+}
+
+// Redactor redacts fields from objects according to a set of rules.
+type Redactor struct {
+	rules []rule
+}
+
+// NewRedactor returns a new instance of a redactor given a comma-separated list of rules.  Each rule is either the
+// name of a builtin set of rules (cloudinit, sysprep or secretrefs), the name of a field which is redacted wherever it
+// appears (e.g. password), or a field which is only redacted within a parent key (e.g. env.value).  The default rules
+// are used if the list is empty, while none disables redaction.
+func NewRedactor(rules string) (*Redactor, error) {
+	if strings.TrimSpace(rules) == "" {
+		rules = DefaultRules
+	}
+
+	r := &Redactor{}
+
+	for _, name := range strings.Split(rules, ",") {
+		name = strings.TrimSpace(name)
+
+		switch {
+		case name == "" || name == "none":
+			continue
+		case builtinRules[name] != nil:
+			r.rules = append(r.rules, builtinRules[name]...)
+		case strings.Count(name, ".") > 1:
+			return nil, fmt.Errorf("invalid redaction rule [%s]; must be one of %v, a field or a parent.field", name, builtinNames())
+		case strings.Contains(name, "."):
+			parent, field, _ := strings.Cut(name, ".")
+			if parent == "" || field == "" {
+				return nil, fmt.Errorf("invalid redaction rule [%s]; must be one of %v, a field or a parent.field", name, builtinNames())
+			}
+
+			r.rules = append(r.rules, rule{parent: parent, field: field})
+		default:
+			r.rules = append(r.rules, rule{field: name})
+		}
+	}
+
+	return r, nil
+}
+
+// Redact returns a copy of a JSON document with every string within the fields matching the rules replaced.
+func (r *Redactor) Redact(data []byte) ([]byte, error) {
+	if len(r.rules) == 0 {
+		return data, nil
+	}
+
+	var document any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to decode document for redaction; %w", err)
+	}
+
+	r.RedactValue(document)
+
+	return json.Marshal(document)
+}
+
+// RedactValue redacts the fields matching the rules of a decoded JSON value in place.
+func (r *Redactor) RedactValue(value any) {
+	r.redact("", value)
+}
+
+// redact redacts the fields matching the rules of a value which is found under a parent key.
+func (r *Redactor) redact(parent string, value any) {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, child := range typed {
+			if r.matches(parent, key) {
+				typed[key] = redactAll(child)
+
+				continue
+			}
+
+			r.redact(key, child)
+		}
+	case []any:
+		// the items of a list are considered to be under the key of the list
+		for _, child := range typed {
+			r.redact(parent, child)
+		}
+	}
+}
+
+// redactAll returns a value with every string within it replaced, keeping its structure so that a redacted object may
+// still be decoded into its type.
+func redactAll(value any) any {
+	switch typed := value.(type) {
+	case string:
+		return Redacted
+	case map[string]any:
+		for key, child := range typed {
+			typed[key] = redactAll(child)
+		}
+	case []any:
+		for i, child := range typed {
+			typed[i] = redactAll(child)
+		}
+	}
+
+	return value
+}
+
+// matches returns if a field under a parent key matches any of the rules.
+func (r *Redactor) matches(parent, field string) bool {
+	for _, rule := range r.rules {
+		if rule.field == field && (rule.parent == "" || rule.parent == parent) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// builtinNames returns the names of the builtin sets of rules.
+func builtinNames() []string {
+	names := make([]string, 0, len(builtinRules))
+	for name := range builtinRules {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
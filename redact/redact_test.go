@@ -0,0 +1,134 @@
+package redact
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNewRedactor(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		rules     string
+		wantRules int
+		wantErr   bool
+	}{
+		{
+			name:      "ensure the default rules are used when unset",
+			wantRules: len(builtinRules["cloudinit"]) + len(builtinRules["sysprep"]),
+		},
+		{
+			name:  "ensure none disables redaction",
+			rules: "none",
+		},
+		{
+			name:      "ensure builtin, field and parent field rules are combined",
+			rules:     "secretrefs, password, env.value",
+			wantRules: len(builtinRules["secretrefs"]) + 2,
+		},
+		{
+			name:    "ensure a rule with too many parents is rejected",
+			rules:   "a.b.c",
+			wantErr: true,
+		},
+		{
+			name:    "ensure a rule with an empty field is rejected",
+			rules:   "env.",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := NewRedactor(tt.rules)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewRedactor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && len(got.rules) != tt.wantRules {
+				t.Errorf("NewRedactor() rules = %d, want %d", len(got.rules), tt.wantRules)
+			}
+		})
+	}
+}
+
+func TestRedactor_Redact(t *testing.T) {
+	t.Parallel()
+
+	const instance = `{
+		"metadata": {"name": "vm-1", "annotations": {"password": "hunter2"}},
+		"spec": {
+			"volumes": [
+				{"name": "cloudinit", "cloudInitNoCloud": {"userData": "#cloud-config\npassword: hunter2", "secretRef": {"name": "user-data"}}},
+				{"name": "sysprep", "sysprep": {"secret": {"name": "answers"}}},
+				{"name": "secret", "secret": {"secretName": "credentials"}}
+			]
+		}
+	}`
+
+	tests := []struct {
+		name  string
+		rules string
+		want  string
+	}{
+		{
+			name: "ensure cloud-init and sysprep are redacted by default, keeping their structure",
+			want: `{
+				"metadata": {"name": "vm-1", "annotations": {"password": "hunter2"}},
+				"spec": {
+					"volumes": [
+						{"name": "cloudinit", "cloudInitNoCloud": {"userData": "[REDACTED]", "secretRef": {"name": "[REDACTED]"}}},
+						{"name": "sysprep", "sysprep": {"secret": {"name": "[REDACTED]"}}},
+						{"name": "secret", "secret": {"secretName": "credentials"}}
+					]
+				}
+			}`,
+		},
+		{
+			name:  "ensure custom field and secret reference rules are applied",
+			rules: "secretrefs,annotations.password",
+			want: `{
+				"metadata": {"name": "vm-1", "annotations": {"password": "[REDACTED]"}},
+				"spec": {
+					"volumes": [
+						{"name": "cloudinit", "cloudInitNoCloud": {"userData": "#cloud-config\npassword: hunter2", "secretRef": {"name": "user-data"}}},
+						{"name": "sysprep", "sysprep": {"secret": {"name": "answers"}}},
+						{"name": "secret", "secret": {"secretName": "[REDACTED]"}}
+					]
+				}
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r, err := NewRedactor(tt.rules)
+			if err != nil {
+				t.Fatalf("NewRedactor() error = %v", err)
+			}
+
+			got, err := r.Redact([]byte(instance))
+			if err != nil {
+				t.Fatalf("Redact() error = %v", err)
+			}
+
+			var gotValue, wantValue any
+			_ = json.Unmarshal(got, &gotValue)
+			_ = json.Unmarshal([]byte(tt.want), &wantValue)
+
+			gotJSON, _ := json.Marshal(gotValue)
+			wantJSON, _ := json.Marshal(wantValue)
+
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("Redact() = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
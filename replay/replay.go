@@ -0,0 +1,199 @@
+// Package replay replays recorded AdmissionReviews through the validation logic of the webhook and compares the
+// decisions against the recorded responses, so that regressions in detection and accounting are caught before a
+// release.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Evaluator represents a webhook which evaluates AdmissionReviews without serving them.
+type Evaluator interface {
+	Evaluate(ctx context.Context, review []byte) ([]byte, error)
+}
+
+// Options represents the options of a replay.
+type Options struct {
+	// CompareMessages compares the messages of decisions in addition to whether they were allowed and their codes.
+	CompareMessages bool
+}
+
+// Decision represents the outcome of an AdmissionReview.
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Code    int32  `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// Result represents the result of replaying a single AdmissionReview.
+type Result struct {
+	Line      int    `json:"line"`
+	UID       string `json:"uid"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Operation string `json:"operation"`
+
+	Actual   *Decision `json:"actual,omitempty"`
+	Expected *Decision `json:"expected,omitempty"`
+
+	// Differences describes each field of the actual decision which differs from the expected decision.
+	Differences []string `json:"differences,omitempty"`
+
+	// Error is set if the AdmissionReview could not be replayed.
+	Error string `json:"error,omitempty"`
+}
+
+// Summary represents the results of a replay.
+type Summary struct {
+	Total      int `json:"total"`
+	Matched    int `json:"matched"`
+	Mismatched int `json:"mismatched"`
+	Unexpected int `json:"unexpected"`
+	Errors     int `json:"errors"`
+}
+
+// Passed returns if every AdmissionReview was replayed and matched its expected decision, if it had one.
+func (s *Summary) Passed() bool {
+	return s.Mismatched == 0 && s.Errors == 0
+}
+
+// Replay replays each AdmissionReview of a JSONL stream, calling the handler with the result of each.  The recorded
+// response of an AdmissionReview, if it has one, is the decision that it is expected to have.  Reviews which have no
+// recorded response are counted as unexpected.  Each AdmissionReview is evaluated independently, so objects which
+// are admitted are not counted against the capacity of those which follow.
+func Replay(ctx context.Context, evaluator Evaluator, reviews io.Reader, opts Options, handle func(*Result) error) (*Summary, error) {
+	summary := &Summary{}
+	reader := bufio.NewReader(reviews)
+
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return summary, fmt.Errorf("failed to read line [%d]; %w", line, err)
+		}
+
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 {
+			result := replay(ctx, evaluator, line, trimmed, opts)
+
+			summary.Total++
+
+			switch {
+			case result.Error != "":
+				summary.Errors++
+			case result.Expected == nil:
+				summary.Unexpected++
+			case len(result.Differences) > 0:
+				summary.Mismatched++
+			default:
+				summary.Matched++
+			}
+
+			if err := handle(result); err != nil {
+				return summary, err
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return summary, nil
+		}
+	}
+}
+
+// replay replays a single AdmissionReview.
+func replay(ctx context.Context, evaluator Evaluator, line int, data []byte, opts Options) *Result {
+	result := &Result{Line: line}
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(data, &review); err != nil {
+		result.Error = fmt.Sprintf("failed to decode admission review; %v", err)
+
+		return result
+	}
+
+	if review.Request != nil {
+		result.UID = string(review.Request.UID)
+		result.Kind = review.Request.Kind.Kind
+		result.Namespace = review.Request.Namespace
+		result.Name = review.Request.Name
+		result.Operation = string(review.Request.Operation)
+
+		// the name of an object which is being created may only be in its metadata, for example if it is generated
+		if result.Name == "" {
+			object := metav1.PartialObjectMetadata{}
+			if json.Unmarshal(review.Request.Object.Raw, &object) == nil {
+				result.Namespace, result.Name = object.Namespace, object.Name
+			}
+		}
+	}
+
+	result.Expected = decisionOf(review.Response)
+
+	response, err := evaluator.Evaluate(ctx, data)
+	if err != nil {
+		result.Error = err.Error()
+
+		return result
+	}
+
+	actual := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(response, &actual); err != nil {
+		result.Error = fmt.Sprintf("failed to decode response; %v", err)
+
+		return result
+	}
+
+	if result.Actual = decisionOf(actual.Response); result.Actual == nil {
+		result.Error = "evaluation returned no response"
+
+		return result
+	}
+
+	if result.Expected != nil {
+		result.Differences = differences(result.Expected, result.Actual, opts)
+	}
+
+	return result
+}
+
+// decisionOf returns the decision of an admission response, or nil if there is no response.
+func decisionOf(response *admissionv1.AdmissionResponse) *Decision {
+	if response == nil {
+		return nil
+	}
+
+	decision := &Decision{Allowed: response.Allowed}
+	if response.Result != nil {
+		decision.Code = response.Result.Code
+		decision.Message = response.Result.Message
+	}
+
+	return decision
+}
+
+// differences returns a description of each field of the actual decision which differs from the expected decision.
+func differences(expected, actual *Decision, opts Options) []string {
+	var diffs []string
+
+	if expected.Allowed != actual.Allowed {
+		diffs = append(diffs, fmt.Sprintf("allowed: expected [%t], got [%t]", expected.Allowed, actual.Allowed))
+	}
+
+	if expected.Code != actual.Code {
+		diffs = append(diffs, fmt.Sprintf("code: expected [%d], got [%d]", expected.Code, actual.Code))
+	}
+
+	if opts.CompareMessages && expected.Message != actual.Message {
+		diffs = append(diffs, fmt.Sprintf("message: expected [%s], got [%s]", expected.Message, actual.Message))
+	}
+
+	return diffs
+}
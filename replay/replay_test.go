@@ -0,0 +1,141 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// testEvaluator denies requests for objects named deny and allows all others.
+type testEvaluator struct{}
+
+func (testEvaluator) Evaluate(_ context.Context, data []byte) ([]byte, error) {
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(data, &review); err != nil {
+		return nil, err
+	}
+
+	if review.Request.Name == "fail" {
+		return nil, errors.New("evaluation failed")
+	}
+
+	response := &admissionv1.AdmissionResponse{
+		UID:     review.Request.UID,
+		Allowed: review.Request.Name != "deny",
+		Result:  &metav1.Status{Code: 200, Message: "request success"},
+	}
+
+	if !response.Allowed {
+		response.Result = &metav1.Status{Code: 403, Message: "requested capacity exceeds available capacity"}
+	}
+
+	return json.Marshal(admissionv1.AdmissionReview{Response: response})
+}
+
+// testReview returns a JSONL line of an AdmissionReview for an object with the given name and recorded response.
+func testReview(t *testing.T, name string, response *admissionv1.AdmissionResponse) string {
+	t.Helper()
+
+	data, err := json.Marshal(admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("uid-" + name),
+			Kind:      metav1.GroupVersionKind{Kind: "VirtualMachineInstance"},
+			Namespace: "team-a",
+			Name:      name,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: []byte(`{}`)},
+		},
+		Response: response,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode admission review; %v", err)
+	}
+
+	return string(data) + "\n"
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+
+	allowed := &admissionv1.AdmissionResponse{Allowed: true, Result: &metav1.Status{Code: 200, Message: "request success"}}
+	denied := &admissionv1.AdmissionResponse{Result: &metav1.Status{Code: 403, Message: "a different message"}}
+
+	tests := []struct {
+		name            string
+		reviews         string
+		opts            Options
+		want            *Summary
+		wantDifferences [][]string
+	}{
+		{
+			name:            "ensure matching decisions are counted as matched",
+			reviews:         testReview(t, "allow", allowed) + "\n" + testReview(t, "deny", denied),
+			want:            &Summary{Total: 2, Matched: 2},
+			wantDifferences: [][]string{nil, nil},
+		},
+		{
+			name:    "ensure a decision which differs is counted as mismatched with its differences",
+			reviews: testReview(t, "deny", allowed),
+			want:    &Summary{Total: 1, Mismatched: 1},
+			wantDifferences: [][]string{{
+				"allowed: expected [true], got [false]",
+				"code: expected [200], got [403]",
+			}},
+		},
+		{
+			name:    "ensure messages are compared when requested",
+			reviews: testReview(t, "deny", denied),
+			opts:    Options{CompareMessages: true},
+			want:    &Summary{Total: 1, Mismatched: 1},
+			wantDifferences: [][]string{{
+				"message: expected [a different message], got [requested capacity exceeds available capacity]",
+			}},
+		},
+		{
+			name:            "ensure a review without a recorded response is counted as unexpected",
+			reviews:         testReview(t, "allow", nil),
+			want:            &Summary{Total: 1, Unexpected: 1},
+			wantDifferences: [][]string{nil},
+		},
+		{
+			name:            "ensure undecodable lines and failed evaluations are counted as errors",
+			reviews:         "not json\n" + strings.TrimSuffix(testReview(t, "fail", allowed), "\n"),
+			want:            &Summary{Total: 2, Errors: 2},
+			wantDifferences: [][]string{nil, nil},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var differences [][]string
+
+			got, err := Replay(context.Background(), testEvaluator{}, strings.NewReader(tt.reviews), tt.opts, func(result *Result) error {
+				differences = append(differences, result.Differences)
+
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Replay() = %+v, want %+v", got, tt.want)
+			}
+
+			if !reflect.DeepEqual(differences, tt.wantDifferences) {
+				t.Errorf("Replay() differences = %v, want %v", differences, tt.wantDifferences)
+			}
+		})
+	}
+}
@@ -12,6 +12,12 @@ const (
 
 	DefaultLabelKey    string = "image_type"
 	DefaultLabelValues string = "windows"
+
+	NodeType = "Node"
+
+	// MaintenanceAnnotation is set to "true" on a node to allow it to be removed from the licensed node pool
+	// during planned maintenance, even if doing so would overcommit the licensed capacity.
+	MaintenanceAnnotation = "licensing/windows-maintenance"
 )
 
 type Nodes []corev1.Node
@@ -59,22 +65,46 @@ func (nodes Nodes) Filter(filter NodeFilter) Nodes {
 	filtered := Nodes{}
 
 	for node := 0; node < len(nodes); node++ {
-		// continue if we have no filter key
-		if nodes[node].GetLabels()[filter.LabelKey()] == "" {
-			continue
-		}
-
 		// store the node if the filter matches
-		for value := 0; value < len(filter.LabelValues()); value++ {
-			if filter.LabelValues()[value] == nodes[node].GetLabels()[filter.LabelKey()] {
-				filtered = append(filtered, nodes[node])
-			}
+		if MatchesNodeFilter(&nodes[node], filter) {
+			filtered = append(filtered, nodes[node])
 		}
 	}
 
 	return filtered
 }
 
+// MatchesNodeFilter returns if a node has the filter label key set to one of the filter label values.
+func MatchesNodeFilter(node *corev1.Node, filter NodeFilter) bool {
+	value := node.GetLabels()[filter.LabelKey()]
+
+	// return if we have no filter key
+	if value == "" {
+		return false
+	}
+
+	for i := 0; i < len(filter.LabelValues()); i++ {
+		if filter.LabelValues()[i] == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Without returns a new list of nodes without the node with the given name.
+func (nodes Nodes) Without(name string) Nodes {
+	without := Nodes{}
+
+	for node := 0; node < len(nodes); node++ {
+		if nodes[node].Name != name {
+			without = append(without, nodes[node])
+		}
+	}
+
+	return without
+}
+
 // SumCPU sums up the value of all CPUs in the node list.
 func (nodes Nodes) SumCPU() int {
 	var sum int
@@ -0,0 +1,32 @@
+package resources
+
+import (
+	"strconv"
+
+	corev1 "kubevirt.io/api/core/v1"
+)
+
+// CappedPriority returns the priority of an object given the value of its priority class and a priority annotation.
+// The annotation may lower the priority of the object but, as it may be set by anyone who may create the object, it
+// is capped at the value of the priority class.  The value of the priority class is returned when the annotation is
+// unset or invalid.
+func CappedPriority(annotations map[string]string, annotation string, classPriority int32) int32 {
+	if value, found := annotations[annotation]; found {
+		if priority, err := strconv.ParseInt(value, 10, 32); err == nil {
+			return min(int32(priority), classPriority)
+		}
+	}
+
+	return classPriority
+}
+
+// QueuePriority returns the priority of a queued virtual machine, which is the value of the priority class of its
+// template, lowered by the queue priority annotation.
+func QueuePriority(vm *corev1.VirtualMachine, classes map[string]int32) int32 {
+	var classPriority int32
+	if vm.Spec.Template != nil {
+		classPriority = classes[vm.Spec.Template.Spec.PriorityClassName]
+	}
+
+	return CappedPriority(vm.GetAnnotations(), QueuePriorityAnnotation, classPriority)
+}
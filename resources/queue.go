@@ -0,0 +1,68 @@
+package resources
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "kubevirt.io/api/core/v1"
+)
+
+// QueueOrder represents the order in which queued windows virtual machines are started.
+type QueueOrder string
+
+const (
+	QueueOrderFIFO     QueueOrder = "FIFO"
+	QueueOrderPriority QueueOrder = "Priority"
+
+	// EnvQueueOrder sets the order in which queued windows virtual machines are started.
+	EnvQueueOrder = "WEBHOOK_QUEUE_ORDER"
+
+	DefaultQueueOrder = QueueOrderFIFO
+)
+
+// NewQueueOrder returns the queue order given its value, using the default if it is unset.
+func NewQueueOrder(value string) (QueueOrder, error) {
+	switch order := QueueOrder(value); order {
+	case "":
+		return DefaultQueueOrder, nil
+	case QueueOrderFIFO, QueueOrderPriority:
+		return order, nil
+	default:
+		return "", fmt.Errorf(
+			"invalid value [%s] for [%s]; must be one of [%s, %s]",
+			value,
+			EnvQueueOrder,
+			QueueOrderFIFO,
+			QueueOrderPriority,
+		)
+	}
+}
+
+// QueuedVirtualMachines returns the queued windows virtual machines in the order that they are to be started.  The
+// value of each priority class by name is only needed when the queue is ordered by priority.
+func QueuedVirtualMachines(vms []*corev1.VirtualMachine, order QueueOrder, classes map[string]int32) []*corev1.VirtualMachine {
+	queued := []*corev1.VirtualMachine{}
+
+	for _, vm := range vms {
+		if vm.GetLabels()[QueuedLabelKey] == QueuedLabelValue && vm.Spec.Template != nil {
+			queued = append(queued, vm)
+		}
+	}
+
+	sort.SliceStable(queued, func(i, j int) bool {
+		if order == QueueOrderPriority {
+			if first, second := QueuePriority(queued[i], classes), QueuePriority(queued[j], classes); first != second {
+				return first > second
+			}
+		}
+
+		// the queued time is RFC3339 in UTC, so it sorts lexically
+		if first, second := queued[i].Annotations[QueuedAtAnnotation], queued[j].Annotations[QueuedAtAnnotation]; first != second {
+			return first < second
+		}
+
+		return queued[i].Namespace+"/"+queued[i].Name < queued[j].Namespace+"/"+queued[j].Name
+	})
+
+	return queued
+}
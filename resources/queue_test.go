@@ -0,0 +1,103 @@
+package resources
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "kubevirt.io/api/core/v1"
+)
+
+func testQueuedVirtualMachine(name, queuedAt, priorityClass, priority string) *corev1.VirtualMachine {
+	vm := &corev1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "test",
+			Labels:      map[string]string{QueuedLabelKey: QueuedLabelValue},
+			Annotations: map[string]string{QueuedAtAnnotation: queuedAt},
+		},
+		Spec: corev1.VirtualMachineSpec{
+			Template: &corev1.VirtualMachineInstanceTemplateSpec{
+				Spec: corev1.VirtualMachineInstanceSpec{PriorityClassName: priorityClass},
+			},
+		},
+	}
+
+	if priority != "" {
+		vm.Annotations[QueuePriorityAnnotation] = priority
+	}
+
+	return vm
+}
+
+func TestQueuedVirtualMachines(t *testing.T) {
+	t.Parallel()
+
+	unqueued := testQueuedVirtualMachine("unqueued", "2026-01-01T00:00:00Z", "", "")
+	unqueued.Labels = nil
+
+	// the priority annotation may lower the priority of a virtual machine but not raise it above its priority class
+	classes := map[string]int32{"high": 100, "low": 1}
+
+	vms := []*corev1.VirtualMachine{
+		testQueuedVirtualMachine("third", "2026-01-01T00:03:00Z", "high", "10"),
+		testQueuedVirtualMachine("first", "2026-01-01T00:01:00Z", "high", ""),
+		testQueuedVirtualMachine("fourth", "2026-01-01T00:04:00Z", "low", "2147483647"),
+		testQueuedVirtualMachine("second", "2026-01-01T00:02:00Z", "", "5"),
+		unqueued,
+	}
+
+	tests := []struct {
+		name  string
+		order QueueOrder
+		want  []string
+	}{
+		{
+			name:  "ensure fifo order starts the earliest queued first",
+			order: QueueOrderFIFO,
+			want:  []string{"first", "second", "third", "fourth"},
+		},
+		{
+			name:  "ensure priority order starts the highest priority first",
+			order: QueueOrderPriority,
+			want:  []string{"first", "third", "fourth", "second"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			queued := QueuedVirtualMachines(vms, tt.order, classes)
+
+			got := []string{}
+			for _, vm := range queued {
+				got = append(got, vm.Name)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("QueuedVirtualMachines() = %v, want %v", got, tt.want)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("QueuedVirtualMachines() = %v, want %v", got, tt.want)
+
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestNewQueueOrder(t *testing.T) {
+	t.Parallel()
+
+	if got, err := NewQueueOrder(""); err != nil || got != QueueOrderFIFO {
+		t.Errorf("NewQueueOrder() = %v, %v, want %v", got, err, QueueOrderFIFO)
+	}
+
+	if _, err := NewQueueOrder("LIFO"); err == nil {
+		t.Errorf("NewQueueOrder() expected error for invalid value")
+	}
+}
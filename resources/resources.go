@@ -2,9 +2,49 @@ package resources
 
 import (
 	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
+const (
+	// WindowsLabelKey is the label stamped on objects which have been detected as windows instances.  It allows
+	// windows instances to be selected without re-running detection.
+	WindowsLabelKey   = "licensing/windows"
+	WindowsLabelValue = "true"
+
+	// WindowsReasonAnnotation is the annotation which stores the reason that an object was detected as a
+	// windows instance.
+	WindowsReasonAnnotation = "licensing/windows-reason"
+
+	// WindowsVCPUsAnnotation is the annotation which stores the number of vCPUs that a windows instance is charged.
+	WindowsVCPUsAnnotation = "licensing/windows-vcpus"
+
+	// PriorityAnnotation is the annotation which lowers the admission priority of a windows instance.  It may not
+	// raise the priority above that of the priority class of the instance.
+	PriorityAnnotation = "licensing/windows-priority"
+
+	// QueuedLabelKey is the label stamped on windows virtual machines which are queued for capacity.  It allows queued
+	// virtual machines to be selected.
+	QueuedLabelKey   = "licensing/windows-queued"
+	QueuedLabelValue = "true"
+
+	// QueuedAtAnnotation is the annotation which stores when a windows virtual machine was queued for capacity.
+	QueuedAtAnnotation = "licensing/windows-queued-at"
+
+	// QueuedRunStrategyAnnotation is the annotation which stores the run strategy that a queued windows virtual
+	// machine is started with once capacity is available.
+	QueuedRunStrategyAnnotation = "licensing/windows-queued-run-strategy"
+
+	// QueuePositionAnnotation is the annotation which stores the position of a queued windows virtual machine in the
+	// queue, starting at 1.
+	QueuePositionAnnotation = "licensing/windows-queue-position"
+
+	// QueuePriorityAnnotation is the annotation which lowers the priority of a queued windows virtual machine when the
+	// queue is ordered by priority.  Higher priorities are started first.  It may not raise the priority above that
+	// of the priority class of the virtual machine template.
+	QueuePriorityAnnotation = "licensing/windows-queue-priority"
+)
+
 type WindowsValidationResult struct {
 	NeedsValidation bool
 	Reason          string
@@ -13,15 +53,35 @@ type WindowsValidationResult struct {
 // WindowsInstanceValidator is an interface that represents an object containing all methods required to
 // validate a windows instance.
 type WindowsInstanceValidator interface {
-	Extract(*admissionv1.AdmissionRequest) (*virtualMachineInstance, error)
+	Extract(*admissionv1.AdmissionRequest) (WindowsInstanceValidator, error)
 	SumCPU() int
 	NeedsValidation() *WindowsValidationResult
 
+	Metadata() []ObjectMetadata
+	Scheduling() []ObjectScheduling
+
 	GetName() string
 	GetNamespace() string
 	GetObjectKind() schema.ObjectKind
 }
 
+// ObjectMetadata represents the metadata of an object, or of a template within the object, alongside the JSON
+// pointer path to the metadata within the object.
+type ObjectMetadata struct {
+	Path        string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// ObjectScheduling represents the scheduling constraints of an object, or of a template within the object,
+// alongside the JSON pointer path to the virtual machine instance spec containing them.
+type ObjectScheduling struct {
+	Path         string
+	NodeSelector map[string]string
+	Affinity     *corev1.Affinity
+	Tolerations  []corev1.Toleration
+}
+
 // SupportedResourceTypes returns the supported resources for this webhook.
 func SupportedResourceTypes() []string {
 	return []string{
@@ -22,14 +22,14 @@ func NewVirtualMachine() *virtualMachine {
 	return &virtualMachine{}
 }
 
-// Extract extracts a VirtualMachineInstance object from a VirtualMachine object.
-func (vm virtualMachine) Extract(admissionRequest *admissionv1.AdmissionRequest) (*virtualMachineInstance, error) {
-	if err := json.Unmarshal(admissionRequest.Object.Raw, &vm); err != nil {
+// Extract extracts a VirtualMachine object from an admission request.
+func (vm virtualMachine) Extract(admissionRequest *admissionv1.AdmissionRequest) (WindowsInstanceValidator, error) {
+	machine := &virtualMachine{}
+	if err := json.Unmarshal(admissionRequest.Object.Raw, machine); err != nil {
 		return nil, fmt.Errorf("failed to decode virtual machine object; %w", err)
 	}
 
-	// derive the spec from the virtual machine instance spec
-	return vm.VirtualMachineInstance(), nil
+	return machine, nil
 }
 
 // NeedsValidation returns if a virtual machine object needs validation or not.
@@ -37,13 +37,62 @@ func (vm virtualMachine) NeedsValidation() *WindowsValidationResult {
 	return vm.isWindows()
 }
 
-// SumCPU sums up the value of all CPUs for the virtual machine.
+// SumCPU sums up the value of all CPUs for the virtual machine.  A virtual machine without a template has no
+// virtual machine instance to charge.
 func (vm virtualMachine) SumCPU() int {
+	if vm.Spec.Template == nil {
+		return 0
+	}
+
 	return vm.VirtualMachineInstance().SumCPU()
 }
 
-// VirtualMachineInstance returns the virtual machine instance object from the virtual machine template spec.
+// Metadata returns the metadata of the virtual machine and of its virtual machine instance template, so that any
+// changes made to the template are carried to the virtual machine instances it creates.
+func (vm virtualMachine) Metadata() []ObjectMetadata {
+	metadata := []ObjectMetadata{
+		{
+			Path:        "/metadata",
+			Labels:      vm.GetLabels(),
+			Annotations: vm.GetAnnotations(),
+		},
+	}
+
+	if vm.Spec.Template != nil {
+		metadata = append(metadata, ObjectMetadata{
+			Path:        "/spec/template/metadata",
+			Labels:      vm.Spec.Template.ObjectMeta.GetLabels(),
+			Annotations: vm.Spec.Template.ObjectMeta.GetAnnotations(),
+		})
+	}
+
+	return metadata
+}
+
+// Scheduling returns the scheduling constraints of the virtual machine instance template.
+func (vm virtualMachine) Scheduling() []ObjectScheduling {
+	if vm.Spec.Template == nil {
+		return []ObjectScheduling{}
+	}
+
+	return []ObjectScheduling{
+		{
+			Path:         "/spec/template/spec",
+			NodeSelector: vm.Spec.Template.Spec.NodeSelector,
+			Affinity:     vm.Spec.Template.Spec.Affinity,
+			Tolerations:  vm.Spec.Template.Spec.Tolerations,
+		},
+	}
+}
+
+// VirtualMachineInstance returns the virtual machine instance object from the virtual machine template spec.  The spec
+// is empty for a virtual machine without a template.
 func (vm virtualMachine) VirtualMachineInstance() *virtualMachineInstance {
+	var spec kubevirtcorev1.VirtualMachineInstanceSpec
+	if vm.Spec.Template != nil {
+		spec = vm.Spec.Template.Spec
+	}
+
 	return &virtualMachineInstance{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       VirtualMachineInstanceType,
@@ -53,7 +102,7 @@ func (vm virtualMachine) VirtualMachineInstance() *virtualMachineInstance {
 			Name:      vm.Name,
 			Namespace: vm.Namespace,
 		},
-		Spec: vm.Spec.Template.Spec,
+		Spec: spec,
 	}
 }
 
@@ -0,0 +1,50 @@
+package resources
+
+import (
+	"testing"
+
+	corev1 "kubevirt.io/api/core/v1"
+)
+
+func Test_virtualMachine_nilTemplate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		vm   virtualMachine
+	}{
+		{
+			name: "ensure a virtual machine without a template is not a windows instance",
+			vm:   virtualMachine{},
+		},
+		{
+			name: "ensure a virtual machine without a template and with a preference is not a windows instance",
+			vm: virtualMachine{
+				Spec: corev1.VirtualMachineSpec{Preference: &corev1.PreferenceMatcher{Name: "rhel.9"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.vm.NeedsValidation(); got.NeedsValidation {
+				t.Errorf("NeedsValidation() = %+v, want not a windows instance", got)
+			}
+
+			if got := tt.vm.SumCPU(); got != 0 {
+				t.Errorf("SumCPU() = %d, want 0", got)
+			}
+
+			if got := tt.vm.VirtualMachineInstance(); got == nil || got.Spec.Domain.CPU != nil || len(got.Spec.Volumes) != 0 {
+				t.Errorf("VirtualMachineInstance() = %+v, want an empty spec", got)
+			}
+
+			if got := tt.vm.Scheduling(); len(got) != 0 {
+				t.Errorf("Scheduling() = %+v, want none", got)
+			}
+		})
+	}
+}
@@ -21,8 +21,8 @@ func NewVirtualMachineInstance() *virtualMachineInstance {
 	return &virtualMachineInstance{}
 }
 
-// Extract extracts a VirtualMachineInstance object from a VirtualMachine object.
-func (vmi virtualMachineInstance) Extract(admissionRequest *admissionv1.AdmissionRequest) (*virtualMachineInstance, error) {
+// Extract extracts a VirtualMachineInstance object from an admission request.
+func (vmi virtualMachineInstance) Extract(admissionRequest *admissionv1.AdmissionRequest) (WindowsInstanceValidator, error) {
 	instance := &virtualMachineInstance{}
 	if err := json.Unmarshal(admissionRequest.Object.Raw, &instance); err != nil {
 		return nil, fmt.Errorf("failed to decode virtual machine instance object; %w", err)
@@ -81,6 +81,29 @@ func (vmi virtualMachineInstance) SumCPU() int {
 	return sockets * cores * threads
 }
 
+// Metadata returns the metadata of the virtual machine instance.
+func (vmi virtualMachineInstance) Metadata() []ObjectMetadata {
+	return []ObjectMetadata{
+		{
+			Path:        "/metadata",
+			Labels:      vmi.GetLabels(),
+			Annotations: vmi.GetAnnotations(),
+		},
+	}
+}
+
+// Scheduling returns the scheduling constraints of the virtual machine instance.
+func (vmi virtualMachineInstance) Scheduling() []ObjectScheduling {
+	return []ObjectScheduling{
+		{
+			Path:         "/spec",
+			NodeSelector: vmi.Spec.NodeSelector,
+			Affinity:     vmi.Spec.Affinity,
+			Tolerations:  vmi.Spec.Tolerations,
+		},
+	}
+}
+
 // isWindows determines if a virtual machine instance object is a windows instance or not.
 func (vmi virtualMachineInstance) isWindows() *WindowsValidationResult {
 	for _, hasWindowsIdentifier := range []func() *WindowsValidationResult{
@@ -99,6 +122,42 @@ func (vmi virtualMachineInstance) isWindows() *WindowsValidationResult {
 	return &WindowsValidationResult{Reason: "no validation required"}
 }
 
+// IsWindows returns if a virtual machine instance is a windows instance, either because it has been labeled as one by
+// the mutating webhook or because it has a windows identifier.
+func IsWindows(instance *corev1.VirtualMachineInstance) bool {
+	if instance.GetLabels()[WindowsLabelKey] == WindowsLabelValue {
+		return true
+	}
+
+	return virtualMachineInstance(*instance).isWindows().NeedsValidation
+}
+
+// WindowsReasons returns every reason that a virtual machine instance is detected as a windows instance, or nothing if
+// it is not one.  Unlike the validation logic, which stops at the first windows identifier, all identifiers are
+// checked so that the detection of an instance may be explained in full.
+func WindowsReasons(instance *corev1.VirtualMachineInstance) []string {
+	vmi := virtualMachineInstance(*instance)
+
+	var reasons []string
+
+	if vmi.GetLabels()[WindowsLabelKey] == WindowsLabelValue {
+		reasons = append(reasons, fmt.Sprintf("has '%s' label", WindowsLabelKey))
+	}
+
+	for _, hasWindowsIdentifier := range []func() *WindowsValidationResult{
+		vmi.hasSysprepVolume,
+		vmi.hasWindowsDriverDiskVolume,
+		vmi.hasHyperV,
+		vmi.hasWindowsPreference,
+	} {
+		if result := hasWindowsIdentifier(); result.NeedsValidation {
+			reasons = append(reasons, result.Reason)
+		}
+	}
+
+	return reasons
+}
+
 // hasSysprepVolume returns if the virtualmachineinstance has a sysprep volume or not.  Sysprep volumes are exclusive
 // to windows machines.
 // WARN: it should be noted that users who deploy their instances via YAML may have a copy/paste error that includes
@@ -148,3 +148,49 @@ func Test_virtualMachineInstance_NeedsValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestWindowsReasons(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		instance *corev1.VirtualMachineInstance
+		want     []string
+	}{
+		{
+			name: "ensure every windows identifier is returned",
+			instance: &corev1.VirtualMachineInstance{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{WindowsLabelKey: WindowsLabelValue}},
+				Spec: corev1.VirtualMachineInstanceSpec{
+					Domain: corev1.DomainSpec{Features: &corev1.Features{Hyperv: &corev1.FeatureHyperv{}}},
+					Volumes: []corev1.Volume{
+						{VolumeSource: corev1.VolumeSource{Sysprep: &corev1.SysprepSource{}}},
+					},
+				},
+			},
+			want: []string{"has 'licensing/windows' label", "has sysprep volume", "has hyper-v features"},
+		},
+		{
+			name:     "ensure an instance without windows identifiers returns nothing",
+			instance: &corev1.VirtualMachineInstance{},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := WindowsReasons(tt.instance)
+			if len(got) != len(tt.want) {
+				t.Fatalf("WindowsReasons() = %v, want %v", got, tt.want)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("WindowsReasons() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
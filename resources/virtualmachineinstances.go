@@ -10,7 +10,11 @@ import (
 type VirtualMachineInstances []corev1.VirtualMachineInstance
 
 // VirtualMachineInstancesFilter represents a filter based on a set of key value inputs that are used to filter nodes.
-type VirtualMachineInstancesFilter struct{}
+type VirtualMachineInstancesFilter struct {
+	// ByLabel filters virtual machine instances by the WindowsLabelKey label, which is stamped by the mutating
+	// webhook, rather than by re-running windows detection.
+	ByLabel bool
+}
 
 // Filter filters a Store object and returns a new store with only filtered virtual machine instances.  In the
 // instance of this webhook, we only want virtual machine instances that are running a windows operating system.
@@ -20,6 +24,14 @@ func (instances VirtualMachineInstances) Filter(filter *VirtualMachineInstancesF
 	for i := 0; i < len(instances); i++ {
 		var instance virtualMachineInstance = virtualMachineInstance(instances[i])
 
+		if filter.ByLabel {
+			if instance.GetLabels()[WindowsLabelKey] == WindowsLabelValue {
+				filtered = append(filtered, corev1.VirtualMachineInstance(instance))
+			}
+
+			continue
+		}
+
 		if instance.hasSysprepVolume().NeedsValidation {
 			filtered = append(filtered, corev1.VirtualMachineInstance(instance))
 		}
@@ -25,3 +25,39 @@ func (vms VirtualMachines) Filter(filter *VirtualMachinesFilter) VirtualMachineI
 
 	return filtered
 }
+
+// SumCPU sums up the value of all CPUs of the virtual machines.
+func (vms VirtualMachines) SumCPU() int {
+	var sum int
+
+	for i := 0; i < len(vms); i++ {
+		if vms[i].Spec.Template != nil {
+			sum += virtualMachine(vms[i]).SumCPU()
+		}
+	}
+
+	return sum
+}
+
+// RunStrategy returns the effective run strategy of a virtual machine, accounting for the deprecated running field.
+func RunStrategy(vm *corev1.VirtualMachine) corev1.VirtualMachineRunStrategy {
+	if vm.Spec.RunStrategy != nil {
+		return *vm.Spec.RunStrategy
+	}
+
+	if vm.Spec.Running != nil && *vm.Spec.Running {
+		return corev1.RunStrategyAlways
+	}
+
+	return corev1.RunStrategyHalted
+}
+
+// StartsAutomatically returns if a run strategy starts a virtual machine instance without any user action.
+func StartsAutomatically(strategy corev1.VirtualMachineRunStrategy) bool {
+	switch strategy {
+	case corev1.RunStrategyAlways, corev1.RunStrategyRerunOnFailure, corev1.RunStrategyOnce:
+		return true
+	default:
+		return false
+	}
+}
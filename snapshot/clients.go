@@ -0,0 +1,61 @@
+package snapshot
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"kubevirt.io/client-go/kubecli"
+	kubevirtfake "kubevirt.io/client-go/kubevirt/fake"
+)
+
+// virtClient is a kubevirt client which serves the virtual machines and virtual machine instances of a snapshot.  Only
+// the methods which the webhook uses are implemented, and any other method panics.
+type virtClient struct {
+	kubecli.KubevirtClient
+
+	clientset *kubevirtfake.Clientset
+}
+
+// VirtualMachineInstance returns the virtual machine instances of the snapshot in a namespace.
+func (c *virtClient) VirtualMachineInstance(namespace string) kubecli.VirtualMachineInstanceInterface {
+	return c.clientset.KubevirtV1().VirtualMachineInstances(namespace)
+}
+
+// VirtualMachine returns the virtual machines of the snapshot in a namespace.
+func (c *virtClient) VirtualMachine(namespace string) kubecli.VirtualMachineInterface {
+	return c.clientset.KubevirtV1().VirtualMachines(namespace)
+}
+
+// Clients returns clients which serve the objects of the snapshot in place of a live cluster.  Every access review is
+// allowed, so that explanations list every consumer by name.
+func (s *Snapshot) Clients() (kubernetes.Interface, kubecli.KubevirtClient) {
+	kubeObjects := []runtime.Object{}
+	for i := range s.Nodes {
+		kubeObjects = append(kubeObjects, &s.Nodes[i])
+	}
+
+	for i := range s.PriorityClasses {
+		kubeObjects = append(kubeObjects, &s.PriorityClasses[i])
+	}
+
+	kubeClient := kubefake.NewSimpleClientset(kubeObjects...)
+	kubeClient.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		review.Status.Allowed = true
+
+		return true, review, nil
+	})
+
+	virtObjects := []runtime.Object{}
+	for i := range s.VirtualMachines {
+		virtObjects = append(virtObjects, &s.VirtualMachines[i])
+	}
+
+	for i := range s.VirtualMachineInstances {
+		virtObjects = append(virtObjects, &s.VirtualMachineInstances[i])
+	}
+
+	return kubeClient, &virtClient{clientset: kubevirtfake.NewSimpleClientset(virtObjects...)}
+}
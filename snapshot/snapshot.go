@@ -0,0 +1,216 @@
+// Package snapshot loads the objects which admission decisions depend on from files, such as the output of
+// `oc get -o yaml` or a must-gather directory, so that decisions may be reproduced without a live cluster.
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+const (
+	kindNode                   = "Node"
+	kindPriorityClass          = "PriorityClass"
+	kindVirtualMachine         = "VirtualMachine"
+	kindVirtualMachineInstance = "VirtualMachineInstance"
+
+	// decoderBufferSize is the number of bytes which are read to determine whether a file is YAML or JSON.
+	decoderBufferSize = 4096
+)
+
+// Snapshot represents the objects of a cluster which admission decisions depend on.  Objects of any other kind are
+// ignored when loading.
+type Snapshot struct {
+	Nodes                   []corev1.Node
+	PriorityClasses         []schedulingv1.PriorityClass
+	VirtualMachines         []kubevirtv1.VirtualMachine
+	VirtualMachineInstances []kubevirtv1.VirtualMachineInstance
+
+	// Skipped are the files within a directory which were skipped because they could not be decoded.  Directories
+	// such as must-gathers contain files which are not kubernetes objects, so they do not fail the load.
+	Skipped []string
+
+	// objects are the loaded objects by kind and key, so that an object which appears in multiple files, for example
+	// both in a list and on its own, is only loaded once.
+	objects map[string]map[string]json.RawMessage
+}
+
+// Load loads a snapshot from files and directories.  Directories are walked recursively for files with a .yaml, .yml
+// or .json extension.  Each file may contain multiple YAML documents, and each document may be a single object or a
+// list of objects.
+func Load(paths ...string) (*Snapshot, error) {
+	s := &Snapshot{objects: map[string]map[string]json.RawMessage{}}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot path [%s]; %w", path, err)
+		}
+
+		if !info.IsDir() {
+			if err := s.loadFile(path); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if entry.IsDir() || !isManifest(file) {
+				return nil
+			}
+
+			if err := s.loadFile(file); err != nil {
+				s.Skipped = append(s.Skipped, file)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk snapshot directory [%s]; %w", path, err)
+		}
+	}
+
+	if err := s.decode(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// loadFile loads each object of each document in a file.
+func (s *Snapshot) loadFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot file [%s]; %w", file, err)
+	}
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), decoderBufferSize)
+
+	for {
+		document := json.RawMessage{}
+		if err := decoder.Decode(&document); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return fmt.Errorf("failed to decode snapshot file [%s]; %w", file, err)
+		}
+
+		if err := s.add(document); err != nil {
+			return fmt.Errorf("failed to decode snapshot file [%s]; %w", file, err)
+		}
+	}
+}
+
+// add adds an object, or each object of a list, to the snapshot.
+func (s *Snapshot) add(document json.RawMessage) error {
+	// empty documents are decoded as null
+	if len(document) == 0 || string(document) == "null" {
+		return nil
+	}
+
+	object := struct {
+		runtime.TypeMeta `json:",inline"`
+		Metadata         struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Items []json.RawMessage `json:"items"`
+	}{}
+
+	if err := json.Unmarshal(document, &object); err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(object.Kind, "List") {
+		for _, item := range object.Items {
+			if err := s.add(item); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	switch object.Kind {
+	case kindNode, kindPriorityClass, kindVirtualMachine, kindVirtualMachineInstance:
+		if s.objects[object.Kind] == nil {
+			s.objects[object.Kind] = map[string]json.RawMessage{}
+		}
+
+		s.objects[object.Kind][object.Metadata.Namespace+"/"+object.Metadata.Name] = document
+	}
+
+	return nil
+}
+
+// decode decodes the loaded objects into their types, in the order of their keys so that loading is deterministic.
+func (s *Snapshot) decode() error {
+	for kind, objects := range s.objects {
+		keys := make([]string, 0, len(objects))
+		for key := range objects {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			var err error
+
+			switch kind {
+			case kindNode:
+				s.Nodes, err = appendDecoded(s.Nodes, objects[key])
+			case kindPriorityClass:
+				s.PriorityClasses, err = appendDecoded(s.PriorityClasses, objects[key])
+			case kindVirtualMachine:
+				s.VirtualMachines, err = appendDecoded(s.VirtualMachines, objects[key])
+			case kindVirtualMachineInstance:
+				s.VirtualMachineInstances, err = appendDecoded(s.VirtualMachineInstances, objects[key])
+			}
+
+			if err != nil {
+				return fmt.Errorf("failed to decode %s [%s]; %w", kind, strings.TrimPrefix(key, "/"), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// appendDecoded decodes an object and appends it to a list of objects of the same type.
+func appendDecoded[T any](objects []T, data json.RawMessage) ([]T, error) {
+	var object T
+	if err := json.Unmarshal(data, &object); err != nil {
+		return objects, err
+	}
+
+	return append(objects, object), nil
+}
+
+// isManifest returns if a file is a YAML or JSON manifest given its extension.
+func isManifest(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,182 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testNodeList = `apiVersion: v1
+kind: List
+items:
+  - apiVersion: v1
+    kind: Node
+    metadata:
+      name: node-1
+  - apiVersion: v1
+    kind: Node
+    metadata:
+      name: node-2
+`
+
+	testInstances = `---
+apiVersion: kubevirt.io/v1
+kind: VirtualMachineInstance
+metadata:
+  name: vm-1
+  namespace: team-a
+---
+apiVersion: kubevirt.io/v1
+kind: VirtualMachineInstance
+metadata:
+  name: vm-1
+  namespace: team-b
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ignored
+  namespace: team-a
+`
+
+	testNode = `{"apiVersion":"v1","kind":"Node","metadata":{"name":"node-1"}}`
+)
+
+// writeFiles writes files, given by their path relative to a temporary directory, returning the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create directory; %v", err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write file; %v", err)
+		}
+	}
+
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		files         map[string]string
+		paths         []string
+		wantNodes     int
+		wantInstances int
+		wantSkipped   int
+		wantErr       bool
+	}{
+		{
+			name: "ensure lists, multiple documents and nested directories are loaded",
+			files: map[string]string{
+				"cluster-scoped-resources/core/nodes.yaml":           testNodeList,
+				"namespaces/kubevirt.io/virtualmachineinstances.yml": testInstances,
+				"README.md": "not a manifest",
+			},
+			paths:         []string{"."},
+			wantNodes:     2,
+			wantInstances: 2,
+		},
+		{
+			name: "ensure an object which appears in multiple files is loaded once",
+			files: map[string]string{
+				"nodes.yaml":  testNodeList,
+				"node-1.json": testNode,
+			},
+			paths:     []string{"nodes.yaml", "node-1.json"},
+			wantNodes: 2,
+		},
+		{
+			name: "ensure an undecodable file within a directory is skipped",
+			files: map[string]string{
+				"nodes.yaml": testNodeList,
+				"junk.yaml":  "not: [yaml",
+			},
+			paths:       []string{"."},
+			wantNodes:   2,
+			wantSkipped: 1,
+		},
+		{
+			name:    "ensure an undecodable file which is given directly returns an error",
+			files:   map[string]string{"junk.yaml": "not: [yaml"},
+			paths:   []string{"junk.yaml"},
+			wantErr: true,
+		},
+		{
+			name:    "ensure a missing path returns an error",
+			paths:   []string{"missing"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := writeFiles(t, tt.files)
+
+			paths := make([]string, len(tt.paths))
+			for i := range tt.paths {
+				paths[i] = filepath.Join(dir, tt.paths[i])
+			}
+
+			got, err := Load(paths...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if len(got.Nodes) != tt.wantNodes {
+				t.Errorf("Load() nodes = %d, want %d", len(got.Nodes), tt.wantNodes)
+			}
+
+			if len(got.VirtualMachineInstances) != tt.wantInstances {
+				t.Errorf("Load() instances = %d, want %d", len(got.VirtualMachineInstances), tt.wantInstances)
+			}
+
+			if len(got.Skipped) != tt.wantSkipped {
+				t.Errorf("Load() skipped = %v, want %d", got.Skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestSnapshot_Clients(t *testing.T) {
+	t.Parallel()
+
+	dir := writeFiles(t, map[string]string{"nodes.yaml": testNodeList, "instances.yaml": testInstances})
+
+	s, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	kubeClient, virtClient := s.Clients()
+
+	nodes, err := kubeClient.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(nodes.Items) != 2 {
+		t.Errorf("Clients() nodes = %v, error = %v, want 2 nodes", nodes, err)
+	}
+
+	instances, err := virtClient.VirtualMachineInstance("team-a").List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(instances.Items) != 1 {
+		t.Errorf("Clients() instances = %v, error = %v, want 1 instance", instances, err)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/audit"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/clients"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/webhook"
+)
+
+const commandVerifyAudit = "verify-audit"
+
+// runVerifyAudit verifies a chain of audit records, including any rotated files, and that the chain contains the
+// head which was checkpointed for it, writing the result as JSON to stdout.  An error is returned if the records were
+// edited, deleted or truncated, or if other chains were checkpointed whose records are not verified.
+func runVerifyAudit(ctx context.Context, args []string) error {
+	var (
+		path           string
+		checkpoint     bool
+		checkpointFile string
+		namespace      string
+		name           string
+		key            string
+		others         bool
+	)
+
+	flags := flag.NewFlagSet(commandVerifyAudit, flag.ContinueOnError)
+	flags.StringVar(&path, "path", webhook.DefaultAuditPath, "audit file to verify, along with its rotated files")
+	flags.BoolVar(&checkpoint, "checkpoint", true, "verify the audit records against their checkpoint")
+	flags.StringVar(&checkpointFile, "checkpoint-file", "", "file containing the checkpoint, rather than reading it from the config map")
+	flags.StringVar(&namespace, "namespace", clients.Namespace(), "namespace of the checkpoint config map")
+	flags.StringVar(&name, "checkpoint-name", webhook.DefaultAuditCheckpointName, "name of the checkpoint config map")
+	flags.StringVar(&key, "checkpoint-key", "", "key of the checkpoint within the config map, rather than the key stored alongside the audit file")
+	flags.BoolVar(&others, "other-checkpoints", true, "report the checkpoints of other chains within the config map, whose audit records are not verified")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+
+		return err
+	}
+
+	var (
+		head    *audit.Head
+		missing []string
+	)
+
+	if checkpoint {
+		var err error
+		if key == "" && checkpointFile == "" {
+			if key, err = webhook.ReadAuditCheckpointKey(path); err != nil {
+				return fmt.Errorf("failed to find the checkpoint of [%s], use --checkpoint-key if its key file was lost; %w", path, err)
+			}
+		}
+
+		if head, err = readCheckpoint(ctx, checkpointFile, namespace, name, key); err != nil {
+			return err
+		}
+
+		if others && checkpointFile == "" {
+			if missing, err = otherCheckpoints(ctx, namespace, name, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	files, err := audit.Files(path)
+	if err != nil {
+		return err
+	}
+
+	verifier := audit.NewVerifier(head)
+	if err := verifier.VerifyFiles(files...); err != nil {
+		return err
+	}
+
+	result := verifier.Result()
+
+	for _, other := range missing {
+		result.Problems = append(result.Problems, fmt.Sprintf(
+			"chain [%s] was checkpointed but its records were not verified; verify them where they are kept, "+
+				"or they were lost", other))
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(result); err != nil {
+		return err
+	}
+
+	if !result.Intact() {
+		return fmt.Errorf("found %d problems with the audit records in [%s]", len(result.Problems), path)
+	}
+
+	return nil
+}
+
+// otherCheckpoints returns the keys of the chains, other than the chain with the given key, which were checkpointed to
+// the checkpoint config map.  Each is a chain whose records are kept elsewhere, or whose records and key were lost.
+func otherCheckpoints(ctx context.Context, namespace, name, key string) ([]string, error) {
+	c, err := clients.NewInCluster()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clients to read checkpoints; %w", err)
+	}
+
+	keys, err := webhook.ReadAuditCheckpointKeys(ctx, c.KubeClient, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(keys, func(other string) bool { return other == key }), nil
+}
+
+// readCheckpoint reads a checkpoint from a file, or from the checkpoint config map using the in-cluster configuration
+// if no file is given.
+func readCheckpoint(ctx context.Context, file, namespace, name, key string) (*audit.Head, error) {
+	if file == "" {
+		c, err := clients.NewInCluster()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create clients to read checkpoint, use --checkpoint-file outside of the cluster; %w", err)
+		}
+
+		return webhook.ReadAuditCheckpoint(ctx, c.KubeClient, namespace, name, key)
+	}
+
+	data, err := readInput(file)
+	if err != nil {
+		return nil, err
+	}
+
+	head := &audit.Head{}
+	if err := json.Unmarshal(data, head); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint [%s]; %w", file, err)
+	}
+
+	return head, nil
+}
@@ -0,0 +1,269 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/pkg/client"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+// apiHandlerFunc represents a handler of the API which is called with the authenticated user.
+type apiHandlerFunc func(w http.ResponseWriter, r *http.Request, user authenticationv1.UserInfo)
+
+// Capacity returns the licensed capacity, broken down by pool and by namespace.  Only the namespaces in which the
+// user may get virtual machine instances are returned.
+func (wh *webhook) Capacity(w http.ResponseWriter, r *http.Request, user authenticationv1.UserInfo) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, client.ErrorResponse{Error: "only GET is supported"})
+		return
+	}
+
+	capacity, err := wh.capacity(r.Context())
+	if err != nil {
+		writeJSON(w, int(errorTypeOf(err).code()), client.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	capacity.Namespaces = wh.visibleUsage(r.Context(), user, capacity.Namespaces)
+
+	writeJSON(w, http.StatusOK, capacity)
+}
+
+// Check returns the decision that the webhook would make for a virtual machine or virtual machine instance manifest,
+// without reserving any capacity.
+func (wh *webhook) Check(w http.ResponseWriter, r *http.Request, user authenticationv1.UserInfo) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, client.ErrorResponse{Error: "only POST is supported"})
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, client.ErrorResponse{Error: "request body too large"})
+			return
+		}
+
+		writeJSON(w, http.StatusBadRequest, client.ErrorResponse{Error: fmt.Sprintf("failed to read request body; %v", err)})
+		return
+	}
+
+	decision, err := wh.check(r.Context(), body, user)
+	if err != nil {
+		writeJSON(w, int(errorTypeOf(err).code()), client.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, decision)
+}
+
+// Explain returns the top consumers of the licensed capacity which the user may see.  The number of consumers may be
+// set with the top query parameter, up to the maximum number of namespaces whose visibility is checked.
+func (wh *webhook) Explain(w http.ResponseWriter, r *http.Request, user authenticationv1.UserInfo) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, client.ErrorResponse{Error: "only GET is supported"})
+		return
+	}
+
+	top, byInstance := DefaultExplainTop, false
+	if wh.Explainer != nil {
+		byInstance = wh.Explainer.byInstance
+
+		if wh.Explainer.top > 0 {
+			top = wh.Explainer.top
+		}
+	}
+
+	if value := r.URL.Query().Get("top"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxVisibilityChecks {
+			writeJSON(w, http.StatusBadRequest, client.ErrorResponse{
+				Error: fmt.Sprintf("invalid value [%s] for top; must be between 1 and %d", value, maxVisibilityChecks),
+			})
+
+			return
+		}
+
+		top = parsed
+	}
+
+	vmInstanceList, err := wh.getFilteredVirtualMachineInstances(r.Context())
+	if err != nil {
+		writeJSON(w, int(errorTypeOf(err).code()), client.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	listed, hidden := visibleConsumers(wh.accessibleNamespaces(r.Context(), user), consumers(vmInstanceList, byInstance), top)
+
+	explanation := &client.ExplainResponse{Consumers: make([]client.Consumer, len(listed)), Others: hidden}
+	for i := range listed {
+		explanation.Consumers[i] = client.Consumer(listed[i])
+	}
+
+	writeJSON(w, http.StatusOK, explanation)
+}
+
+// capacity returns the licensed capacity, broken down by the pool of each node label value and by namespace.
+func (wh *webhook) capacity(ctx context.Context) (*client.CapacityResponse, error) {
+	nodeList, err := wh.getFilteredNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	vmInstanceList, err := wh.getFilteredVirtualMachineInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := &client.CapacityResponse{
+		Total:      nodeList.SumCPU(),
+		Used:       vmInstanceList.SumCPU(),
+		Pools:      []client.PoolCapacity{},
+		Namespaces: []client.NamespaceUsage{},
+	}
+
+	capacity.Reserved = wh.Priority.reserved(capacity.Total)
+	capacity.Available = capacity.Total - capacity.Used - capacity.Reserved
+
+	// each value of the node label is a pool
+	pools := map[string]*client.PoolCapacity{}
+	nodePools := map[string]string{}
+
+	for _, value := range wh.NodeFilter.LabelValues() {
+		pools[value] = &client.PoolCapacity{Pool: value}
+	}
+
+	for i := range nodeList {
+		value := nodeList[i].GetLabels()[wh.NodeFilter.LabelKey()]
+		nodePools[nodeList[i].Name] = value
+
+		pools[value].Nodes++
+		pools[value].Total += nodeList[i : i+1].SumCPU()
+	}
+
+	namespaces := map[string]*client.NamespaceUsage{}
+
+	for i := range vmInstanceList {
+		vcpus := vmInstanceList[i : i+1].SumCPU()
+
+		if pool, found := nodePools[vmInstanceList[i].Status.NodeName]; found {
+			pools[pool].Used += vcpus
+		}
+
+		usage, found := namespaces[vmInstanceList[i].Namespace]
+		if !found {
+			usage = &client.NamespaceUsage{Namespace: vmInstanceList[i].Namespace}
+			namespaces[usage.Namespace] = usage
+		}
+
+		usage.Instances++
+		usage.Used += vcpus
+	}
+
+	for _, value := range wh.NodeFilter.LabelValues() {
+		capacity.Pools = append(capacity.Pools, *pools[value])
+	}
+
+	for _, usage := range namespaces {
+		capacity.Namespaces = append(capacity.Namespaces, *usage)
+	}
+
+	sort.Slice(capacity.Namespaces, func(i, j int) bool {
+		return capacity.Namespaces[i].Namespace < capacity.Namespaces[j].Namespace
+	})
+
+	return capacity, nil
+}
+
+// visibleUsage returns the usage of the namespaces in which a user may get virtual machine instances.
+func (wh *webhook) visibleUsage(
+	ctx context.Context,
+	user authenticationv1.UserInfo,
+	usage []client.NamespaceUsage,
+) []client.NamespaceUsage {
+	namespaces := make([]string, len(usage))
+	for i := range usage {
+		namespaces[i] = usage[i].Namespace
+	}
+
+	visible := wh.visibleNamespaces(ctx, user, "get", namespaces)
+
+	filtered := []client.NamespaceUsage{}
+
+	for i := range usage {
+		if visible[usage[i].Namespace] {
+			filtered = append(filtered, usage[i])
+		}
+	}
+
+	return filtered
+}
+
+// check returns the decision that the webhook would make for a virtual machine or virtual machine instance manifest.
+func (wh *webhook) check(ctx context.Context, manifest []byte, user authenticationv1.UserInfo) (*Decision, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(manifest, &typeMeta); err != nil {
+		return nil, newAdmissionError(ErrorTypeDecode, "failed to decode manifest; %w", err)
+	}
+
+	var validator resources.WindowsInstanceValidator
+	switch typeMeta.Kind {
+	case resources.VirtualMachineType:
+		validator = resources.NewVirtualMachine()
+	case resources.VirtualMachineInstanceType:
+		validator = resources.NewVirtualMachineInstance()
+	default:
+		return nil, newAdmissionError(
+			ErrorTypeUnsupportedKind,
+			"unsupported kind [%s]; only [%s %s] supported",
+			typeMeta.Kind,
+			resources.VirtualMachineType,
+			resources.VirtualMachineInstanceType,
+		)
+	}
+
+	object, err := validator.Extract(&admissionv1.AdmissionRequest{Object: runtime.RawExtension{Raw: manifest}})
+	if err != nil {
+		return nil, newAdmissionError(ErrorTypeDecode, "failed extracting object from manifest; %w", err)
+	}
+
+	validationResult := object.NeedsValidation()
+	if !validationResult.NeedsValidation {
+		return &Decision{
+			Allowed:   true,
+			Code:      http.StatusOK,
+			Message:   fmt.Sprintf("skipping validation, reason [%s]", validationResult.Reason),
+			Requested: object.SumCPU(),
+		}, nil
+	}
+
+	instance, err := decodeInstance(typeMeta.Kind, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	decision, _, err := wh.decide(ctx, object, instance, wh.accessibleNamespaces(ctx, user))
+
+	return decision, err
+}
+
+// writeJSON writes a JSON response with a given status code.
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	responseBody, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(responseBody)
+}
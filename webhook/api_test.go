@@ -0,0 +1,457 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+	kubevirtfake "kubevirt.io/client-go/kubevirt/fake"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/pkg/client"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+const (
+	// testToken authenticates a user who may access the API and get instances in all namespaces.
+	testToken = "test-token"
+
+	// testNamespaceToken authenticates a user who may access the API and only get instances in the team-a namespace.
+	testNamespaceToken = "test-namespace-token"
+
+	// testForbiddenToken authenticates a user who may not access the API.
+	testForbiddenToken = "test-forbidden-token"
+)
+
+// testUsers are the users which are authenticated by each test token.
+var testUsers = map[string]string{
+	testToken:          "test-user",
+	testNamespaceToken: "test-namespace-user",
+	testForbiddenToken: "test-forbidden-user",
+}
+
+// testAccess returns if a test user is allowed the access described by a SubjectAccessReview spec.
+func testAccess(spec authorizationv1.SubjectAccessReviewSpec) bool {
+	switch spec.User {
+	case "test-user":
+		return true
+	case "test-namespace-user":
+		return spec.NonResourceAttributes != nil ||
+			(spec.ResourceAttributes != nil && spec.ResourceAttributes.Namespace == "team-a")
+	default:
+		return false
+	}
+}
+
+// newTestAPIServer returns a server whose webhook is backed by fake clients containing the given objects.  Only the
+// test tokens are authenticated.
+func newTestAPIServer(t *testing.T, nodes []runtime.Object, instances []runtime.Object) *Server {
+	t.Helper()
+
+	kubeClient := fake.NewSimpleClientset(nodes...)
+	kubeClient.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if username, found := testUsers[review.Spec.Token]; found {
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: username},
+			}
+		}
+
+		return true, review, nil
+	})
+	kubeClient.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		review.Status.Allowed = testAccess(review.Spec)
+
+		return true, review, nil
+	})
+
+	virtClient := kubevirtfake.NewSimpleClientset(instances...)
+
+	ctrl := gomock.NewController(t)
+	mockVirtClient := kubecli.NewMockKubevirtClient(ctrl)
+	mockVirtClient.EXPECT().VirtualMachineInstance(gomock.Any()).DoAndReturn(
+		func(namespace string) kubecli.VirtualMachineInstanceInterface {
+			return virtClient.KubevirtV1().VirtualMachineInstances(namespace)
+		},
+	).AnyTimes()
+
+	priority, err := newPriorityPolicy("2", "")
+	if err != nil {
+		t.Fatalf("newPriorityPolicy() error = %v", err)
+	}
+
+	explainer, err := newExplainer("", "")
+	if err != nil {
+		t.Fatalf("newExplainer() error = %v", err)
+	}
+
+	return &Server{webhook: &webhook{
+		KubeClient: kubeClient,
+		VirtClient: mockVirtClient,
+		NodeFilter: resources.NewNodeFilter("", "windows,windows-large"),
+		Logger:     zerolog.Nop(),
+		Priority:   priority,
+		Explainer:  explainer,
+	}}
+}
+
+func testNode(name, pool string, cpus int64) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{resources.DefaultLabelKey: pool}},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{corev1.ResourceCPU: *resource.NewQuantity(cpus, resource.DecimalSI)},
+		},
+	}
+}
+
+func testWindowsInstance(namespace, name, node string, cores uint32) *kubevirtv1.VirtualMachineInstance {
+	return &kubevirtv1.VirtualMachineInstance{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "kubevirt.io/v1", Kind: resources.VirtualMachineInstanceType},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: kubevirtv1.VirtualMachineInstanceSpec{
+			Domain: kubevirtv1.DomainSpec{
+				CPU: &kubevirtv1.CPU{Sockets: 1, Cores: cores, Threads: 1},
+			},
+			Volumes: []kubevirtv1.Volume{
+				{Name: "sysprep", VolumeSource: kubevirtv1.VolumeSource{Sysprep: &kubevirtv1.SysprepSource{}}},
+			},
+		},
+		Status: kubevirtv1.VirtualMachineInstanceStatus{NodeName: node, Phase: kubevirtv1.Running},
+	}
+}
+
+func TestWebhook_Capacity(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAPIServer(t,
+		[]runtime.Object{
+			testNode("node-1", "windows", 8),
+			testNode("node-2", "windows-large", 16),
+			testNode("node-3", "linux", 32),
+		},
+		[]runtime.Object{
+			testWindowsInstance("team-b", "vm-1", "node-1", 4),
+			testWindowsInstance("team-a", "vm-1", "node-2", 2),
+			testWindowsInstance("team-a", "vm-2", "node-2", 6),
+		},
+	)
+
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		wantStatus int
+		want       *client.CapacityResponse
+	}{
+		{
+			name:       "ensure capacity is broken down by pool and namespace",
+			method:     http.MethodGet,
+			token:      testToken,
+			wantStatus: http.StatusOK,
+			want: &client.CapacityResponse{
+				Total:     24,
+				Used:      12,
+				Reserved:  2,
+				Available: 10,
+				Pools: []client.PoolCapacity{
+					{Pool: "windows", Nodes: 1, Total: 8, Used: 4},
+					{Pool: "windows-large", Nodes: 1, Total: 16, Used: 8},
+				},
+				Namespaces: []client.NamespaceUsage{
+					{Namespace: "team-a", Instances: 2, Used: 8},
+					{Namespace: "team-b", Instances: 1, Used: 4},
+				},
+			},
+		},
+		{
+			name:       "ensure namespaces are filtered to those the user may get instances in",
+			method:     http.MethodGet,
+			token:      testNamespaceToken,
+			wantStatus: http.StatusOK,
+			want: &client.CapacityResponse{
+				Total:     24,
+				Used:      12,
+				Reserved:  2,
+				Available: 10,
+				Pools: []client.PoolCapacity{
+					{Pool: "windows", Nodes: 1, Total: 8, Used: 4},
+					{Pool: "windows-large", Nodes: 1, Total: 16, Used: 8},
+				},
+				Namespaces: []client.NamespaceUsage{
+					{Namespace: "team-a", Instances: 2, Used: 8},
+				},
+			},
+		},
+		{
+			name:       "ensure a user who may not access the api is forbidden",
+			method:     http.MethodGet,
+			token:      testForbiddenToken,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "ensure an unauthenticated request is rejected",
+			method:     http.MethodGet,
+			token:      "invalid-token",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "ensure a request without a token is rejected",
+			method:     http.MethodGet,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "ensure an unsupported method is rejected",
+			method:     http.MethodPost,
+			token:      testToken,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			request := httptest.NewRequest(tt.method, "/v1/capacity", nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			recorder := httptest.NewRecorder()
+			s.apiHandler(s.webhook.Capacity).ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("Capacity() status = %d, want %d; body %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			if tt.want == nil {
+				return
+			}
+
+			got := &client.CapacityResponse{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), got); err != nil {
+				t.Fatalf("Capacity() returned invalid response; %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Capacity() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhook_Check(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAPIServer(t,
+		[]runtime.Object{testNode("node-1", "windows", 16)},
+		[]runtime.Object{testWindowsInstance("team-a", "vm-1", "node-1", 8)},
+	)
+
+	manifest := func(object any) string {
+		raw, err := json.Marshal(object)
+		if err != nil {
+			t.Fatalf("failed to encode manifest; %v", err)
+		}
+
+		return string(raw)
+	}
+
+	linux := testWindowsInstance("team-a", "linux", "", 32)
+	linux.Spec.Volumes = nil
+
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantAllowed bool
+	}{
+		{
+			name:        "ensure a windows instance which fits is allowed",
+			body:        manifest(testWindowsInstance("team-a", "vm-2", "", 4)),
+			wantStatus:  http.StatusOK,
+			wantAllowed: true,
+		},
+		{
+			name:       "ensure a windows instance which does not fit within the unreserved capacity is denied",
+			body:       manifest(testWindowsInstance("team-a", "vm-2", "", 8)),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "ensure an instance which does not need validation is allowed",
+			body:        manifest(linux),
+			wantStatus:  http.StatusOK,
+			wantAllowed: true,
+		},
+		{
+			name:       "ensure an unsupported kind is rejected",
+			body:       `{"apiVersion":"v1","kind":"Pod"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "ensure an undecodable manifest is rejected",
+			body:       `not json`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			request := httptest.NewRequest(http.MethodPost, "/v1/check", bytes.NewBufferString(tt.body))
+			request.Header.Set("Authorization", "Bearer "+testToken)
+
+			recorder := httptest.NewRecorder()
+			s.apiHandler(s.webhook.Check).ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("Check() status = %d, want %d; body %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			got := &client.Decision{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), got); err != nil {
+				t.Fatalf("Check() returned invalid response; %v", err)
+			}
+
+			if got.Allowed != tt.wantAllowed {
+				t.Errorf("Check() allowed = %t, want %t; message %s", got.Allowed, tt.wantAllowed, got.Message)
+			}
+		})
+	}
+}
+
+func Test_apiVerb(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{method: http.MethodGet, want: "get"},
+		{method: http.MethodHead, want: "get"},
+		{method: http.MethodPost, want: "create"},
+		{method: http.MethodPut, want: "update"},
+		{method: http.MethodPatch, want: "patch"},
+		{method: http.MethodDelete, want: "delete"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.method, func(t *testing.T) {
+			t.Parallel()
+
+			if got := apiVerb(tt.method); got != tt.want {
+				t.Errorf("apiVerb() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhook_Explain(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAPIServer(t,
+		[]runtime.Object{testNode("node-1", "windows", 32)},
+		[]runtime.Object{
+			testWindowsInstance("team-a", "vm-1", "node-1", 4),
+			testWindowsInstance("team-b", "vm-1", "node-1", 8),
+			testWindowsInstance("team-c", "vm-1", "node-1", 2),
+		},
+	)
+
+	tests := []struct {
+		name       string
+		token      string
+		query      string
+		wantStatus int
+		want       *client.ExplainResponse
+	}{
+		{
+			name:       "ensure the top consumers are returned, largest first",
+			token:      testToken,
+			wantStatus: http.StatusOK,
+			want: &client.ExplainResponse{Consumers: []client.Consumer{
+				{Namespace: "team-b", VCPUs: 8},
+				{Namespace: "team-a", VCPUs: 4},
+				{Namespace: "team-c", VCPUs: 2},
+			}},
+		},
+		{
+			name:       "ensure the number of top consumers may be limited",
+			token:      testToken,
+			query:      "?top=1",
+			wantStatus: http.StatusOK,
+			want: &client.ExplainResponse{
+				Consumers: []client.Consumer{{Namespace: "team-b", VCPUs: 8}},
+				Others:    6,
+			},
+		},
+		{
+			name:       "ensure consumers the user may not see are only counted as others",
+			token:      testNamespaceToken,
+			wantStatus: http.StatusOK,
+			want: &client.ExplainResponse{
+				Consumers: []client.Consumer{{Namespace: "team-a", VCPUs: 4}},
+				Others:    10,
+			},
+		},
+		{
+			name:       "ensure an invalid number of top consumers is rejected",
+			token:      testToken,
+			query:      "?top=0",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			request := httptest.NewRequest(http.MethodGet, client.ExplainPath+tt.query, nil)
+			request.Header.Set("Authorization", "Bearer "+tt.token)
+
+			recorder := httptest.NewRecorder()
+			s.apiHandler(s.webhook.Explain).ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("Explain() status = %d, want %d; body %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			if tt.want == nil {
+				return
+			}
+
+			got := &client.ExplainResponse{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), got); err != nil {
+				t.Fatalf("Explain() returned invalid response; %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Explain() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/audit"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+const (
+	// EnvAudit enables the audit sink, which writes a record of each validation decision either to a file which is
+	// rotated by size (file) or to stderr (stderr), separately from the operational logs which are written to stdout.
+	EnvAudit = "WEBHOOK_AUDIT"
+
+	// EnvAuditPath is the file that audit records are written to when writing to a file.
+	EnvAuditPath = "WEBHOOK_AUDIT_PATH"
+
+	// EnvAuditMaxBytes is the size at which the audit file is rotated.
+	EnvAuditMaxBytes = "WEBHOOK_AUDIT_MAX_BYTES"
+
+	// EnvAuditMaxBackups is the number of rotated audit files which are kept.
+	EnvAuditMaxBackups = "WEBHOOK_AUDIT_MAX_BACKUPS"
+
+	// EnvAuditChain chains each audit record to the record before it by including the hash of the previous record, so
+	// that edits, deletions and truncation of the audit records may be detected.
+	EnvAuditChain = "WEBHOOK_AUDIT_CHAIN"
+
+	// EnvPolicyVersion is the version of the policy which is recorded with each decision, for example the release of
+	// the configuration that the webhook is deployed with.
+	EnvPolicyVersion = "WEBHOOK_POLICY_VERSION"
+
+	DefaultAuditPath       = "/var/lib/webhook/audit.jsonl"
+	DefaultAuditMaxBytes   = 10 * 1024 * 1024
+	DefaultAuditMaxBackups = 3
+)
+
+// AuditMode represents where audit records are written.
+type AuditMode string
+
+const (
+	AuditModeFile   AuditMode = "file"
+	AuditModeStderr AuditMode = "stderr"
+)
+
+// newAuditLogger returns a new instance of an audit logger given its configuration, or nil if auditing is disabled.
+// The defaults are used for any values which are unset.  Chained records written to a file continue the chain of the
+// records already in the file.
+func newAuditLogger(mode, path, maxBytes, maxBackups string, chained bool) (*audit.Logger, error) {
+	switch AuditMode(mode) {
+	case "":
+		return nil, nil
+	case AuditModeStderr:
+		// a chain written to stderr would restart from its first record whenever the webhook restarts, which cannot
+		// be told apart from records being deleted once it is checkpointed
+		if chained {
+			return nil, fmt.Errorf("[%s] requires [%s] to be [%s]", EnvAuditChain, EnvAudit, AuditModeFile)
+		}
+
+		return audit.NewLogger(os.Stderr), nil
+	case AuditModeFile:
+	default:
+		return nil, fmt.Errorf("invalid value [%s] for [%s]; must be one of [%s %s]", mode, EnvAudit, AuditModeFile, AuditModeStderr)
+	}
+
+	if path == "" {
+		path = DefaultAuditPath
+	}
+
+	size := int64(DefaultAuditMaxBytes)
+	if maxBytes != "" {
+		value, err := strconv.ParseInt(maxBytes, 10, 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid value [%s] for [%s]; must be a positive number", maxBytes, EnvAuditMaxBytes)
+		}
+
+		size = value
+	}
+
+	backups := DefaultAuditMaxBackups
+	if maxBackups != "" {
+		value, err := strconv.Atoi(maxBackups)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid value [%s] for [%s]; must be a non-negative number", maxBackups, EnvAuditMaxBackups)
+		}
+
+		backups = value
+	}
+
+	file, err := audit.NewRotatingFile(path, size, backups)
+	if err != nil {
+		return nil, err
+	}
+
+	if !chained {
+		return audit.NewLogger(file), nil
+	}
+
+	head, err := audit.LastHead(path)
+	if err != nil {
+		file.Close()
+
+		return nil, err
+	}
+
+	return audit.NewChainedLogger(file, head), nil
+}
+
+// policyHash returns a hash of the effective configuration of the policy which validation decisions are made under.
+func (wh *webhook) policyHash() string {
+	policy := struct {
+		LabelKey     string                      `json:"labelKey"`
+		LabelValues  []string                    `json:"labelValues"`
+		CountByLabel bool                        `json:"countByLabel"`
+		HighPriority int32                       `json:"highPriority"`
+		Reserved     string                      `json:"reserved"`
+		Failure      map[ErrorType]FailureAction `json:"failure"`
+	}{
+		CountByLabel: wh.CountByLabel,
+	}
+
+	if wh.NodeFilter != nil {
+		policy.LabelKey, policy.LabelValues = wh.NodeFilter.LabelKey(), wh.NodeFilter.LabelValues()
+	}
+
+	if wh.Priority != nil {
+		policy.HighPriority = wh.Priority.highPriority
+		policy.Reserved = fmt.Sprintf("%d+%d%%", wh.Priority.reservedVCPUs, wh.Priority.reservedPercent)
+	}
+
+	if wh.FailurePolicy != nil {
+		policy.Failure = wh.FailurePolicy.actions
+	}
+
+	// the policy always encodes as the fields are all simple types
+	data, _ := json.Marshal(policy)
+	sum := sha256.Sum256(data)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// audit writes the audit record of a validation decision once its response has been sent.  The instance and the
+// decision are nil if the request was decided before they were known, such as when it was skipped or failed.
+// Failing to audit a decision is logged but does not affect the response, which has already been sent.
+func (wh *webhook) audit(op *operation, instance *kubevirtv1.VirtualMachineInstance, decision *Decision, cached bool) {
+	if wh.auditor == nil || op.response.review == nil {
+		return
+	}
+
+	record := &audit.Record{
+		Time:    time.Now().UTC(),
+		UID:     string(op.response.uid),
+		Reasons: []string{},
+		Policy:  audit.Policy{Version: wh.policyVersion, Hash: wh.policy},
+		Outcome: audit.Outcome{Allowed: op.response.review.Response.Allowed, Cached: cached},
+	}
+
+	if result := op.response.review.Response.Result; result != nil {
+		record.Outcome.Code, record.Outcome.Message = result.Code, result.Message
+	}
+
+	if op.request != nil {
+		request := op.request.admissionRequest
+
+		record.User = audit.User{Username: request.UserInfo.Username, UID: request.UserInfo.UID, Groups: request.UserInfo.Groups}
+		record.Object = audit.Object{
+			Kind:      request.Kind.Kind,
+			Namespace: request.Namespace,
+			Name:      request.Name,
+			Operation: string(request.Operation),
+			DryRun:    op.isDryRun(),
+		}
+
+		// the name of the object is not known to the api server until it is generated
+		if op.object != nil && op.object.GetName() != "" {
+			record.Object.Name = op.object.GetName()
+		}
+
+		if instance == nil && op.object != nil {
+			instance, _ = decodeInstance(request.Kind.Kind, request.Object.Raw)
+		}
+	}
+
+	if instance != nil {
+		if reasons := resources.WindowsReasons(instance); reasons != nil {
+			record.Reasons = reasons
+		}
+	}
+
+	if decision != nil {
+		record.ChargedVCPUs = decision.Requested
+		record.Capacity = &audit.Capacity{
+			Total:     decision.Total,
+			Used:      decision.Used,
+			Reserved:  decision.Reserved,
+			Available: decision.Available,
+			Priority:  decision.Priority,
+		}
+	}
+
+	if err := wh.auditor.Write(record); err != nil {
+		withOperation(wh.Logger.Error(), op).Err(err).Msg("failed to write audit record")
+	}
+}
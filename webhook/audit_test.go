@@ -0,0 +1,236 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/audit"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/snapshot"
+)
+
+func Test_newAuditLogger(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		mode       string
+		maxBytes   string
+		maxBackups string
+		chained    bool
+		wantNil    bool
+		wantErr    bool
+	}{
+		{
+			name:    "ensure auditing is disabled by default",
+			wantNil: true,
+		},
+		{
+			name: "ensure audit records may be written to stderr",
+			mode: "stderr",
+		},
+		{
+			name:    "ensure audit records written to stderr may not be chained",
+			mode:    "stderr",
+			chained: true,
+			wantErr: true,
+		},
+		{
+			name: "ensure audit records may be written to a file",
+			mode: "file",
+		},
+		{
+			name:    "ensure audit records written to a file may be chained",
+			mode:    "file",
+			chained: true,
+		},
+		{
+			name:    "ensure an invalid mode returns an error",
+			mode:    "stdout",
+			wantErr: true,
+		},
+		{
+			name:     "ensure an invalid maximum size returns an error",
+			mode:     "file",
+			maxBytes: "-1",
+			wantErr:  true,
+		},
+		{
+			name:       "ensure an invalid number of backups returns an error",
+			mode:       "file",
+			maxBackups: "some",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := newAuditLogger(tt.mode, t.TempDir()+"/audit.jsonl", tt.maxBytes, tt.maxBackups, tt.chained)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newAuditLogger() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && (got == nil) != tt.wantNil {
+				t.Errorf("newAuditLogger() = %v, wantNil %v", got, tt.wantNil)
+			}
+		})
+	}
+}
+
+func Test_newAuditLogger_chained(t *testing.T) {
+	t.Parallel()
+
+	path := t.TempDir() + "/audit.jsonl"
+
+	// each logger continues the chain of the records written by the one before it, as the webhook does on restart
+	for i := 0; i < 2; i++ {
+		logger, err := newAuditLogger("file", path, "", "", true)
+		if err != nil {
+			t.Fatalf("newAuditLogger() error = %v", err)
+		}
+
+		if err := logger.Write(&audit.Record{UID: "uid"}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+
+		if head, _ := logger.Head(); head.Sequence != uint64(i+1) {
+			t.Errorf("Head() sequence = %d, want %d", head.Sequence, i+1)
+		}
+
+		logger.Close()
+	}
+
+	verifier := audit.NewVerifier(nil)
+	if err := verifier.VerifyFiles(path); err != nil {
+		t.Fatalf("VerifyFiles() error = %v", err)
+	}
+
+	if result := verifier.Result(); !result.Intact() || result.Records != 2 {
+		t.Errorf("Result() = %+v, want an intact chain of 2 records", result)
+	}
+}
+
+func TestWebhook_audit(t *testing.T) {
+	t.Parallel()
+
+	s := &snapshot.Snapshot{
+		Nodes:                   []corev1.Node{*testNode("node-1", "windows", 8)},
+		VirtualMachineInstances: []kubevirtv1.VirtualMachineInstance{*testWindowsInstance("team-a", "vm-1", "node-1", 6)},
+	}
+
+	wh, err := NewWebhookForClients(s.Clients())
+	if err != nil {
+		t.Fatalf("NewWebhookForClients() error = %v", err)
+	}
+
+	output := &bytes.Buffer{}
+	wh.auditor = audit.NewLogger(output)
+	wh.policyVersion = "v1.2.3"
+
+	review := func(uid string, instance *kubevirtv1.VirtualMachineInstance) []byte {
+		object, err := json.Marshal(instance)
+		if err != nil {
+			t.Fatalf("failed to encode object; %v", err)
+		}
+
+		raw, err := json.Marshal(admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request: &admissionv1.AdmissionRequest{
+				UID:       k8stypes.UID(uid),
+				Kind:      metav1.GroupVersionKind{Group: "kubevirt.io", Version: "v1", Kind: "VirtualMachineInstance"},
+				Resource:  metav1.GroupVersionResource{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachineinstances"},
+				Namespace: instance.Namespace,
+				Operation: admissionv1.Create,
+				UserInfo:  authenticationv1.UserInfo{Username: "alice", Groups: []string{"team-b"}},
+				Object:    runtime.RawExtension{Raw: object},
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to encode admission review; %v", err)
+		}
+
+		return raw
+	}
+
+	linux := testWindowsInstance("team-b", "linux", "", 4)
+	linux.Spec.Volumes = nil
+
+	// each review is evaluated in order, so that the retried review replays the decision of the first
+	for _, body := range [][]byte{
+		review("uid-fits", testWindowsInstance("team-b", "fits", "", 2)),
+		review("uid-exceeds", testWindowsInstance("team-b", "exceeds", "", 4)),
+		review("uid-linux", linux),
+		review("uid-fits", testWindowsInstance("team-b", "fits", "", 2)),
+		[]byte(`not json`),
+	} {
+		if _, err := wh.Evaluate(context.Background(), body); err != nil {
+			t.Fatalf("Evaluate() error = %v", err)
+		}
+	}
+
+	records := []audit.Record{}
+	for _, line := range bytes.Split(bytes.TrimSpace(output.Bytes()), []byte("\n")) {
+		record := audit.Record{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("audit() wrote an invalid record; %v", err)
+		}
+
+		records = append(records, record)
+	}
+
+	if len(records) != 5 {
+		t.Fatalf("audit() wrote %d records, want 5", len(records))
+	}
+
+	fits, exceeds, linuxRecord, retried, invalid := records[0], records[1], records[2], records[3], records[4]
+
+	if !fits.Outcome.Allowed || fits.ChargedVCPUs != 2 || fits.Capacity == nil || fits.Capacity.Used != 6 || fits.Capacity.Total != 8 {
+		t.Errorf("audit() allowed record = %+v, want 2 vCPUs charged against 6 of 8 used", fits)
+	}
+
+	if fits.User.Username != "alice" || fits.Object.Name != "fits" || fits.Object.Namespace != "team-b" || fits.UID != "uid-fits" {
+		t.Errorf("audit() allowed record = %+v, want the user and object of the request", fits)
+	}
+
+	if len(fits.Reasons) == 0 || fits.Reasons[0] != "has sysprep volume" {
+		t.Errorf("audit() allowed record reasons = %v, want the detection reasons", fits.Reasons)
+	}
+
+	if fits.Policy.Version != "v1.2.3" || fits.Policy.Hash != wh.policyHash() {
+		t.Errorf("audit() allowed record policy = %+v, want the policy version and hash", fits.Policy)
+	}
+
+	changed := &webhook{NodeFilter: wh.NodeFilter, FailurePolicy: wh.FailurePolicy, Priority: wh.Priority, CountByLabel: !wh.CountByLabel}
+
+	if changed.policyHash() == fits.Policy.Hash {
+		t.Errorf("policyHash() did not change with the policy")
+	}
+
+	if exceeds.Outcome.Allowed || exceeds.Outcome.Code != 403 || exceeds.ChargedVCPUs != 4 {
+		t.Errorf("audit() denied record = %+v, want denied", exceeds)
+	}
+
+	if !linuxRecord.Outcome.Allowed || linuxRecord.Capacity != nil || len(linuxRecord.Reasons) != 0 {
+		t.Errorf("audit() skipped record = %+v, want allowed without capacity", linuxRecord)
+	}
+
+	if !retried.Outcome.Cached || !retried.Outcome.Allowed {
+		t.Errorf("audit() retried record = %+v, want the cached decision", retried)
+	}
+
+	if invalid.Outcome.Allowed || invalid.UID != "" {
+		t.Errorf("audit() invalid record = %+v, want denied", invalid)
+	}
+}
@@ -0,0 +1,238 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// reviewCacheTTL is the amount of time that the results of token and access reviews are cached for.  It is kept
+	// short so that revoked tokens and permissions stop applying soon after they are revoked.
+	reviewCacheTTL = 10 * time.Second
+
+	// maxReviewCacheEntries is the maximum number of results which are held by a review cache, so that many distinct
+	// tokens or users may not grow the cache without bound.
+	maxReviewCacheEntries = 1024
+)
+
+// reviewCache is a short-lived cache of the results of token and access reviews so that the API does not send a
+// review to the API server for every request.  A nil cache caches nothing.
+type reviewCache[T any] struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]reviewCacheEntry[T]
+}
+
+// reviewCacheEntry represents a cached review result.
+type reviewCacheEntry[T any] struct {
+	result  T
+	expires time.Time
+}
+
+// newReviewCache returns a new review cache which holds results for the given ttl.
+func newReviewCache[T any](ttl time.Duration) *reviewCache[T] {
+	return &reviewCache[T]{ttl: ttl, entries: map[string]reviewCacheEntry[T]{}}
+}
+
+// get returns the cached result for a key, unless it has expired.
+func (cache *reviewCache[T]) get(key string) (T, bool) {
+	var result T
+	if cache == nil {
+		return result, false
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cached, found := cache.entries[key]
+	if !found || time.Now().After(cached.expires) {
+		return result, false
+	}
+
+	return cached.result, true
+}
+
+// put stores the result for a key.  Expired results are purged when the cache is full, and the result is not stored
+// if the cache is still full.
+func (cache *reviewCache[T]) put(key string, result T) {
+	if cache == nil {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+
+	if _, found := cache.entries[key]; !found && len(cache.entries) >= maxReviewCacheEntries {
+		for existing, cached := range cache.entries {
+			if now.After(cached.expires) {
+				delete(cache.entries, existing)
+			}
+		}
+
+		if len(cache.entries) >= maxReviewCacheEntries {
+			return
+		}
+	}
+
+	cache.entries[key] = reviewCacheEntry[T]{result: result, expires: now.Add(cache.ttl)}
+}
+
+// authenticate authenticates the bearer token of a request with a TokenReview, returning the authenticated user.
+// Authenticated users are cached by a hash of the token, so that the token itself is not held in memory.
+func (wh *webhook) authenticate(r *http.Request) (*authenticationv1.UserInfo, error) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return nil, errors.New("missing bearer token")
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(hash[:])
+
+	if userInfo, found := wh.tokenReviews.get(key); found {
+		return &userInfo, nil
+	}
+
+	review, err := wh.KubeClient.AuthenticationV1().TokenReviews().Create(r.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to review token; %w", err)
+	}
+
+	if !review.Status.Authenticated {
+		return nil, fmt.Errorf("invalid bearer token; %s", review.Status.Error)
+	}
+
+	// only authenticated tokens are cached, so that invalid tokens may not fill the cache
+	wh.tokenReviews.put(key, review.Status.User)
+
+	return &review.Status.User, nil
+}
+
+// authorize authorizes a user to make a request with a SubjectAccessReview for the path of the request, using the
+// verb which corresponds to the method of the request.  This allows access to the API to be granted with the
+// nonResourceURLs of a ClusterRole.
+func (wh *webhook) authorize(r *http.Request, userInfo authenticationv1.UserInfo) (bool, error) {
+	return wh.reviewAccess(r.Context(), userInfo, authorizationv1.SubjectAccessReviewSpec{
+		NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+			Path: r.URL.Path,
+			Verb: apiVerb(r.Method),
+		},
+	})
+}
+
+// canAccessInstances returns if a user may perform a verb on virtual machine instances in a namespace, or in all
+// namespaces if the namespace is empty.  Any failure to check is treated as the user not being allowed.
+func (wh *webhook) canAccessInstances(ctx context.Context, userInfo authenticationv1.UserInfo, verb, namespace string) bool {
+	allowed, err := wh.reviewAccess(ctx, userInfo, authorizationv1.SubjectAccessReviewSpec{
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      verb,
+			Group:     "kubevirt.io",
+			Resource:  "virtualmachineinstances",
+		},
+	})
+	if err != nil {
+		wh.Logger.Debug().Err(err).Str("namespace", namespace).Msg("failed to review access to namespace")
+
+		return false
+	}
+
+	return allowed
+}
+
+// visibleNamespaces returns the namespaces in which a user may perform a verb on virtual machine instances.  Each
+// namespace is only checked individually if the user may not perform the verb in all namespaces, and namespaces are
+// assumed to be hidden once the maximum number of namespaces have been checked.
+func (wh *webhook) visibleNamespaces(
+	ctx context.Context,
+	userInfo authenticationv1.UserInfo,
+	verb string,
+	namespaces []string,
+) map[string]bool {
+	visible := make(map[string]bool, len(namespaces))
+
+	all := wh.canAccessInstances(ctx, userInfo, verb, metav1.NamespaceAll)
+
+	for _, namespace := range namespaces {
+		if _, checked := visible[namespace]; checked {
+			continue
+		}
+
+		visible[namespace] = all || (len(visible) < maxVisibilityChecks && wh.canAccessInstances(ctx, userInfo, verb, namespace))
+	}
+
+	return visible
+}
+
+// reviewAccess returns if a user is allowed the access described by the attributes of a SubjectAccessReview spec.
+// Results are cached by the user and the attributes of the spec.
+func (wh *webhook) reviewAccess(
+	ctx context.Context,
+	userInfo authenticationv1.UserInfo,
+	spec authorizationv1.SubjectAccessReviewSpec,
+) (bool, error) {
+	extra := make(map[string]authorizationv1.ExtraValue, len(userInfo.Extra))
+	for key, value := range userInfo.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+
+	spec.User = userInfo.Username
+	spec.Groups = userInfo.Groups
+	spec.UID = userInfo.UID
+	spec.Extra = extra
+
+	// the spec is marshalled with sorted map keys, so it is a stable key for the same user and attributes
+	key, err := json.Marshal(spec)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal access review; %w", err)
+	}
+
+	if allowed, found := wh.accessReviews.get(string(key)); found {
+		return allowed, nil
+	}
+
+	review, err := wh.KubeClient.AuthorizationV1().SubjectAccessReviews().Create(
+		ctx,
+		&authorizationv1.SubjectAccessReview{Spec: spec},
+		metav1.CreateOptions{},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to review access; %w", err)
+	}
+
+	wh.accessReviews.put(string(key), review.Status.Allowed)
+
+	return review.Status.Allowed, nil
+}
+
+// apiVerb returns the authorization verb which corresponds to an http method, in the same way that the API server
+// does for requests to non-resource URLs.
+func apiVerb(method string) string {
+	switch method {
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "get"
+	}
+}
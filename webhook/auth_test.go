@@ -0,0 +1,212 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testReviewWebhook returns the webhook of a test API server with review caches, along with its fake client so that
+// the reviews which are sent may be counted.
+func testReviewWebhook(t *testing.T) (*webhook, *fake.Clientset) {
+	t.Helper()
+
+	wh := newTestAPIServer(t, nil, nil).webhook
+	wh.tokenReviews = newReviewCache[authenticationv1.UserInfo](time.Minute)
+	wh.accessReviews = newReviewCache[bool](time.Minute)
+
+	return wh, wh.KubeClient.(*fake.Clientset)
+}
+
+// countActions returns the number of actions of a fake client for a resource.
+func countActions(client *fake.Clientset, resource string) int {
+	count := 0
+
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == resource {
+			count++
+		}
+	}
+
+	return count
+}
+
+func TestWebhook_authenticate_cache(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		token       string
+		wantErr     bool
+		wantReviews int
+	}{
+		{
+			name:        "ensure an authenticated token is only reviewed once",
+			token:       testToken,
+			wantReviews: 1,
+		},
+		{
+			name:        "ensure an invalid token is reviewed every time",
+			token:       "invalid-token",
+			wantErr:     true,
+			wantReviews: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			wh, client := testReviewWebhook(t)
+
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/api/v1/capacity", nil)
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+
+				user, err := wh.authenticate(r)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("authenticate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				if !tt.wantErr && user.Username != testUsers[tt.token] {
+					t.Errorf("authenticate() user = %s, want %s", user.Username, testUsers[tt.token])
+				}
+			}
+
+			if got := countActions(client, "tokenreviews"); got != tt.wantReviews {
+				t.Errorf("authenticate() sent %d token reviews, want %d", got, tt.wantReviews)
+			}
+		})
+	}
+}
+
+func TestWebhook_visibleNamespaces(t *testing.T) {
+	t.Parallel()
+
+	namespaces := []string{"team-a"}
+	for i := 0; i < 2*maxVisibilityChecks; i++ {
+		namespaces = append(namespaces, fmt.Sprintf("team-%d", i))
+	}
+
+	tests := []struct {
+		name        string
+		user        string
+		wantVisible int
+		wantReviews int
+	}{
+		{
+			name:        "ensure every namespace is visible with access to all namespaces",
+			user:        testUsers[testToken],
+			wantVisible: len(namespaces),
+			wantReviews: 1,
+		},
+		{
+			name:        "ensure the number of namespaces checked individually is bounded",
+			user:        testUsers[testNamespaceToken],
+			wantVisible: 1,
+			wantReviews: 1 + maxVisibilityChecks,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			wh, client := testReviewWebhook(t)
+			user := authenticationv1.UserInfo{Username: tt.user}
+
+			// the second call is answered from the cache
+			for i := 0; i < 2; i++ {
+				visible := wh.visibleNamespaces(context.Background(), user, "get", namespaces)
+
+				count := 0
+
+				for _, namespace := range namespaces {
+					if visible[namespace] {
+						count++
+					}
+				}
+
+				if count != tt.wantVisible {
+					t.Errorf("visibleNamespaces() visible = %d, want %d", count, tt.wantVisible)
+				}
+			}
+
+			if got := countActions(client, "subjectaccessreviews"); got != tt.wantReviews {
+				t.Errorf("visibleNamespaces() sent %d access reviews, want %d", got, tt.wantReviews)
+			}
+		})
+	}
+}
+
+func Test_reviewCache(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		cache       *reviewCache[bool]
+		fill        int
+		wantFound   bool
+		wantEntries int
+	}{
+		{
+			name:        "ensure a result is cached",
+			cache:       newReviewCache[bool](time.Minute),
+			wantFound:   true,
+			wantEntries: 1,
+		},
+		{
+			name:        "ensure an expired result is not returned",
+			cache:       newReviewCache[bool](-time.Minute),
+			wantFound:   false,
+			wantEntries: 1,
+		},
+		{
+			name:        "ensure a result is not cached when the cache is full",
+			cache:       newReviewCache[bool](time.Minute),
+			fill:        maxReviewCacheEntries,
+			wantFound:   false,
+			wantEntries: maxReviewCacheEntries,
+		},
+		{
+			name:        "ensure expired results are purged when the cache is full",
+			cache:       newReviewCache[bool](-time.Minute),
+			fill:        maxReviewCacheEntries,
+			wantFound:   false,
+			wantEntries: 1,
+		},
+		{
+			name:      "ensure a nil cache caches nothing",
+			wantFound: false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			for i := 0; i < tt.fill; i++ {
+				tt.cache.put(fmt.Sprintf("fill-%d", i), false)
+			}
+
+			tt.cache.put("key", true)
+
+			if _, found := tt.cache.get("key"); found != tt.wantFound {
+				t.Errorf("reviewCache.get() found = %v, want %v", found, tt.wantFound)
+			}
+
+			if tt.cache != nil && len(tt.cache.entries) != tt.wantEntries {
+				t.Errorf("reviewCache has %d entries, want %d", len(tt.cache.entries), tt.wantEntries)
+			}
+		})
+	}
+}
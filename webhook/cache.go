@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/metrics"
+)
+
+const (
+	EnvDecisionCacheTTL = "WEBHOOK_DECISION_CACHE_TTL"
+
+	// DefaultDecisionCacheTTL is the default amount of time that a decision is cached for.  This comfortably
+	// covers the API server retrying a request after the webhook timeout.
+	DefaultDecisionCacheTTL = 60 * time.Second
+)
+
+// decision represents the decision that was made for an admission request.
+type decision struct {
+	allowed bool
+	code    int32
+	message string
+	expires time.Time
+}
+
+// decisionCache is a short-lived cache of decisions keyed by the admission request UID.  The API server may retry
+// a request with the same UID, in which case the original decision is replayed rather than recalculated.
+type decisionCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[types.UID]decision
+	lastPurge time.Time
+}
+
+// newDecisionCache returns a new decision cache which holds decisions for the given ttl.  A ttl of zero disables
+// the cache.
+func newDecisionCache(ttl time.Duration) *decisionCache {
+	return &decisionCache{
+		ttl:       ttl,
+		entries:   map[types.UID]decision{},
+		lastPurge: time.Now(),
+	}
+}
+
+// get returns the cached decision for a request UID.
+func (cache *decisionCache) get(uid types.UID) (decision, bool) {
+	if cache.ttl == 0 || uid == "" {
+		return decision{}, false
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cached, found := cache.entries[uid]
+	if found && time.Now().After(cached.expires) {
+		delete(cache.entries, uid)
+		found = false
+	}
+
+	if found {
+		metrics.DecisionCacheRequests.WithLabelValues("hit").Inc()
+	} else {
+		metrics.DecisionCacheRequests.WithLabelValues("miss").Inc()
+	}
+
+	return cached, found
+}
+
+// put stores the decision for a request UID.
+func (cache *decisionCache) put(uid types.UID, allowed bool, code int32, message string) {
+	if cache.ttl == 0 || uid == "" {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+
+	cache.entries[uid] = decision{
+		allowed: allowed,
+		code:    code,
+		message: message,
+		expires: now.Add(cache.ttl),
+	}
+
+	// purge expired decisions once per ttl so that the cache does not grow without bound
+	if now.Sub(cache.lastPurge) > cache.ttl {
+		for key, cached := range cache.entries {
+			if now.After(cached.expires) {
+				delete(cache.entries, key)
+			}
+		}
+
+		cache.lastPurge = now
+	}
+
+	metrics.DecisionCacheEntries.Set(float64(len(cache.entries)))
+}
@@ -0,0 +1,168 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/snapshot"
+)
+
+func Test_decisionCache(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		uid       string
+		wait      time.Duration
+		wantFound bool
+	}{
+		{
+			name:      "ensure a decision is replayed for the same uid",
+			ttl:       time.Minute,
+			uid:       "test-uid",
+			wantFound: true,
+		},
+		{
+			name:      "ensure a decision is not replayed after it expires",
+			ttl:       time.Millisecond,
+			uid:       "test-uid",
+			wait:      5 * time.Millisecond,
+			wantFound: false,
+		},
+		{
+			name:      "ensure a decision is not cached when the cache is disabled",
+			ttl:       0,
+			uid:       "test-uid",
+			wantFound: false,
+		},
+		{
+			name:      "ensure a decision is not cached for an empty uid",
+			ttl:       time.Minute,
+			uid:       "",
+			wantFound: false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache := newDecisionCache(tt.ttl)
+			cache.put("test-uid", false, http.StatusForbidden, "denied")
+
+			time.Sleep(tt.wait)
+
+			cached, found := cache.get(types.UID(tt.uid))
+			if found != tt.wantFound {
+				t.Fatalf("decisionCache.get() found = %v, want %v", found, tt.wantFound)
+			}
+
+			if found && (cached.allowed || cached.code != http.StatusForbidden || cached.message != "denied") {
+				t.Errorf("decisionCache.get() = %+v, want original decision", cached)
+			}
+		})
+	}
+}
+
+func TestWebhook_Validate_dryRun(t *testing.T) {
+	t.Parallel()
+
+	s := &snapshot.Snapshot{
+		Nodes:                   []corev1.Node{*testNode("node-1", "windows", 8)},
+		VirtualMachineInstances: []kubevirtv1.VirtualMachineInstance{*testWindowsInstance("team-a", "vm-1", "node-1", 2)},
+	}
+
+	tests := []struct {
+		name        string
+		uid         string
+		cores       uint32
+		dryRun      bool
+		wantAllowed bool
+		wantMessage string
+		wantCached  bool
+	}{
+		{
+			name:        "ensure a dry run request is not cached and is told the remaining capacity",
+			uid:         "uid-dry-run",
+			cores:       4,
+			dryRun:      true,
+			wantAllowed: true,
+			wantMessage: "request success (dry run); remaining capacity: [2]",
+		},
+		{
+			name:        "ensure a denied dry run request is not cached",
+			uid:         "uid-dry-run-denied",
+			cores:       8,
+			dryRun:      true,
+			wantAllowed: false,
+			wantMessage: "requested capacity: [8], exceeds available capacity: [6]; currently used [2]; " +
+				"top consumers: [team-a: 2 vCPUs]",
+		},
+		{
+			name:        "ensure a request which is not a dry run is cached",
+			uid:         "uid-real",
+			cores:       4,
+			wantAllowed: true,
+			wantMessage: "request success",
+			wantCached:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			wh, err := NewWebhookForClients(s.Clients())
+			if err != nil {
+				t.Fatalf("NewWebhookForClients() error = %v", err)
+			}
+
+			object, _ := json.Marshal(testWindowsInstance("team-a", "vm-2", "", tt.cores))
+
+			review, _ := json.Marshal(admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+				Request: &admissionv1.AdmissionRequest{
+					UID:       types.UID(tt.uid),
+					Kind:      metav1.GroupVersionKind{Group: "kubevirt.io", Version: "v1", Kind: resources.VirtualMachineInstanceType},
+					Namespace: "team-a",
+					Operation: admissionv1.Create,
+					DryRun:    &tt.dryRun,
+					Object:    runtime.RawExtension{Raw: object},
+				},
+			})
+
+			body, err := wh.Evaluate(context.Background(), review)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+
+			response := &admissionv1.AdmissionReview{}
+			if err := json.Unmarshal(body, response); err != nil {
+				t.Fatalf("Evaluate() returned an invalid review; %v", err)
+			}
+
+			if response.Response.Allowed != tt.wantAllowed || response.Response.Result.Message != tt.wantMessage {
+				t.Errorf("Evaluate() = %v, %q, want %v, %q",
+					response.Response.Allowed, response.Response.Result.Message, tt.wantAllowed, tt.wantMessage)
+			}
+
+			if _, found := wh.decisions.get(types.UID(tt.uid)); found != tt.wantCached {
+				t.Errorf("decisionCache.get() found = %v, want %v", found, tt.wantCached)
+			}
+		})
+	}
+}
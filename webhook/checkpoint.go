@@ -0,0 +1,295 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/audit"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/clients"
+)
+
+const (
+	// EnvAuditCheckpointName is the name of the config map which the head of each chain of audit records is
+	// checkpointed to.
+	EnvAuditCheckpointName = "WEBHOOK_AUDIT_CHECKPOINT_NAME"
+
+	// EnvAuditCheckpointInterval is how often the head of the chain of audit records is checkpointed, or 0 to disable
+	// checkpoints.
+	EnvAuditCheckpointInterval = "WEBHOOK_AUDIT_CHECKPOINT_INTERVAL"
+
+	DefaultAuditCheckpointName     = "windows-overcommit-audit-checkpoint"
+	DefaultAuditCheckpointInterval = time.Minute
+
+	// auditCheckpointKeySuffix is the suffix of the file, alongside the audit file, which holds the key that the chain
+	// of audit records in the file is checkpointed under.
+	auditCheckpointKeySuffix = ".checkpoint-key"
+
+	// checkpointTimeout is the amount of time allowed for a single checkpoint.
+	checkpointTimeout = 10 * time.Second
+)
+
+// checkpointer periodically checkpoints the head of a chain of audit records to a config map, so that deleting or
+// truncating the audit records beyond the checkpoint may be detected.  Each chain is checkpointed under its own key,
+// which is stored alongside its audit file so that the key is kept for as long as the chain is.
+type checkpointer struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	key       string
+	interval  time.Duration
+
+	// last is the head which was last checkpointed, so that an unchanged head is not checkpointed again.
+	last audit.Head
+
+	// behind is set once the chain is found to be behind its checkpoint, after which the checkpoint is never
+	// written again so that it is kept as evidence of the records which were lost.
+	behind error
+}
+
+// newCheckpointer returns a new instance of a checkpointer given the config map name and interval, using the defaults
+// if they are unset, or nil if checkpoints are disabled.  The chain of the audit file at the path is checkpointed under
+// the key which is stored alongside it, which is created with the identity of this process if there is none.
+func newCheckpointer(client kubernetes.Interface, name, interval, path string) (*checkpointer, error) {
+	c := &checkpointer{
+		client:    client,
+		namespace: clients.Namespace(),
+		name:      name,
+		interval:  DefaultAuditCheckpointInterval,
+	}
+
+	if c.name == "" {
+		c.name = DefaultAuditCheckpointName
+	}
+
+	if interval != "" {
+		value, err := time.ParseDuration(interval)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid value [%s] for [%s]; must be a non-negative duration", interval, EnvAuditCheckpointInterval)
+		}
+
+		c.interval = value
+	}
+
+	if c.interval == 0 {
+		return nil, nil
+	}
+
+	if path == "" {
+		path = DefaultAuditPath
+	}
+
+	key, err := ReadAuditCheckpointKey(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		key = clients.Identity()
+		if err := os.WriteFile(AuditCheckpointKeyPath(path), []byte(key+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write audit checkpoint key; %w", err)
+		}
+	case err != nil:
+		return nil, err
+	}
+
+	c.key = key
+
+	return c, nil
+}
+
+// AuditCheckpointKeyPath returns the path of the file which holds the key that the chain of audit records in an audit
+// file is checkpointed under.
+func AuditCheckpointKeyPath(path string) string {
+	return path + auditCheckpointKeySuffix
+}
+
+// ReadAuditCheckpointKey returns the key that the chain of audit records in an audit file is checkpointed under.  An
+// error which wraps fs.ErrNotExist is returned if the chain has no key.
+func ReadAuditCheckpointKey(path string) (string, error) {
+	content, err := os.ReadFile(AuditCheckpointKeyPath(path))
+	if err != nil {
+		return "", fmt.Errorf("failed to read audit checkpoint key; %w", err)
+	}
+
+	key := strings.TrimSpace(string(content))
+	if key == "" {
+		return "", fmt.Errorf("audit checkpoint key [%s] is empty", AuditCheckpointKeyPath(path))
+	}
+
+	return key, nil
+}
+
+// checkpoint writes the head of the chain to the config map under the key of the chain, unless it has not changed
+// since it was last written.  An error is returned, and the checkpoint is left as it is, if the head is behind the
+// checkpoint, which happens when records beyond the checkpoint have been deleted.
+func (c *checkpointer) checkpoint(ctx context.Context, head audit.Head) error {
+	if c.behind != nil {
+		return c.behind
+	}
+
+	if head.Sequence == 0 || head == c.last {
+		return nil
+	}
+
+	content, err := json.Marshal(head)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit checkpoint; %w", err)
+	}
+
+	configMaps := c.client.CoreV1().ConfigMaps(c.namespace)
+
+	// other chains are checkpointed under their own keys in the same config map
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := configMaps.Get(ctx, c.name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: c.name, Namespace: c.namespace},
+				Data:       map[string]string{c.key: string(content)},
+			}, metav1.CreateOptions{})
+		case err == nil:
+			if err := c.verifyAhead(configMap.Data[c.key], head); err != nil {
+				return err
+			}
+
+			if configMap.Data == nil {
+				configMap.Data = map[string]string{}
+			}
+
+			configMap.Data[c.key] = string(content)
+
+			_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		}
+
+		return err
+	})
+
+	if c.behind != nil {
+		return c.behind
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to write audit checkpoint to config map [%s/%s]; %w", c.namespace, c.name, err)
+	}
+
+	c.last = head
+
+	return nil
+}
+
+// verifyAhead verifies that the head of the chain is ahead of the head which is already checkpointed, if any, or is
+// the same head.  A head which is behind means that the chain restarted, for example because its file was deleted, so the checkpointer
+// stops writing checkpoints rather than replacing the evidence of it.
+func (c *checkpointer) verifyAhead(content string, head audit.Head) error {
+	if content == "" {
+		return nil
+	}
+
+	checkpointed := audit.Head{}
+	if err := json.Unmarshal([]byte(content), &checkpointed); err != nil {
+		return fmt.Errorf("failed to decode existing audit checkpoint for [%s]; %w", c.key, err)
+	}
+
+	// the same head is checkpointed again when the webhook restarts without writing any records
+	if head.Sequence > checkpointed.Sequence || (head.Sequence == checkpointed.Sequence && head.Hash == checkpointed.Hash) {
+		return nil
+	}
+
+	c.behind = fmt.Errorf(
+		"audit chain at sequence [%d] is not ahead of its checkpoint at sequence [%d] in config map [%s/%s]; "+
+			"records may have been deleted, so the checkpoint is kept and no further checkpoints are written",
+		head.Sequence, checkpointed.Sequence, c.namespace, c.name,
+	)
+
+	return c.behind
+}
+
+// ReadAuditCheckpoint returns the head of a chain of audit records which was checkpointed to a config map under a key.
+func ReadAuditCheckpoint(ctx context.Context, client kubernetes.Interface, namespace, name, key string) (*audit.Head, error) {
+	configMap, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit checkpoint config map [%s/%s]; %w", namespace, name, err)
+	}
+
+	content, found := configMap.Data[key]
+	if !found {
+		return nil, fmt.Errorf("no audit checkpoint for [%s] in config map [%s/%s]", key, namespace, name)
+	}
+
+	head := &audit.Head{}
+	if err := json.Unmarshal([]byte(content), head); err != nil {
+		return nil, fmt.Errorf("failed to decode audit checkpoint for [%s]; %w", key, err)
+	}
+
+	return head, nil
+}
+
+// ReadAuditCheckpointKeys returns the sorted keys of every chain of audit records which was checkpointed to a config
+// map, so that the checkpoints of chains whose records were lost along with their key may be found.
+func ReadAuditCheckpointKeys(ctx context.Context, client kubernetes.Interface, namespace, name string) ([]string, error) {
+	configMap, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit checkpoint config map [%s/%s]; %w", namespace, name, err)
+	}
+
+	keys := make([]string, 0, len(configMap.Data))
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	return keys, nil
+}
+
+// checkpointAudit checkpoints the head of the chain of audit records, if they are chained and checkpointed.
+func (wh *webhook) checkpointAudit(ctx context.Context) {
+	if wh.checkpoints == nil || wh.auditor == nil {
+		return
+	}
+
+	head, chained := wh.auditor.Head()
+	if !chained {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkpointTimeout)
+	defer cancel()
+
+	if err := wh.checkpoints.checkpoint(ctx, head); err != nil {
+		wh.Logger.Error().Err(err).Msg("failed to checkpoint audit records")
+
+		return
+	}
+
+	wh.Logger.Debug().Uint64("sequence", head.Sequence).Str("hash", head.Hash).Msg("checkpointed audit records")
+}
+
+// checkpointLoop periodically checkpoints the head of the chain of audit records until the context is cancelled.
+func (wh *webhook) checkpointLoop(ctx context.Context) {
+	if wh.checkpoints == nil {
+		return
+	}
+
+	ticker := time.NewTicker(wh.checkpoints.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			wh.checkpointAudit(ctx)
+		}
+	}
+}
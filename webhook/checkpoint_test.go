@@ -0,0 +1,236 @@
+package webhook
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/audit"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/clients"
+)
+
+func Test_newCheckpointer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		interval     string
+		key          string
+		wantInterval time.Duration
+		wantKey      string
+		wantNil      bool
+		wantErr      bool
+	}{
+		{
+			name:         "ensure the default interval is used when unset",
+			wantInterval: DefaultAuditCheckpointInterval,
+			wantKey:      clients.Identity(),
+		},
+		{
+			name:         "ensure the key stored alongside the audit file is kept",
+			key:          "pod-a\n",
+			wantInterval: DefaultAuditCheckpointInterval,
+			wantKey:      "pod-a",
+		},
+		{
+			name:    "ensure an empty key returns an error",
+			key:     "\n",
+			wantErr: true,
+		},
+		{
+			name:         "ensure the interval may be set",
+			interval:     "5m",
+			wantInterval: 5 * time.Minute,
+			wantKey:      clients.Identity(),
+		},
+		{
+			name:     "ensure checkpoints may be disabled",
+			interval: "0",
+			wantNil:  true,
+		},
+		{
+			name:     "ensure an invalid interval returns an error",
+			interval: "often",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			if tt.key != "" {
+				if err := os.WriteFile(AuditCheckpointKeyPath(path), []byte(tt.key), 0o600); err != nil {
+					t.Fatalf("WriteFile() error = %v", err)
+				}
+			}
+
+			got, err := newCheckpointer(fake.NewSimpleClientset(), "", tt.interval, path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newCheckpointer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if (got == nil) != tt.wantNil {
+				t.Fatalf("newCheckpointer() = %v, wantNil %v", got, tt.wantNil)
+			}
+
+			if got == nil {
+				return
+			}
+
+			if got.interval != tt.wantInterval || got.name != DefaultAuditCheckpointName || got.key != tt.wantKey {
+				t.Errorf("newCheckpointer() = %+v, want interval %s, key %s and default name", got, tt.wantInterval, tt.wantKey)
+			}
+
+			// the key is kept alongside the audit file so that the chain is checkpointed under it after a restart
+			if key, err := ReadAuditCheckpointKey(path); err != nil || key != tt.wantKey {
+				t.Errorf("ReadAuditCheckpointKey() = %s, %v, want %s", key, err, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestCheckpointer_checkpoint(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// another replica has already checkpointed its own chain
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultAuditCheckpointName, Namespace: "test"},
+		Data:       map[string]string{"pod-b": `{"sequence":7,"hash":"other"}`},
+	})
+
+	c := &checkpointer{client: client, namespace: "test", name: DefaultAuditCheckpointName, key: "pod-a", interval: time.Minute}
+
+	// an empty chain is not checkpointed
+	if err := c.checkpoint(ctx, audit.Head{}); err != nil {
+		t.Fatalf("checkpoint() error = %v", err)
+	}
+
+	if _, err := ReadAuditCheckpoint(ctx, client, "test", DefaultAuditCheckpointName, "pod-a"); err == nil {
+		t.Fatalf("ReadAuditCheckpoint() error = nil, want no checkpoint for an empty chain")
+	}
+
+	head := audit.Head{Sequence: 3, Hash: strings.Repeat("a", 64), Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	if err := c.checkpoint(ctx, head); err != nil {
+		t.Fatalf("checkpoint() error = %v", err)
+	}
+
+	got, err := ReadAuditCheckpoint(ctx, client, "test", DefaultAuditCheckpointName, "pod-a")
+	if err != nil {
+		t.Fatalf("ReadAuditCheckpoint() error = %v", err)
+	}
+
+	if *got != head {
+		t.Errorf("ReadAuditCheckpoint() = %+v, want %+v", got, head)
+	}
+
+	other, err := ReadAuditCheckpoint(ctx, client, "test", DefaultAuditCheckpointName, "pod-b")
+	if err != nil || other.Sequence != 7 {
+		t.Errorf("ReadAuditCheckpoint() = %+v, %v, want the checkpoint of the other replica unchanged", other, err)
+	}
+
+	keys, err := ReadAuditCheckpointKeys(ctx, client, "test", DefaultAuditCheckpointName)
+	if err != nil || !slices.Equal(keys, []string{"pod-a", "pod-b"}) {
+		t.Errorf("ReadAuditCheckpointKeys() = %v, %v, want the key of every chain", keys, err)
+	}
+
+	// an unchanged head is not written again
+	client.ClearActions()
+
+	if err := c.checkpoint(ctx, head); err != nil {
+		t.Fatalf("checkpoint() error = %v", err)
+	}
+
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("checkpoint() made %d requests for an unchanged head, want none", len(actions))
+	}
+}
+
+func TestCheckpointer_create(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+
+	c := &checkpointer{client: client, namespace: "test", name: DefaultAuditCheckpointName, key: "pod-a", interval: time.Minute}
+
+	head := audit.Head{Sequence: 1, Hash: strings.Repeat("a", 64)}
+	if err := c.checkpoint(ctx, head); err != nil {
+		t.Fatalf("checkpoint() error = %v", err)
+	}
+
+	if got, err := ReadAuditCheckpoint(ctx, client, "test", DefaultAuditCheckpointName, "pod-a"); err != nil || got.Sequence != 1 {
+		t.Errorf("ReadAuditCheckpoint() = %+v, %v, want the config map to be created", got, err)
+	}
+}
+
+func TestCheckpointer_behind(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// the chain had reached sequence 5 before its file was deleted and the webhook restarted
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultAuditCheckpointName, Namespace: "test"},
+		Data:       map[string]string{"pod-a": `{"sequence":5,"hash":"` + strings.Repeat("a", 64) + `"}`},
+	})
+
+	c := &checkpointer{client: client, namespace: "test", name: DefaultAuditCheckpointName, key: "pod-a", interval: time.Minute}
+
+	// the same head is checkpointed again after a restart without any records being written
+	if err := c.checkpoint(ctx, audit.Head{Sequence: 5, Hash: strings.Repeat("a", 64)}); err != nil {
+		t.Fatalf("checkpoint() error = %v, want the same head to be allowed", err)
+	}
+
+	tests := []struct {
+		name  string
+		heads []audit.Head
+	}{
+		{
+			name:  "ensure a restarted chain is not checkpointed",
+			heads: []audit.Head{{Sequence: 1, Hash: strings.Repeat("b", 64)}},
+		},
+		{
+			name:  "ensure a different chain at the same sequence is not checkpointed",
+			heads: []audit.Head{{Sequence: 5, Hash: strings.Repeat("b", 64)}},
+		},
+		{
+			name: "ensure a restarted chain is not checkpointed once it passes the checkpoint",
+			heads: []audit.Head{
+				{Sequence: 1, Hash: strings.Repeat("b", 64)},
+				{Sequence: 6, Hash: strings.Repeat("c", 64)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		c := &checkpointer{client: client, namespace: "test", name: DefaultAuditCheckpointName, key: "pod-a", interval: time.Minute}
+
+		for _, head := range tt.heads {
+			if err := c.checkpoint(ctx, head); err == nil {
+				t.Errorf("%s: checkpoint() of sequence %d error = nil, want an error", tt.name, head.Sequence)
+			}
+		}
+
+		got, err := ReadAuditCheckpoint(ctx, client, "test", DefaultAuditCheckpointName, "pod-a")
+		if err != nil || got.Sequence != 5 || got.Hash != strings.Repeat("a", 64) {
+			t.Errorf("%s: ReadAuditCheckpoint() = %+v, %v, want the checkpoint kept", tt.name, got, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"encoding/json"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// admissionReviewKind is the kind of the objects sent to and returned from the webhook.
+const admissionReviewKind = "AdmissionReview"
+
+// decodeAdmissionReview decodes an AdmissionReview of any supported version into the internal v1 representation.
+// The apiVersion of the incoming review is returned so that the response may be sent in the same version.
+func decodeAdmissionReview(body []byte) (*admissionv1.AdmissionReview, string, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(body, &typeMeta); err != nil {
+		return nil, "", newAdmissionError(ErrorTypeDecode, "failed to unmarshal admission review type; %w", err)
+	}
+
+	switch typeMeta.APIVersion {
+	// the api server always sets the version, but default to v1 for clients who do not
+	case admissionv1.SchemeGroupVersion.String(), "":
+		review := &admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, review); err != nil {
+			return nil, "", newAdmissionError(ErrorTypeDecode, "failed to unmarshal admission review; %w", err)
+		}
+
+		return review, admissionv1.SchemeGroupVersion.String(), nil
+	case admissionv1beta1.SchemeGroupVersion.String():
+		review := &admissionv1beta1.AdmissionReview{}
+		if err := json.Unmarshal(body, review); err != nil {
+			return nil, "", newAdmissionError(ErrorTypeDecode, "failed to unmarshal v1beta1 admission review; %w", err)
+		}
+
+		return &admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: admissionv1.SchemeGroupVersion.String(),
+				Kind:       admissionReviewKind,
+			},
+			Request: convertRequestFromV1beta1(review.Request),
+		}, typeMeta.APIVersion, nil
+	default:
+		return nil, "", newAdmissionError(
+			ErrorTypeDecode,
+			"unsupported admission review version [%s]; only [%s, %s] supported",
+			typeMeta.APIVersion,
+			admissionv1.SchemeGroupVersion.String(),
+			admissionv1beta1.SchemeGroupVersion.String(),
+		)
+	}
+}
+
+// encodeAdmissionReview encodes an admission response into an AdmissionReview of the requested version.
+func encodeAdmissionReview(response *admissionv1.AdmissionResponse, apiVersion string) ([]byte, error) {
+	if apiVersion == admissionv1beta1.SchemeGroupVersion.String() {
+		return json.Marshal(&admissionv1beta1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: apiVersion,
+				Kind:       admissionReviewKind,
+			},
+			Response: convertResponseToV1beta1(response),
+		})
+	}
+
+	return json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionv1.SchemeGroupVersion.String(),
+			Kind:       admissionReviewKind,
+		},
+		Response: response,
+	})
+}
+
+// convertRequestFromV1beta1 converts a v1beta1 admission request to a v1 admission request.
+func convertRequestFromV1beta1(in *admissionv1beta1.AdmissionRequest) *admissionv1.AdmissionRequest {
+	if in == nil {
+		return nil
+	}
+
+	return &admissionv1.AdmissionRequest{
+		UID:                in.UID,
+		Kind:               in.Kind,
+		Resource:           in.Resource,
+		SubResource:        in.SubResource,
+		RequestKind:        in.RequestKind,
+		RequestResource:    in.RequestResource,
+		RequestSubResource: in.RequestSubResource,
+		Name:               in.Name,
+		Namespace:          in.Namespace,
+		Operation:          admissionv1.Operation(in.Operation),
+		UserInfo:           in.UserInfo,
+		Object:             in.Object,
+		OldObject:          in.OldObject,
+		DryRun:             in.DryRun,
+		Options:            in.Options,
+	}
+}
+
+// convertResponseToV1beta1 converts a v1 admission response to a v1beta1 admission response.
+func convertResponseToV1beta1(in *admissionv1.AdmissionResponse) *admissionv1beta1.AdmissionResponse {
+	if in == nil {
+		return nil
+	}
+
+	out := &admissionv1beta1.AdmissionResponse{
+		UID:              in.UID,
+		Allowed:          in.Allowed,
+		Result:           in.Result,
+		Patch:            in.Patch,
+		AuditAnnotations: in.AuditAnnotations,
+		Warnings:         in.Warnings,
+	}
+
+	if in.PatchType != nil {
+		patchType := admissionv1beta1.PatchType(*in.PatchType)
+		out.PatchType = &patchType
+	}
+
+	return out
+}
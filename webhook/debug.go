@@ -0,0 +1,234 @@
+package webhook
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/redact"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+const (
+	// EnvDebugRedact is the comma-separated list of redaction rules which are applied to the volumes and access
+	// credentials of objects logged at debug level.  See the redact package for the format of the rules.
+	EnvDebugRedact = "WEBHOOK_DEBUG_REDACT"
+
+	DefaultDebugRedact = "cloudinit,sysprep,secretrefs"
+)
+
+// debugAnnotations are the annotations whose values are logged at debug level since they affect detection or
+// admission.  Only the keys of any other annotations are logged, as they may contain anything, including the entire
+// object in the case of the last applied configuration.
+var debugAnnotations = []string{
+	"vm.kubevirt.io/os",
+	"kubevirt.io/cluster-preference-name",
+}
+
+// debugObject represents the fields of an object which are logged at debug level.
+type debugObject struct {
+	Kind         string          `json:"kind"`
+	Namespace    string          `json:"namespace"`
+	Name         string          `json:"name"`
+	GenerateName string          `json:"generateName,omitempty"`
+	Owners       []string        `json:"owners,omitempty"`
+	Metadata     []debugMetadata `json:"metadata,omitempty"`
+
+	CPU     debugCPU     `json:"cpu"`
+	Windows debugWindows `json:"windows"`
+
+	Volumes           []any `json:"volumes,omitempty"`
+	AccessCredentials []any `json:"accessCredentials,omitempty"`
+}
+
+// debugMetadata represents the metadata of an object, or of a template within the object.
+type debugMetadata struct {
+	Path             string            `json:"path"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	Annotations      map[string]string `json:"annotations,omitempty"`
+	OtherAnnotations []string          `json:"otherAnnotations,omitempty"`
+}
+
+// debugCPU represents the CPU topology of an object and the vCPUs that it is charged.
+type debugCPU struct {
+	Sockets   uint32 `json:"sockets"`
+	Cores     uint32 `json:"cores"`
+	Threads   uint32 `json:"threads"`
+	Model     string `json:"model,omitempty"`
+	Dedicated bool   `json:"dedicated"`
+	Requests  string `json:"requests,omitempty"`
+	Limits    string `json:"limits,omitempty"`
+	VCPUs     int    `json:"vcpus"`
+}
+
+// debugWindows represents the signals which are used to detect an object as a windows instance.
+type debugWindows struct {
+	Reasons         []string `json:"reasons"`
+	Sysprep         bool     `json:"sysprep"`
+	DriverDisk      bool     `json:"driverDisk"`
+	HyperV          bool     `json:"hyperV"`
+	PriorityClass   string   `json:"priorityClass,omitempty"`
+	NeedsValidation bool     `json:"needsValidation"`
+}
+
+// newDebugRedactor returns the redactor which is applied to objects logged at debug level, using the default rules if
+// they are unset.
+func newDebugRedactor(rules string) (*redact.Redactor, error) {
+	if strings.TrimSpace(rules) == "" {
+		rules = DefaultDebugRedact
+	}
+
+	return redact.NewRedactor(rules)
+}
+
+// newDebugObject returns the fields of the object of an operation which are logged at debug level.  Rather than the
+// entire object, only its identity, CPU topology and the signals used to detect windows instances are included, and
+// the volumes and access credentials are redacted, since they may include user data, sysprep contents and secrets.
+func newDebugObject(op *operation, instance *kubevirtv1.VirtualMachineInstance, redactor *redact.Redactor) *debugObject {
+	view := &debugObject{
+		Kind:         op.object.GetObjectKind().GroupVersionKind().Kind,
+		Namespace:    op.object.GetNamespace(),
+		Name:         op.object.GetName(),
+		GenerateName: instance.GenerateName,
+		Windows: debugWindows{
+			Reasons:         resources.WindowsReasons(instance),
+			HyperV:          instance.Spec.Domain.Features != nil && instance.Spec.Domain.Features.Hyperv != nil,
+			PriorityClass:   instance.Spec.PriorityClassName,
+			NeedsValidation: op.object.NeedsValidation().NeedsValidation,
+		},
+		CPU: debugCPU{
+			VCPUs: op.object.SumCPU(),
+		},
+	}
+
+	if view.Windows.Reasons == nil {
+		view.Windows.Reasons = []string{}
+	}
+
+	for _, owner := range instance.OwnerReferences {
+		view.Owners = append(view.Owners, owner.Kind+"/"+owner.Name)
+	}
+
+	for _, metadata := range op.object.Metadata() {
+		view.Metadata = append(view.Metadata, newDebugMetadata(metadata))
+	}
+
+	if cpu := instance.Spec.Domain.CPU; cpu != nil {
+		view.CPU.Sockets, view.CPU.Cores, view.CPU.Threads = cpu.Sockets, cpu.Cores, cpu.Threads
+		view.CPU.Model = cpu.Model
+		view.CPU.Dedicated = cpu.DedicatedCPUPlacement
+	}
+
+	if quantity, found := instance.Spec.Domain.Resources.Requests["cpu"]; found {
+		view.CPU.Requests = quantity.String()
+	}
+
+	if quantity, found := instance.Spec.Domain.Resources.Limits["cpu"]; found {
+		view.CPU.Limits = quantity.String()
+	}
+
+	for _, volume := range instance.Spec.Volumes {
+		view.Windows.Sysprep = view.Windows.Sysprep || volume.Sysprep != nil
+		view.Windows.DriverDisk = view.Windows.DriverDisk ||
+			(volume.DataVolume != nil && volume.DataVolume.Name == "windows-drivers-disk")
+	}
+
+	view.Volumes = redactedValues(instance.Spec.Volumes, redactor)
+	view.AccessCredentials = redactedValues(instance.Spec.AccessCredentials, redactor)
+
+	return view
+}
+
+// newDebugMetadata returns the metadata which is logged at debug level.  Labels are logged in full, while only the
+// annotations which affect detection or admission have their values logged.
+func newDebugMetadata(metadata resources.ObjectMetadata) debugMetadata {
+	result := debugMetadata{Path: metadata.Path, Labels: metadata.Labels}
+
+	for key, value := range metadata.Annotations {
+		if !isDebugAnnotation(key) {
+			result.OtherAnnotations = append(result.OtherAnnotations, key)
+
+			continue
+		}
+
+		if result.Annotations == nil {
+			result.Annotations = map[string]string{}
+		}
+
+		result.Annotations[key] = value
+	}
+
+	sort.Strings(result.OtherAnnotations)
+
+	return result
+}
+
+// isDebugAnnotation returns if the value of an annotation is logged at debug level.
+func isDebugAnnotation(key string) bool {
+	if strings.HasPrefix(key, "licensing/") {
+		return true
+	}
+
+	for _, annotation := range debugAnnotations {
+		if key == annotation {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactedValues returns each of a list of values as decoded JSON with the fields matching the rules of the redactor
+// redacted.  A value which cannot be encoded is replaced with the redacted placeholder rather than being logged.
+func redactedValues[T any](values []T, redactor *redact.Redactor) []any {
+	if len(values) == 0 {
+		return nil
+	}
+
+	result := make([]any, len(values))
+
+	for i := range values {
+		data, err := json.Marshal(values[i])
+		if err != nil {
+			result[i] = redact.Redacted
+
+			continue
+		}
+
+		var value any
+		if err := json.Unmarshal(data, &value); err != nil {
+			result[i] = redact.Redacted
+
+			continue
+		}
+
+		redactor.RedactValue(value)
+		result[i] = value
+	}
+
+	return result
+}
+
+// debugObject logs the fields of the object of an operation at debug level, if enabled.
+func (wh *webhook) debugObject(op *operation) {
+	event := wh.debug(op)
+	if !event.Enabled() {
+		return
+	}
+
+	instance, err := decodeInstance(op.request.admissionRequest.Kind.Kind, op.request.admissionRequest.Object.Raw)
+	if err != nil {
+		event.Err(err).Msg("failed to decode object for debugging")
+
+		return
+	}
+
+	redactor := wh.debugRedactor
+	if redactor == nil {
+		redactor, _ = newDebugRedactor("")
+	}
+
+	event.Interface("object", newDebugObject(op, instance, redactor)).Msg("decoded object")
+}
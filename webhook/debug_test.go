@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestWebhook_debugObject(t *testing.T) {
+	t.Parallel()
+
+	object := `{
+		"apiVersion": "kubevirt.io/v1",
+		"kind": "VirtualMachineInstance",
+		"metadata": {
+			"name": "vm-1",
+			"namespace": "team-a",
+			"labels": {"app": "vm-1"},
+			"annotations": {
+				"kubectl.kubernetes.io/last-applied-configuration": "{\"userData\":\"password: hunter2\"}",
+				"vm.kubevirt.io/os": "windows2k22"
+			}
+		},
+		"spec": {
+			"priorityClassName": "high",
+			"domain": {
+				"cpu": {"sockets": 2, "cores": 4, "threads": 1},
+				"devices": {}
+			},
+			"accessCredentials": [
+				{"userPassword": {"source": {"secret": {"secretName": "admin-password"}}}}
+			],
+			"volumes": [
+				{"name": "rootdisk", "dataVolume": {"name": "vm-1-rootdisk"}},
+				{"name": "cloudinitdisk", "cloudInitNoCloud": {"userData": "password: hunter2"}},
+				{"name": "sysprep", "sysprep": {"secret": {"name": "unattend-xml"}}}
+			]
+		}
+	}`
+
+	review := `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"test-uid",` +
+		`"kind":{"group":"kubevirt.io","version":"v1","kind":"VirtualMachineInstance"},"operation":"CREATE","object":` + object + `}}`
+
+	tests := []struct {
+		name        string
+		rules       string
+		want        []string
+		wantMissing []string
+	}{
+		{
+			name:  "ensure the default rules redact user data, sysprep contents and secret references",
+			rules: "",
+			want: []string{
+				`"name":"vm-1"`, `"namespace":"team-a"`, `"sockets":2`, `"cores":4`, `"vcpus":8`,
+				`"sysprep":true`, `"has sysprep volume"`, `"vm.kubevirt.io/os":"windows2k22"`,
+				`"otherAnnotations":["kubectl.kubernetes.io/last-applied-configuration"]`,
+				`"app":"vm-1"`, `"priorityClass":"high"`, `"vm-1-rootdisk"`,
+			},
+			wantMissing: []string{"hunter2", "unattend-xml", "admin-password"},
+		},
+		{
+			name:        "ensure redaction may be limited to user data",
+			rules:       "cloudinit",
+			want:        []string{"unattend-xml", "admin-password"},
+			wantMissing: []string{"hunter2"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			redactor, err := newDebugRedactor(tt.rules)
+			if err != nil {
+				t.Fatalf("newDebugRedactor() error = %v", err)
+			}
+
+			output := &bytes.Buffer{}
+			wh := &webhook{Logger: zerolog.New(output).Level(zerolog.DebugLevel), debugRedactor: redactor}
+
+			op, err := NewOperation(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(review)))
+			if err != nil {
+				t.Fatalf("NewOperation() error = %v", err)
+			}
+
+			wh.debugObject(op)
+
+			if !json.Valid(output.Bytes()) {
+				t.Fatalf("debugObject() logged invalid JSON: %s", output)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(output.String(), want) {
+					t.Errorf("debugObject() = %s, want %s", output, want)
+				}
+			}
+
+			for _, missing := range tt.wantMissing {
+				if strings.Contains(output.String(), missing) {
+					t.Errorf("debugObject() = %s, want %s redacted", output, missing)
+				}
+			}
+		})
+	}
+
+	t.Run("ensure nothing is decoded unless debug logging is enabled", func(t *testing.T) {
+		t.Parallel()
+
+		output := &bytes.Buffer{}
+		wh := &webhook{Logger: zerolog.New(output).Level(zerolog.InfoLevel)}
+
+		op, err := NewOperation(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(review)))
+		if err != nil {
+			t.Fatalf("NewOperation() error = %v", err)
+		}
+
+		wh.debugObject(op)
+
+		if output.Len() != 0 {
+			t.Errorf("debugObject() = %s, want nothing logged", output)
+		}
+	})
+}
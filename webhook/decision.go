@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/pkg/client"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+// decisionTimeout is the maximum amount of time that deciding an admission request may take.  It is kept below the
+// timeout of the webhook configuration so that a slow API server fails the request under the failure policy, rather
+// than the API server giving up on the webhook.
+const decisionTimeout = 8 * time.Second
+
+// Decision represents the decision of whether a windows instance fits within the available capacity.  It is shared
+// with the client of the check API.
+type Decision = client.Decision
+
+// decide decides whether a windows instance fits within the available capacity.  Denials are explained with the
+// usage in the visible namespaces, and the top consumers of capacity are returned for logging.  Nothing is reserved by
+// deciding.
+func (wh *webhook) decide(
+	ctx context.Context,
+	object resources.WindowsInstanceValidator,
+	instance *kubevirtv1.VirtualMachineInstance,
+	visible namespaceVisibility,
+) (*Decision, []consumer, error) {
+	// get the virtual machine instance list from the cluster and the current used capacity
+	vmInstanceList, err := wh.getFilteredVirtualMachineInstances(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// // return if we found an instance in the cluster matching this name
+	// // TODO: this likely needs to be handled differently for an UPDATE request
+	// for i := 0; i < len(vmInstanceList); i++ {
+	// 	if vmInstanceList[i].GetName() == op.object.GetName() && vmInstanceList[i].GetNamespace() == op.object.GetNamespace() {
+	// 		wh.respond(op, "skipping validation", true)
+	// 		return
+	// 	}
+	// }
+
+	// get the node list from the cluster and the total capacity
+	nodeList, err := wh.getFilteredNodes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// only high priority instances may use the capacity which is reserved for them
+	classes, err := wh.priorityClasses(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	decision := &Decision{
+		Allowed:   true,
+		Code:      http.StatusOK,
+		Message:   "request success",
+		Requested: object.SumCPU(),
+		Total:     nodeList.SumCPU(),
+		Used:      vmInstanceList.SumCPU(),
+		Priority:  priorityOf(instance, classes),
+	}
+
+	highPriority := wh.Priority.isHighPriority(decision.Priority)
+	if !highPriority {
+		decision.Reserved = wh.Priority.reserved(decision.Total)
+	}
+
+	decision.Available = decision.Total - decision.Used - decision.Reserved
+
+	// ensure the requested capacity would not exceed the available capacity
+	if decision.Requested <= decision.Available {
+		return decision, nil, nil
+	}
+
+	msg := fmt.Sprintf("requested capacity: [%d], exceeds available capacity: [%d]; currently used [%d]",
+		decision.Requested,
+		decision.Available,
+		decision.Used,
+	)
+
+	if decision.Reserved > 0 {
+		msg += fmt.Sprintf("; [%d] reserved for priority [%d] and above", decision.Reserved, wh.Priority.highPriority)
+	}
+
+	// let high priority requests know which lower priority instances are in their way
+	if highPriority {
+		candidates := preemptionCandidates(vmInstanceList, classes, decision.Priority, decision.Requested-decision.Available)
+		msg = preemptionMessage(msg, visible, candidates)
+	}
+
+	// let the requester know who is using the capacity
+	msg, topConsumers := wh.explain(visible, vmInstanceList, msg)
+
+	decision.Allowed = false
+	decision.Code = http.StatusForbidden
+	decision.Message = msg
+
+	return decision, topConsumers, nil
+}
+
+// decodeInstance decodes a virtual machine instance, or the instance templated by a virtual machine, from an object.
+func decodeInstance(kind string, raw []byte) (*kubevirtv1.VirtualMachineInstance, error) {
+	if kind != resources.VirtualMachineType {
+		instance := &kubevirtv1.VirtualMachineInstance{}
+		if err := json.Unmarshal(raw, instance); err != nil {
+			return nil, newAdmissionError(ErrorTypeDecode, "failed to decode virtual machine instance object; %w", err)
+		}
+
+		return instance, nil
+	}
+
+	vm := &kubevirtv1.VirtualMachine{}
+	if err := json.Unmarshal(raw, vm); err != nil {
+		return nil, newAdmissionError(ErrorTypeDecode, "failed to decode virtual machine object; %w", err)
+	}
+
+	instance := &kubevirtv1.VirtualMachineInstance{}
+	instance.Name, instance.Namespace = vm.Name, vm.Namespace
+
+	if vm.Spec.Template != nil {
+		instance.Annotations = vm.Spec.Template.ObjectMeta.Annotations
+		instance.Spec = vm.Spec.Template.Spec
+	}
+
+	return instance, nil
+}
+
+// preemptionMessage returns a denial message with the lower priority instances whose shutdown would make room for the
+// request appended.  Only the candidates in the visible namespaces are listed by name, while the others are only
+// counted, in the same way as the consumers of the capacity are explained.
+func preemptionMessage(msg string, visible namespaceVisibility, candidates []preemptionCandidate) string {
+	if len(candidates) == 0 {
+		return msg + "; shutting down lower priority instances would not make room"
+	}
+
+	names, hidden := []string{}, 0
+
+	for i := range candidates {
+		if !visible(candidates[i].namespace) {
+			hidden++
+
+			continue
+		}
+
+		names = append(names, candidates[i].String())
+	}
+
+	if len(names) == 0 {
+		return msg + fmt.Sprintf("; shutting down [%d] lower priority instances in other namespaces would make room", hidden)
+	}
+
+	suffix := ""
+	if hidden > 0 {
+		suffix = fmt.Sprintf(" and [%d] instances in other namespaces", hidden)
+	}
+
+	return truncatedList(msg+"; shutting down lower priority instances would make room: ", names, maxMessageBytes-len(suffix)) + suffix
+}
@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrorType represents the category of an error which occurs while handling an admission request.  Each error type
+// is mapped to an action by the FailurePolicy.
+type ErrorType string
+
+const (
+	// ErrorTypeDecode represents a request which could not be read or decoded.
+	ErrorTypeDecode ErrorType = "Decode"
+
+	// ErrorTypeUnsupportedKind represents a request for a kind of object that the webhook does not handle.
+	ErrorTypeUnsupportedKind ErrorType = "UnsupportedKind"
+
+	// ErrorTypeUnsupportedOperation represents a request for an operation that the webhook does not handle.
+	ErrorTypeUnsupportedOperation ErrorType = "UnsupportedOperation"
+
+	// ErrorTypeAPI represents a failure communicating with the kubernetes API.
+	ErrorTypeAPI ErrorType = "API"
+
+	// ErrorTypeInternal represents an unexpected failure within the webhook itself, such as a panic.
+	ErrorTypeInternal ErrorType = "Internal"
+)
+
+// ErrorTypes returns all error types.
+func ErrorTypes() []ErrorType {
+	return []ErrorType{
+		ErrorTypeDecode,
+		ErrorTypeUnsupportedKind,
+		ErrorTypeUnsupportedOperation,
+		ErrorTypeAPI,
+		ErrorTypeInternal,
+	}
+}
+
+// code returns the status code that is returned in the admission response when a request is denied for an error
+// of this type.
+func (errorType ErrorType) code() int32 {
+	switch errorType {
+	case ErrorTypeDecode:
+		return http.StatusBadRequest
+	case ErrorTypeUnsupportedKind, ErrorTypeUnsupportedOperation:
+		return http.StatusUnprocessableEntity
+	case ErrorTypeAPI:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// admissionError represents an error which occurred while handling an admission request.
+type admissionError struct {
+	errorType ErrorType
+	err       error
+}
+
+// newAdmissionError returns a new admission error of a given type.
+func newAdmissionError(errorType ErrorType, format string, a ...any) *admissionError {
+	return &admissionError{
+		errorType: errorType,
+		err:       fmt.Errorf(format, a...),
+	}
+}
+
+// Error returns the error message.  It is used to satisfy the error interface.
+func (e *admissionError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *admissionError) Unwrap() error {
+	return e.err
+}
+
+// errorTypeOf returns the error type of an error.  Errors which were not created as an admission error are
+// considered internal errors.
+func errorTypeOf(err error) ErrorType {
+	var admissionErr *admissionError
+	if errors.As(err, &admissionErr) {
+		return admissionErr.errorType
+	}
+
+	return ErrorTypeInternal
+}
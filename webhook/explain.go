@@ -0,0 +1,198 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+const (
+	// EnvExplainTop is the number of top consumers which are listed when explaining a denial.
+	EnvExplainTop = "WEBHOOK_EXPLAIN_TOP"
+
+	// EnvExplainByInstance lists the top consuming instances, rather than namespaces, when explaining a denial.
+	EnvExplainByInstance = "WEBHOOK_EXPLAIN_BY_INSTANCE"
+
+	DefaultExplainTop = 5
+
+	// maxMessageBytes is the maximum size of a response message.  The message is stored in events and audit logs by
+	// the API server, so it is kept well below any of their limits.
+	maxMessageBytes = 1024
+
+	// maxVisibilityChecks is the maximum number of namespaces whose visibility is checked for a single request so
+	// that large clusters do not cause a request for every namespace.
+	maxVisibilityChecks = 20
+)
+
+// explainer explains a denial by listing the top consumers of the licensed capacity which the requester may see.
+type explainer struct {
+	top        int
+	byInstance bool
+}
+
+// newExplainer returns a new instance of an explainer given the number of top consumers and whether to list
+// instances rather than namespaces, using the defaults if they are unset.
+func newExplainer(top, byInstance string) (*explainer, error) {
+	e := &explainer{top: DefaultExplainTop, byInstance: byInstance == "true"}
+
+	if top != "" {
+		value, err := strconv.Atoi(top)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid value [%s] for [%s]; must be a non-negative number", top, EnvExplainTop)
+		}
+
+		e.top = value
+	}
+
+	return e, nil
+}
+
+// consumer represents the capacity used by a namespace or an instance.
+type consumer struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name,omitempty"`
+	VCPUs     int    `json:"vcpus"`
+}
+
+// String returns the consumer as it is displayed in a message.
+func (c consumer) String() string {
+	if c.Name == "" {
+		return fmt.Sprintf("%s: %d vCPUs", c.Namespace, c.VCPUs)
+	}
+
+	return fmt.Sprintf("%s/%s: %d vCPUs", c.Namespace, c.Name, c.VCPUs)
+}
+
+// consumers returns the capacity used by each namespace, or each instance, sorted with the largest first.
+func consumers(instances resources.VirtualMachineInstances, byInstance bool) []consumer {
+	byKey := map[string]*consumer{}
+	result := []*consumer{}
+
+	for i := range instances {
+		key, name := instances[i].Namespace, ""
+		if byInstance {
+			key, name = instances[i].Namespace+"/"+instances[i].Name, instances[i].Name
+		}
+
+		if _, found := byKey[key]; !found {
+			byKey[key] = &consumer{Namespace: instances[i].Namespace, Name: name}
+			result = append(result, byKey[key])
+		}
+
+		byKey[key].VCPUs += resources.VirtualMachineInstances{instances[i]}.SumCPU()
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].VCPUs != result[j].VCPUs {
+			return result[i].VCPUs > result[j].VCPUs
+		}
+
+		return result[i].String() < result[j].String()
+	})
+
+	sorted := make([]consumer, len(result))
+	for i := range result {
+		sorted[i] = *result[i]
+	}
+
+	return sorted
+}
+
+// namespaceVisibility returns if the consumers in a namespace may be listed when explaining a denial.
+type namespaceVisibility func(namespace string) bool
+
+// objectNamespace returns the visibility of a denial which is reported on an object, where only the consumers in the
+// namespace of the object may be listed.  The denial of an admission request ends up in the status and events of the
+// object, or of the virtual machine which created it, so it is seen by anyone who may see that namespace rather than
+// only by the requester, which is often a KubeVirt service account that may see every namespace.
+func objectNamespace(namespace string) namespaceVisibility {
+	return func(candidate string) bool {
+		return candidate == namespace
+	}
+}
+
+// accessibleNamespaces returns the visibility of a denial which is only returned to a user, where the consumers in the
+// namespaces in which the user may get virtual machine instances may be listed.  Namespaces are checked as they are
+// needed and are assumed to be hidden once the maximum number of namespaces have been checked.
+func (wh *webhook) accessibleNamespaces(ctx context.Context, user authenticationv1.UserInfo) namespaceVisibility {
+	visible := map[string]bool{}
+
+	return func(namespace string) bool {
+		if _, checked := visible[namespace]; !checked {
+			visible[namespace] = len(visible) < maxVisibilityChecks && wh.canAccessInstances(ctx, user, "get", namespace)
+		}
+
+		return visible[namespace]
+	}
+}
+
+// explain returns the message with the top consumers which may be seen appended.  Consumers in namespaces which may
+// not be seen are only counted in aggregate.  The message is truncated to fit within the maximum message size.  All top
+// consumers are also returned, regardless of what may be seen, so that they may be logged.
+func (wh *webhook) explain(
+	visible namespaceVisibility,
+	instances resources.VirtualMachineInstances,
+	msg string,
+) (string, []consumer) {
+	if wh.Explainer == nil || wh.Explainer.top == 0 || len(instances) == 0 {
+		return msg, nil
+	}
+
+	all := consumers(instances, wh.Explainer.byInstance)
+	listed, hidden := visibleConsumers(visible, all, wh.Explainer.top)
+
+	entries := make([]string, len(listed))
+	for i := range listed {
+		entries[i] = listed[i].String()
+	}
+
+	if hidden > 0 {
+		entries = append(entries, fmt.Sprintf("others: %d vCPUs", hidden))
+	}
+
+	return truncatedList(msg+"; top consumers: ", entries, maxMessageBytes), all[:min(len(all), wh.Explainer.top)]
+}
+
+// visibleConsumers returns up to the top number of consumers which may be seen, along with the capacity used by every
+// other consumer.  The consumers must already be sorted with the largest first.
+func visibleConsumers(visible namespaceVisibility, all []consumer, top int) (listed []consumer, hidden int) {
+	listed = []consumer{}
+
+	for _, c := range all {
+		if !visible(c.Namespace) || len(listed) == top {
+			hidden += c.VCPUs
+
+			continue
+		}
+
+		listed = append(listed, c)
+	}
+
+	return listed, hidden
+}
+
+// truncatedList returns a prefix followed by a bracketed, comma-separated list of entries which fits within a maximum
+// number of bytes.  Entries which do not fit are replaced with a count of how many were omitted, so that the list is
+// always well-formed.
+func truncatedList(prefix string, entries []string, maxBytes int) string {
+	for count := len(entries); count >= 0; count-- {
+		included := entries[:count]
+		if count < len(entries) {
+			included = append(included[:count:count], fmt.Sprintf("%d more", len(entries)-count))
+		}
+
+		message := prefix + "[" + strings.Join(included, ", ") + "]"
+		if len(message) <= maxBytes {
+			return message
+		}
+	}
+
+	// the prefix alone is too long, so it must be truncated
+	return prefix[:max(0, maxBytes-3)] + "..."
+}
@@ -0,0 +1,164 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/snapshot"
+)
+
+func Test_consumers(t *testing.T) {
+	t.Parallel()
+
+	instance := func(namespace, name string, cores uint32) kubevirtv1.VirtualMachineInstance {
+		return kubevirtv1.VirtualMachineInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: kubevirtv1.VirtualMachineInstanceSpec{
+				Domain: kubevirtv1.DomainSpec{CPU: &kubevirtv1.CPU{Sockets: 1, Cores: cores, Threads: 1}},
+			},
+		}
+	}
+
+	instances := resources.VirtualMachineInstances{
+		instance("team-a", "vm-1", 2),
+		instance("team-b", "vm-1", 8),
+		instance("team-a", "vm-2", 4),
+		instance("team-c", "vm-1", 6),
+	}
+
+	tests := []struct {
+		name       string
+		byInstance bool
+		want       []string
+	}{
+		{
+			name: "ensure usage is summed by namespace, largest first",
+			want: []string{"team-b: 8 vCPUs", "team-a: 6 vCPUs", "team-c: 6 vCPUs"},
+		},
+		{
+			name:       "ensure usage is listed by instance, largest first",
+			byInstance: true,
+			want:       []string{"team-b/vm-1: 8 vCPUs", "team-c/vm-1: 6 vCPUs", "team-a/vm-2: 4 vCPUs", "team-a/vm-1: 2 vCPUs"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := []string{}
+			for _, c := range consumers(instances, tt.byInstance) {
+				got = append(got, c.String())
+			}
+
+			if strings.Join(got, ";") != strings.Join(tt.want, ";") {
+				t.Errorf("consumers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_truncatedList(t *testing.T) {
+	t.Parallel()
+
+	entries := []string{"team-a: 8 vCPUs", "team-b: 6 vCPUs", "team-c: 4 vCPUs"}
+
+	tests := []struct {
+		name     string
+		maxBytes int
+		want     string
+	}{
+		{
+			name:     "ensure all entries are listed when they fit",
+			maxBytes: 1024,
+			want:     "denied; [team-a: 8 vCPUs, team-b: 6 vCPUs, team-c: 4 vCPUs]",
+		},
+		{
+			name:     "ensure entries which do not fit are counted",
+			maxBytes: 45,
+			want:     "denied; [team-a: 8 vCPUs, 2 more]",
+		},
+		{
+			name:     "ensure a prefix which does not fit is truncated",
+			maxBytes: 6,
+			want:     "den...",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := truncatedList("denied; ", entries, tt.maxBytes)
+			if got != tt.want {
+				t.Errorf("truncatedList() = %q, want %q", got, tt.want)
+			}
+
+			if len(got) > tt.maxBytes {
+				t.Errorf("truncatedList() length = %d, want at most %d", len(got), tt.maxBytes)
+			}
+		})
+	}
+}
+
+func TestWebhook_Validate_explain(t *testing.T) {
+	t.Parallel()
+
+	// the clients of a snapshot allow every access review, as they would for a kubevirt service account
+	s := &snapshot.Snapshot{
+		Nodes: []corev1.Node{*testNode("node-1", "windows", 8)},
+		VirtualMachineInstances: []kubevirtv1.VirtualMachineInstance{
+			*testWindowsInstance("team-a", "vm-1", "node-1", 2),
+			*testWindowsInstance("team-b", "vm-1", "node-1", 4),
+		},
+	}
+
+	wh, err := NewWebhookForClients(s.Clients())
+	if err != nil {
+		t.Fatalf("NewWebhookForClients() error = %v", err)
+	}
+
+	for _, username := range []string{"alice", "system:serviceaccount:openshift-cnv:kubevirt-controller"} {
+		object, _ := json.Marshal(testWindowsInstance("team-a", "vm-2", "", 4))
+
+		review, _ := json.Marshal(admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request: &admissionv1.AdmissionRequest{
+				UID:       k8stypes.UID("uid-" + username),
+				Kind:      metav1.GroupVersionKind{Group: "kubevirt.io", Version: "v1", Kind: resources.VirtualMachineInstanceType},
+				Namespace: "team-a",
+				Operation: admissionv1.Create,
+				UserInfo:  authenticationv1.UserInfo{Username: username},
+				Object:    runtime.RawExtension{Raw: object},
+			},
+		})
+
+		body, err := wh.Evaluate(context.Background(), review)
+		if err != nil {
+			t.Fatalf("Evaluate() error = %v", err)
+		}
+
+		response := &admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, response); err != nil {
+			t.Fatalf("Evaluate() returned an invalid review; %v", err)
+		}
+
+		message := response.Response.Result.Message
+		if response.Response.Allowed || !strings.HasSuffix(message, "; top consumers: [team-a: 2 vCPUs, others: 4 vCPUs]") {
+			t.Errorf("Evaluate() by %s = %s, want only the namespace of the object listed", username, message)
+		}
+	}
+}
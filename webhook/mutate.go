@@ -0,0 +1,132 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+// EnvCountByLabel enables counting used capacity by the windows label stamped by the mutating webhook rather than by
+// re-running windows detection on each virtual machine instance.
+const EnvCountByLabel = "WEBHOOK_COUNT_BY_LABEL"
+
+// Mutate runs the mutation logic for the webhook.  Objects which are detected as windows instances are stamped
+// with a stable label, and annotations which record why they were detected and how many vCPUs they are charged.
+// Objects which are no longer detected as windows instances have the label and annotations removed.
+func (wh *webhook) Mutate(w http.ResponseWriter, r *http.Request) {
+	op, err := newOperation(w, r, mutateScope)
+	if err != nil {
+		wh.fail(op, err)
+		return
+	}
+	wh.log(op).Msg("received mutation request")
+
+	ctx, cancel := context.WithTimeout(r.Context(), decisionTimeout)
+	defer cancel()
+
+	// queue windows virtual machines which would exceed the licensed capacity rather than allowing them to start
+	changes, queuePatches, err := wh.queuePatches(ctx, op)
+	if err != nil {
+		wh.fail(op, err)
+		return
+	}
+
+	patches := append(windowsMetadataPatches(op.object, changes), queuePatches...)
+
+	// pin windows instances to the licensed nodes, denying those which explicitly ask to be elsewhere
+	if wh.placement != nil && pinnable(op.request.admissionRequest) && op.object.NeedsValidation().NeedsValidation {
+		for _, scheduling := range op.object.Scheduling() {
+			placementPatches, err := wh.placement.patches(scheduling)
+			if err != nil {
+				op.response.allowed = false
+				wh.log(op).Msgf("returning with message: [%s]", err)
+				op.response.send(http.StatusForbidden, fmt.Sprintf("windows instance must run on licensed nodes; %v", err))
+
+				return
+			}
+
+			patches = append(patches, placementPatches...)
+		}
+	}
+
+	if len(patches) == 0 {
+		op.response.send(http.StatusOK, "no mutation required")
+		return
+	}
+
+	patch, err := json.Marshal(patches)
+	if err != nil {
+		wh.fail(op, newAdmissionError(ErrorTypeInternal, "failed to marshal patch; %w", err))
+		return
+	}
+
+	wh.debug(op).RawJSON("patch", patch).Msg("mutating object")
+
+	op.response.patch = patch
+	op.response.send(http.StatusOK, fmt.Sprintf("applied [%d] patches", len(patches)))
+}
+
+// metadataChanges represents additional labels and annotations to set and remove on the top-level metadata of an
+// object.  They must be patched alongside the windows metadata as a map which does not yet exist is added as a whole.
+type metadataChanges struct {
+	setLabels         map[string]string
+	setAnnotations    map[string]string
+	removeLabels      []string
+	removeAnnotations []string
+}
+
+// windowsMetadataPatches returns the patches needed for the metadata of an object to reflect whether it was
+// detected as a windows instance, along with any additional changes to the top-level metadata.
+func windowsMetadataPatches(object resources.WindowsInstanceValidator, changes *metadataChanges) []patchOperation {
+	result := object.NeedsValidation()
+
+	setLabels, setAnnotations := map[string]string{}, map[string]string{}
+	removeLabels, removeAnnotations := []string{}, []string{}
+
+	if result.NeedsValidation {
+		setLabels[resources.WindowsLabelKey] = resources.WindowsLabelValue
+		setAnnotations[resources.WindowsReasonAnnotation] = result.Reason
+		setAnnotations[resources.WindowsVCPUsAnnotation] = strconv.Itoa(object.SumCPU())
+	} else {
+		removeLabels = append(removeLabels, resources.WindowsLabelKey)
+		removeAnnotations = append(removeAnnotations, resources.WindowsReasonAnnotation, resources.WindowsVCPUsAnnotation)
+	}
+
+	patches := []patchOperation{}
+
+	for _, metadata := range object.Metadata() {
+		labels, annotations := setLabels, setAnnotations
+		labelsRemoved, annotationsRemoved := removeLabels, removeAnnotations
+
+		if metadata.Path == "/metadata" && changes != nil {
+			labels, annotations = merged(setLabels, changes.setLabels), merged(setAnnotations, changes.setAnnotations)
+			labelsRemoved = append(slices.Clone(removeLabels), changes.removeLabels...)
+			annotationsRemoved = append(slices.Clone(removeAnnotations), changes.removeAnnotations...)
+		}
+
+		patches = append(patches, mapPatch(metadata.Path+"/labels", metadata.Labels, labels, labelsRemoved)...)
+		patches = append(patches, mapPatch(metadata.Path+"/annotations", metadata.Annotations, annotations, annotationsRemoved)...)
+	}
+
+	return patches
+}
+
+// merged returns a new map with the keys of both maps, preferring the values of the second.
+func merged(first, second map[string]string) map[string]string {
+	result := make(map[string]string, len(first)+len(second))
+
+	for key, value := range first {
+		result[key] = value
+	}
+
+	for key, value := range second {
+		result[key] = value
+	}
+
+	return result
+}
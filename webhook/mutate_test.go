@@ -0,0 +1,194 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+func Test_windowsMetadataPatches(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		kind   string
+		object string
+		want   []patchOperation
+	}{
+		{
+			name:   "ensure a windows instance without metadata is labeled and annotated",
+			kind:   resources.VirtualMachineInstanceType,
+			object: `{"spec":{"domain":{"cpu":{"cores":2},"features":{"hyperv":{}}}}}`,
+			want: []patchOperation{
+				{Op: "add", Path: "/metadata/labels", Value: map[string]any{resources.WindowsLabelKey: "true"}},
+				{Op: "add", Path: "/metadata/annotations", Value: map[string]any{
+					resources.WindowsReasonAnnotation: "has hyper-v features",
+					resources.WindowsVCPUsAnnotation:  "2",
+				}},
+			},
+		},
+		{
+			name:   "ensure a windows instance with existing metadata has keys added",
+			kind:   resources.VirtualMachineInstanceType,
+			object: `{"metadata":{"labels":{"app":"test"},"annotations":{"licensing/windows-vcpus":"1"}},"spec":{"domain":{"features":{"hyperv":{}}}}}`,
+			want: []patchOperation{
+				{Op: "add", Path: "/metadata/labels/licensing~1windows", Value: "true"},
+				{Op: "add", Path: "/metadata/annotations/licensing~1windows-reason", Value: "has hyper-v features"},
+			},
+		},
+		{
+			name:   "ensure a windows virtual machine template is labeled and annotated",
+			kind:   resources.VirtualMachineType,
+			object: `{"metadata":{"labels":{"licensing/windows":"true"},"annotations":{"licensing/windows-reason":"has hyper-v features","licensing/windows-vcpus":"1"}},"spec":{"template":{"spec":{"domain":{"features":{"hyperv":{}}}}}}}`,
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/template/metadata/labels", Value: map[string]any{resources.WindowsLabelKey: "true"}},
+				{Op: "add", Path: "/spec/template/metadata/annotations", Value: map[string]any{
+					resources.WindowsReasonAnnotation: "has hyper-v features",
+					resources.WindowsVCPUsAnnotation:  "1",
+				}},
+			},
+		},
+		{
+			name:   "ensure a labeled instance which is no longer windows has the metadata removed",
+			kind:   resources.VirtualMachineInstanceType,
+			object: `{"metadata":{"labels":{"licensing/windows":"true"},"annotations":{"licensing/windows-reason":"has hyper-v features"}}}`,
+			want: []patchOperation{
+				{Op: "remove", Path: "/metadata/labels/licensing~1windows"},
+				{Op: "remove", Path: "/metadata/annotations/licensing~1windows-reason"},
+			},
+		},
+		{
+			name:   "ensure a linux instance without metadata is not mutated",
+			kind:   resources.VirtualMachineInstanceType,
+			object: `{"metadata":{"name":"linux"}}`,
+			want:   []patchOperation{},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var validator resources.WindowsInstanceValidator = resources.NewVirtualMachineInstance()
+			if tt.kind == resources.VirtualMachineType {
+				validator = resources.NewVirtualMachine()
+			}
+
+			object, err := validator.Extract(&admissionv1.AdmissionRequest{Object: runtime.RawExtension{Raw: []byte(tt.object)}})
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+
+			// compare the marshaled patches as the values are generic
+			got, _ := json.Marshal(windowsMetadataPatches(object, nil))
+			want, _ := json.Marshal(tt.want)
+
+			if string(got) != string(want) {
+				t.Errorf("windowsMetadataPatches() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestWebhook_Mutate_placement(t *testing.T) {
+	t.Parallel()
+
+	const (
+		pinned      = `{"spec":{"volumes":[{"name":"sysprep","sysprep":{}}]}}`
+		conflicting = `{"spec":{"nodeSelector":{"image_type":"linux"},"volumes":[{"name":"sysprep","sysprep":{}}]}}`
+		template    = `{"spec":{"template":{"spec":{"volumes":[{"name":"sysprep","sysprep":{}}]}}}}`
+	)
+
+	tests := []struct {
+		name        string
+		kind        string
+		operation   admissionv1.Operation
+		object      string
+		wantAllowed bool
+		wantPinned  bool
+	}{
+		{
+			name:        "ensure a new windows instance is pinned",
+			kind:        resources.VirtualMachineInstanceType,
+			operation:   admissionv1.Create,
+			object:      pinned,
+			wantAllowed: true,
+			wantPinned:  true,
+		},
+		{
+			name:        "ensure a new windows instance asking for an unlicensed node is denied",
+			kind:        resources.VirtualMachineInstanceType,
+			operation:   admissionv1.Create,
+			object:      conflicting,
+			wantAllowed: false,
+		},
+		{
+			name:        "ensure an updated windows instance is not pinned",
+			kind:        resources.VirtualMachineInstanceType,
+			operation:   admissionv1.Update,
+			object:      pinned,
+			wantAllowed: true,
+		},
+		{
+			name:        "ensure an updated windows instance on an unlicensed node is not denied",
+			kind:        resources.VirtualMachineInstanceType,
+			operation:   admissionv1.Update,
+			object:      conflicting,
+			wantAllowed: true,
+		},
+		{
+			name:        "ensure an updated windows virtual machine template is pinned",
+			kind:        resources.VirtualMachineType,
+			operation:   admissionv1.Update,
+			object:      template,
+			wantAllowed: true,
+			wantPinned:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := newPlacement(resources.NewNodeFilter("image_type", "windows"), "")
+			if err != nil {
+				t.Fatalf("newPlacement() error = %v", err)
+			}
+
+			wh := &webhook{Logger: zerolog.Nop(), placement: p}
+
+			review := fmt.Sprintf(
+				`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"test-uid",`+
+					`"kind":{"group":"kubevirt.io","version":"v1","kind":%q},"operation":%q,"object":%s}}`,
+				tt.kind, tt.operation, tt.object,
+			)
+
+			recorder := httptest.NewRecorder()
+			wh.Mutate(recorder, httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader(review)))
+
+			got := &admissionv1.AdmissionReview{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), got); err != nil {
+				t.Fatalf("Mutate() returned invalid review %s; %v", recorder.Body, err)
+			}
+
+			if got.Response.Allowed != tt.wantAllowed {
+				t.Errorf("Mutate() allowed = %v, want %v; %s", got.Response.Allowed, tt.wantAllowed, got.Response.Result.Message)
+			}
+
+			if gotPinned := strings.Contains(string(got.Response.Patch), "nodeSelector"); gotPinned != tt.wantPinned {
+				t.Errorf("Mutate() patch = %s, want pinned %v", got.Response.Patch, tt.wantPinned)
+			}
+		})
+	}
+}
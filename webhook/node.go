@@ -0,0 +1,171 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+// NodeValidationMode represents the action taken when a node update or deletion would reduce the licensed capacity
+// below the capacity used by windows instances.
+type NodeValidationMode string
+
+const (
+	NodeValidationModeDeny NodeValidationMode = "Deny"
+	NodeValidationModeWarn NodeValidationMode = "Warn"
+
+	// EnvNodeValidationMode sets the node validation mode.
+	EnvNodeValidationMode = "WEBHOOK_NODE_VALIDATION_MODE"
+
+	DefaultNodeValidationMode = NodeValidationModeDeny
+)
+
+// newNodeValidationMode returns the node validation mode given its value, using the default if it is unset.
+func newNodeValidationMode(value string) (NodeValidationMode, error) {
+	switch mode := NodeValidationMode(value); mode {
+	case "":
+		return DefaultNodeValidationMode, nil
+	case NodeValidationModeDeny, NodeValidationModeWarn:
+		return mode, nil
+	default:
+		return "", fmt.Errorf(
+			"invalid value [%s] for [%s]; must be one of [%s, %s]",
+			value,
+			EnvNodeValidationMode,
+			NodeValidationModeDeny,
+			NodeValidationModeWarn,
+		)
+	}
+}
+
+// ValidateNode runs the validation logic for node updates and deletions.  Removing a node from the licensed node pool,
+// either by deleting it or by changing its label, is denied or warned about when the remaining licensed capacity
+// would fall below the capacity used by windows instances.  A node with the maintenance annotation may always be
+// removed.
+func (wh *webhook) ValidateNode(w http.ResponseWriter, r *http.Request) {
+	op, err := newScopedOperation(w, r, nodeScope)
+	if err != nil {
+		wh.fail(op, err)
+		return
+	}
+
+	request := op.request.admissionRequest
+
+	oldNode, newNode, err := decodeNodes(request)
+	if err != nil {
+		wh.fail(op, err)
+		return
+	}
+
+	// events may not be reused once they are sent, so each message needs a new one
+	log := func() *zerolog.Event {
+		return wh.log(op).Str("node", oldNode.Name).Str("operation", string(request.Operation))
+	}
+
+	if !removesLicensedNode(request.Operation, oldNode, newNode, wh.NodeFilter) {
+		op.response.send(http.StatusOK, "node change does not remove a licensed node")
+		return
+	}
+
+	// allow planned maintenance regardless of capacity, but make it visible to the requester
+	if isUnderMaintenance(oldNode) || isUnderMaintenance(newNode) {
+		msg := fmt.Sprintf("skipping capacity validation for node [%s] under planned maintenance", oldNode.Name)
+		log().Msgf("returning with message: [%s]", msg)
+
+		op.response.warnings = append(op.response.warnings, msg)
+		op.response.send(http.StatusOK, msg)
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), decisionTimeout)
+	defer cancel()
+
+	nodeList, err := wh.getFilteredNodes(ctx)
+	if err != nil {
+		wh.fail(op, err)
+		return
+	}
+	remaining := nodeList.Without(oldNode.Name).SumCPU()
+
+	vmInstanceList, err := wh.getFilteredVirtualMachineInstances(ctx)
+	if err != nil {
+		wh.fail(op, err)
+		return
+	}
+	used := vmInstanceList.SumCPU()
+
+	log().
+		Int("total", nodeList.SumCPU()).
+		Int("remaining", remaining).
+		Int("used", used).
+		Str("mode", string(wh.NodeValidationMode)).
+		Msg("node capacity values")
+
+	if remaining >= used {
+		op.response.send(http.StatusOK, fmt.Sprintf("remaining licensed capacity: [%d], covers used capacity: [%d]", remaining, used))
+		return
+	}
+
+	msg := fmt.Sprintf(
+		"removing licensed node [%s] would reduce licensed capacity to [%d], below used capacity [%d]; "+
+			"annotate the node with [%s=true] for planned maintenance",
+		oldNode.Name, remaining, used, resources.MaintenanceAnnotation,
+	)
+	log().Msgf("returning with message: [%s]", msg)
+
+	if wh.NodeValidationMode == NodeValidationModeWarn {
+		op.response.warnings = append(op.response.warnings, msg)
+		op.response.send(http.StatusOK, msg)
+
+		return
+	}
+
+	op.response.allowed = false
+	op.response.send(http.StatusForbidden, msg)
+}
+
+// decodeNodes decodes the node prior to the request and, for updates, the node after the request.
+func decodeNodes(request *admissionv1.AdmissionRequest) (oldNode, newNode *corev1.Node, err error) {
+	oldNode = &corev1.Node{}
+	if err := json.Unmarshal(request.OldObject.Raw, oldNode); err != nil {
+		return nil, nil, newAdmissionError(ErrorTypeDecode, "failed to decode old node object; %w", err)
+	}
+
+	if request.Operation != admissionv1.Update {
+		return oldNode, nil, nil
+	}
+
+	newNode = &corev1.Node{}
+	if err := json.Unmarshal(request.Object.Raw, newNode); err != nil {
+		return nil, nil, newAdmissionError(ErrorTypeDecode, "failed to decode node object; %w", err)
+	}
+
+	return oldNode, newNode, nil
+}
+
+// removesLicensedNode returns if an operation removes a node from the licensed node pool, either by deleting a node
+// matching the node filter or by updating it so that it no longer matches.
+func removesLicensedNode(operation admissionv1.Operation, oldNode, newNode *corev1.Node, filter resources.NodeFilter) bool {
+	if !resources.MatchesNodeFilter(oldNode, filter) {
+		return false
+	}
+
+	if operation == admissionv1.Delete {
+		return true
+	}
+
+	return !resources.MatchesNodeFilter(newNode, filter)
+}
+
+// isUnderMaintenance returns if a node is annotated for planned maintenance.
+func isUnderMaintenance(node *corev1.Node) bool {
+	return node != nil && node.GetAnnotations()[resources.MaintenanceAnnotation] == "true"
+}
@@ -0,0 +1,155 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+func Test_removesLicensedNode(t *testing.T) {
+	t.Parallel()
+
+	node := func(imageType string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: map[string]string{"image_type": imageType}}}
+	}
+
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		oldNode   *corev1.Node
+		newNode   *corev1.Node
+		want      bool
+	}{
+		{
+			name:      "ensure deleting a licensed node removes it",
+			operation: admissionv1.Delete,
+			oldNode:   node("windows"),
+			want:      true,
+		},
+		{
+			name:      "ensure deleting an unlicensed node does not remove a licensed node",
+			operation: admissionv1.Delete,
+			oldNode:   node("linux"),
+			want:      false,
+		},
+		{
+			name:      "ensure relabeling a licensed node removes it",
+			operation: admissionv1.Update,
+			oldNode:   node("windows"),
+			newNode:   node("linux"),
+			want:      true,
+		},
+		{
+			name:      "ensure relabeling a licensed node to another licensed value does not remove it",
+			operation: admissionv1.Update,
+			oldNode:   node("windows"),
+			newNode:   node("windows-2022"),
+			want:      false,
+		},
+		{
+			name:      "ensure labeling an unlicensed node does not remove a licensed node",
+			operation: admissionv1.Update,
+			oldNode:   node("linux"),
+			newNode:   node("windows"),
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			filter := resources.NewNodeFilter("image_type", "windows,windows-2022")
+
+			if got := removesLicensedNode(tt.operation, tt.oldNode, tt.newNode, filter); got != tt.want {
+				t.Errorf("removesLicensedNode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newNodeValidationMode(t *testing.T) {
+	t.Parallel()
+
+	for value, want := range map[string]NodeValidationMode{"": NodeValidationModeDeny, "Warn": NodeValidationModeWarn} {
+		if got, err := newNodeValidationMode(value); err != nil || got != want {
+			t.Errorf("newNodeValidationMode(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+
+	if _, err := newNodeValidationMode("Ignore"); err == nil {
+		t.Errorf("newNodeValidationMode() expected error for invalid value")
+	}
+}
+
+func TestWebhook_ValidateNode(t *testing.T) {
+	t.Parallel()
+
+	node := testNode("node-1", "windows", 8)
+
+	s := newTestAPIServer(t,
+		[]runtime.Object{node, testNode("node-2", "windows", 8)},
+		[]runtime.Object{testWindowsInstance("team-a", "vm-1", "node-2", 12)},
+	)
+
+	output := &bytes.Buffer{}
+	s.webhook.Logger = zerolog.New(output)
+	s.webhook.NodeValidationMode = NodeValidationModeDeny
+
+	oldObject, _ := json.Marshal(node)
+
+	review, _ := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "uid-1",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: resources.NodeType},
+			Operation: admissionv1.Delete,
+			OldObject: runtime.RawExtension{Raw: oldObject},
+		},
+	})
+
+	recorder := httptest.NewRecorder()
+	s.webhook.ValidateNode(recorder, httptest.NewRequest(http.MethodPost, "/validate-node", bytes.NewReader(review)))
+
+	response := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatalf("ValidateNode() returned an invalid review; %v", err)
+	}
+
+	if response.Response.Allowed {
+		t.Errorf("ValidateNode() allowed = true, want removing capacity below the used capacity denied")
+	}
+
+	// each message is logged as its own event with the fields of the node
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("ValidateNode() logged %d lines, want 2; %s", len(lines), output)
+	}
+
+	if strings.Contains(lines[1], `"total"`) {
+		t.Errorf("ValidateNode() logged %s, want the fields of the earlier message left out", lines[1])
+	}
+
+	for _, line := range lines {
+		entry := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("ValidateNode() logged invalid JSON %s; %v", line, err)
+		}
+
+		if entry["node"] != "node-1" || entry["operation"] != "DELETE" || strings.Count(line, `"node"`) != 1 {
+			t.Errorf("ValidateNode() logged %s, want the node and operation once", line)
+		}
+	}
+}
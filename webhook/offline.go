@@ -0,0 +1,39 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+)
+
+// Evaluate runs the validation logic against an AdmissionReview without serving it, returning the AdmissionReview
+// that would have been sent in response.  It is used to reproduce decisions offline, for example against the clients
+// of a cluster snapshot.
+func (wh *webhook) Evaluate(ctx context.Context, review []byte) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, "/validate", bytes.NewReader(review))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request; %w", err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	wh.Validate(recorder, request)
+
+	response := recorder.Result()
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response; %w", err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("validation returned status [%d]; %s", response.StatusCode, body)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/snapshot"
+)
+
+func TestWebhook_Evaluate(t *testing.T) {
+	t.Parallel()
+
+	s := &snapshot.Snapshot{
+		Nodes:                   []corev1.Node{*testNode("node-1", "windows", 8)},
+		VirtualMachineInstances: []kubevirtv1.VirtualMachineInstance{*testWindowsInstance("team-a", "vm-1", "node-1", 6)},
+	}
+
+	wh, err := NewWebhookForClients(s.Clients())
+	if err != nil {
+		t.Fatalf("NewWebhookForClients() error = %v", err)
+	}
+
+	review := func(uid string, cores uint32) []byte {
+		object, err := json.Marshal(testWindowsInstance("team-b", uid, "", cores))
+		if err != nil {
+			t.Fatalf("failed to encode object; %v", err)
+		}
+
+		raw, err := json.Marshal(admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request: &admissionv1.AdmissionRequest{
+				UID:       k8stypes.UID("uid-" + uid),
+				Kind:      metav1.GroupVersionKind{Group: "kubevirt.io", Version: "v1", Kind: "VirtualMachineInstance"},
+				Resource:  metav1.GroupVersionResource{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachineinstances"},
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: object},
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to encode admission review; %v", err)
+		}
+
+		return raw
+	}
+
+	tests := []struct {
+		name        string
+		review      []byte
+		wantAllowed bool
+	}{
+		{
+			name:        "ensure an instance which fits within the snapshot capacity is allowed",
+			review:      review("fits", 2),
+			wantAllowed: true,
+		},
+		{
+			name:   "ensure an instance which exceeds the snapshot capacity is denied",
+			review: review("exceeds", 4),
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := wh.Evaluate(context.Background(), tt.review)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+
+			response := admissionv1.AdmissionReview{}
+			if err := json.Unmarshal(got, &response); err != nil {
+				t.Fatalf("Evaluate() returned invalid admission review; %v", err)
+			}
+
+			if response.Response == nil || response.Response.Allowed != tt.wantAllowed {
+				t.Errorf("Evaluate() response = %+v, want allowed %t", response.Response, tt.wantAllowed)
+			}
+		})
+	}
+}
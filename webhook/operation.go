@@ -1,9 +1,10 @@
 package webhook
 
 import (
-	"fmt"
 	"net/http"
+	"slices"
 
+	admissionv1 "k8s.io/api/admission/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
@@ -17,8 +18,75 @@ type operation struct {
 	object   resources.WindowsInstanceValidator
 }
 
-// NewOperation return a new instance of an operation object.
+// operationScope represents the operations and kinds of objects which a handler supports.
+type operationScope struct {
+	operations []admissionv1.Operation
+	kinds      []string
+}
+
+var (
+	// validateScope is the scope of the validating webhook.  We only care about create operations for now.
+	// TODO: handle update in case create was bypassed somehow
+	// TODO: correct logic if we ever need to account for both virtual machines and virtual machine instances.  For now
+	// we are only counting virtual machine instances.
+	validateScope = operationScope{
+		operations: []admissionv1.Operation{admissionv1.Create},
+		kinds:      []string{resources.VirtualMachineInstanceType},
+	}
+
+	// mutateScope is the scope of the mutating webhook.  Updates are included so that the windows metadata
+	// continues to reflect the object as it changes.
+	mutateScope = operationScope{
+		operations: []admissionv1.Operation{admissionv1.Create, admissionv1.Update},
+		kinds:      []string{resources.VirtualMachineType, resources.VirtualMachineInstanceType},
+	}
+
+	// nodeScope is the scope of the node validating webhook.  Only updates and deletions may remove a node from the
+	// licensed node pool.
+	nodeScope = operationScope{
+		operations: []admissionv1.Operation{admissionv1.Update, admissionv1.Delete},
+		kinds:      []string{resources.NodeType},
+	}
+)
+
+// NewOperation return a new instance of an operation object for validation.  The operation is always returned, even
+// when an error is returned, so that a response may be sent for the request.
 func NewOperation(w http.ResponseWriter, r *http.Request) (*operation, error) {
+	return newOperation(w, r, validateScope)
+}
+
+// newOperation return a new instance of an operation object for a request within a given scope.  The operation is
+// always returned, even when an error is returned, so that a response may be sent for the request.
+func newOperation(w http.ResponseWriter, r *http.Request, scope operationScope) (*operation, error) {
+	op, err := newScopedOperation(w, r, scope)
+	if err != nil {
+		return op, err
+	}
+
+	// get the extractor used for extracting the instance
+	var validator resources.WindowsInstanceValidator
+	switch op.request.admissionRequest.Kind.Kind {
+	case resources.VirtualMachineType:
+		validator = resources.NewVirtualMachine()
+	case resources.VirtualMachineInstanceType:
+		validator = resources.NewVirtualMachineInstance()
+	}
+
+	// extract the instance
+	instance, err := validator.Extract(op.request.admissionRequest)
+	if err != nil {
+		return op, newAdmissionError(ErrorTypeDecode, "failed extracting object from request; %w", err)
+	}
+
+	op.object = instance
+
+	return op, nil
+}
+
+// newScopedOperation return a new instance of an operation object for a request within a given scope, without
+// extracting the object from the request.  The operation is always returned, even when an error is returned, so that
+// a response may be sent for the request.
+func newScopedOperation(w http.ResponseWriter, r *http.Request, scope operationScope) (*operation, error) {
 	// create the base operation object
 	op := &operation{
 		response: &response{
@@ -31,37 +99,40 @@ func NewOperation(w http.ResponseWriter, r *http.Request) (*operation, error) {
 	// create the request object
 	req, err := newRequest(r)
 	if err != nil {
-		return op, fmt.Errorf("unable to create request object; %w", err)
+		return op, err
 	}
 
-	// get the extractor used for extracting the instance
-	// TODO: correct logic if we ever need to account for both virtual machines and virtual machine instances.  For now
-	// we are only counting virtual machine instances.
-	var validator resources.WindowsInstanceValidator
-	switch req.admissionRequest.Kind.Kind {
-	// case resources.VirtualMachineType:
-	// 	validator = resources.NewVirtualMachine()
-	case resources.VirtualMachineInstanceType:
-		validator = resources.NewVirtualMachineInstance()
-	default:
-		return nil, fmt.Errorf(
+	op.request = req
+	op.response.uid = req.admissionRequest.UID
+	op.response.apiVersion = req.apiVersion
+
+	if !slices.Contains(scope.operations, req.admissionRequest.Operation) {
+		return op, newAdmissionError(
+			ErrorTypeUnsupportedOperation,
+			"unsupported operation [%s]; only [%+v] supported",
+			req.admissionRequest.Operation,
+			scope.operations,
+		)
+	}
+
+	if !slices.Contains(scope.kinds, req.admissionRequest.Kind.Kind) {
+		return op, newAdmissionError(
+			ErrorTypeUnsupportedKind,
 			"unsupported kind [%s]; only [%+v] supported",
 			req.admissionRequest.Kind.Kind,
-			resources.SupportedResourceTypes(),
+			scope.kinds,
 		)
 	}
 
-	// extract the instance
-	instance, err := validator.Extract(req.admissionRequest)
-	if err != nil {
-		return nil, fmt.Errorf("failed extracting object from request; %w", err)
-	}
+	return op, nil
+}
 
-	// set some values
-	op.response.uid = req.admissionRequest.UID
-	op.request = req
-	op.response.review = req.admissionReview
-	op.object = instance
+// isDryRun returns if the operation is for a dry run request.  Dry run requests are decided exactly as any other
+// request but must not cause any side effects.
+func (op *operation) isDryRun() bool {
+	if op.request == nil || op.request.admissionRequest.DryRun == nil {
+		return false
+	}
 
-	return op, nil
+	return *op.request.admissionRequest.DryRun
 }
@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"sort"
+	"strings"
+)
+
+// patchOperation represents a single JSON patch operation as defined by RFC 6902.
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+// mapPatch returns the patch operations required to set and remove keys in a map which exists at the given path
+// within an object.  The current value of the map is required as the map must be added as a whole if it does not
+// yet exist.
+func mapPatch(path string, current, set map[string]string, remove []string) []patchOperation {
+	patches := []patchOperation{}
+
+	// add the map as a whole if it does not exist
+	if len(current) == 0 {
+		if len(set) == 0 {
+			return patches
+		}
+
+		return append(patches, patchOperation{Op: "add", Path: path, Value: set})
+	}
+
+	// sort the keys so that patches are deterministic
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if value, found := current[key]; found && value == set[key] {
+			continue
+		}
+
+		patches = append(patches, patchOperation{Op: "add", Path: path + "/" + escapePointer(key), Value: set[key]})
+	}
+
+	for _, key := range remove {
+		if _, found := current[key]; found {
+			patches = append(patches, patchOperation{Op: "remove", Path: path + "/" + escapePointer(key)})
+		}
+	}
+
+	return patches
+}
+
+// escapePointer escapes a key for use as a JSON pointer reference token as defined by RFC 6901.
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
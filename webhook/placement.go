@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+const (
+	// EnvPinWindowsInstances enables pinning windows instances to the nodes matching the node filter.
+	EnvPinWindowsInstances = "WEBHOOK_PIN_WINDOWS_INSTANCES"
+
+	// EnvNodeTolerations is a JSON list of tolerations which are added to pinned windows instances so that they may
+	// tolerate any taints on the nodes matching the node filter.
+	EnvNodeTolerations = "WEBHOOK_NODE_TOLERATIONS"
+
+	requiredNodeAffinityPath = "/affinity/nodeAffinity/requiredDuringSchedulingIgnoredDuringExecution"
+)
+
+// placement pins windows instances to the nodes that are counted as licensed capacity, which are the nodes matching
+// the node filter.  Without this, a windows instance may be scheduled on an unlicensed node.
+type placement struct {
+	filter      resources.NodeFilter
+	tolerations []corev1.Toleration
+}
+
+// newPlacement returns a new instance of a placement object given the node filter and a JSON list of tolerations.
+func newPlacement(filter resources.NodeFilter, tolerationsJSON string) (*placement, error) {
+	p := &placement{filter: filter, tolerations: []corev1.Toleration{}}
+
+	if tolerationsJSON == "" {
+		return p, nil
+	}
+
+	if err := json.Unmarshal([]byte(tolerationsJSON), &p.tolerations); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tolerations; %w", err)
+	}
+
+	return p, nil
+}
+
+// pinnable returns if the object of a request may be pinned to the licensed nodes.  The scheduling constraints of a
+// virtual machine instance may not change once it has been created and its updates come from virt-controller and
+// virt-handler, so only new instances are pinned.  Virtual machine templates are pinned on any change.
+func pinnable(request *admissionv1.AdmissionRequest) bool {
+	return request.Kind.Kind != resources.VirtualMachineInstanceType || request.Operation == admissionv1.Create
+}
+
+// patches returns the patches required to pin the scheduling constraints of an object to the nodes matching the node
+// filter.  An error is returned if the existing scheduling constraints conflict with the node filter.
+func (p *placement) patches(scheduling resources.ObjectScheduling) ([]patchOperation, error) {
+	key, values := p.filter.LabelKey(), p.filter.LabelValues()
+
+	patches := []patchOperation{}
+
+	// a node selector on the label key must select a licensed node.  if there is not one, we may use a node selector
+	// to pin the instance when there is only a single value and fall back to node affinity otherwise.
+	value, selected := scheduling.NodeSelector[key]
+	if selected && !slices.Contains(values, value) {
+		return nil, fmt.Errorf(
+			"node selector [%s=%s] conflicts with licensed nodes [%s in %v]",
+			key, value, key, values,
+		)
+	}
+
+	useNodeSelector := !selected && len(values) == 1
+	if useNodeSelector {
+		patches = append(patches, mapPatch(
+			scheduling.Path+"/nodeSelector",
+			scheduling.NodeSelector,
+			map[string]string{key: values[0]},
+			nil,
+		)...)
+	}
+
+	affinityPatches, err := p.affinityPatches(scheduling, !selected && !useNodeSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	patches = append(patches, affinityPatches...)
+	patches = append(patches, p.tolerationPatches(scheduling)...)
+
+	return patches, nil
+}
+
+// affinityPatches validates that any required node affinity does not conflict with the node filter, optionally
+// returning the patches required to pin the object with required node affinity.
+func (p *placement) affinityPatches(scheduling resources.ObjectScheduling, pin bool) ([]patchOperation, error) {
+	key, values := p.filter.LabelKey(), p.filter.LabelValues()
+	requirement := corev1.NodeSelectorRequirement{Key: key, Operator: corev1.NodeSelectorOpIn, Values: values}
+
+	patches := []patchOperation{}
+
+	var required *corev1.NodeSelector
+	if scheduling.Affinity != nil && scheduling.Affinity.NodeAffinity != nil {
+		required = scheduling.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	}
+
+	// node selector terms are ORed while the expressions within a term are ANDed, so each term must be pinned
+	if required != nil && len(required.NodeSelectorTerms) > 0 {
+		for i, term := range required.NodeSelectorTerms {
+			constrained := false
+
+			for _, expression := range term.MatchExpressions {
+				if expression.Key != key {
+					continue
+				}
+
+				constrained = true
+
+				if expression.Operator != corev1.NodeSelectorOpIn || !isSubset(expression.Values, values) {
+					return nil, fmt.Errorf(
+						"node affinity [%s %s %v] conflicts with licensed nodes [%s in %v]",
+						expression.Key, expression.Operator, expression.Values, key, values,
+					)
+				}
+			}
+
+			if constrained || !pin {
+				continue
+			}
+
+			path := fmt.Sprintf("%s%s/nodeSelectorTerms/%d/matchExpressions", scheduling.Path, requiredNodeAffinityPath, i)
+			if len(term.MatchExpressions) == 0 {
+				patches = append(patches, patchOperation{Op: "add", Path: path, Value: []corev1.NodeSelectorRequirement{requirement}})
+			} else {
+				patches = append(patches, patchOperation{Op: "add", Path: path + "/-", Value: requirement})
+			}
+		}
+
+		return patches, nil
+	}
+
+	if !pin {
+		return patches, nil
+	}
+
+	selector := &corev1.NodeSelector{
+		NodeSelectorTerms: []corev1.NodeSelectorTerm{
+			{MatchExpressions: []corev1.NodeSelectorRequirement{requirement}},
+		},
+	}
+
+	switch {
+	case scheduling.Affinity == nil:
+		patches = append(patches, patchOperation{
+			Op:    "add",
+			Path:  scheduling.Path + "/affinity",
+			Value: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: selector}},
+		})
+	case scheduling.Affinity.NodeAffinity == nil:
+		patches = append(patches, patchOperation{
+			Op:    "add",
+			Path:  scheduling.Path + "/affinity/nodeAffinity",
+			Value: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: selector},
+		})
+	default:
+		patches = append(patches, patchOperation{
+			Op:    "add",
+			Path:  scheduling.Path + requiredNodeAffinityPath,
+			Value: selector,
+		})
+	}
+
+	return patches, nil
+}
+
+// tolerationPatches returns the patches required to add any missing tolerations to the object.
+func (p *placement) tolerationPatches(scheduling resources.ObjectScheduling) []patchOperation {
+	missing := []corev1.Toleration{}
+
+	for i := range p.tolerations {
+		if !slices.ContainsFunc(scheduling.Tolerations, func(existing corev1.Toleration) bool {
+			return existing.MatchToleration(&p.tolerations[i])
+		}) {
+			missing = append(missing, p.tolerations[i])
+		}
+	}
+
+	if len(missing) == 0 {
+		return []patchOperation{}
+	}
+
+	if len(scheduling.Tolerations) == 0 {
+		return []patchOperation{{Op: "add", Path: scheduling.Path + "/tolerations", Value: missing}}
+	}
+
+	patches := make([]patchOperation, len(missing))
+	for i := range missing {
+		patches[i] = patchOperation{Op: "add", Path: scheduling.Path + "/tolerations/-", Value: missing[i]}
+	}
+
+	return patches
+}
+
+// isSubset returns if every value in a list of values is contained in a list of allowed values.
+func isSubset(values, allowed []string) bool {
+	for _, value := range values {
+		if !slices.Contains(allowed, value) {
+			return false
+		}
+	}
+
+	return len(values) > 0
+}
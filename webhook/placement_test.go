@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+func Test_placement_patches(t *testing.T) {
+	t.Parallel()
+
+	toleration := corev1.Toleration{Key: "os", Operator: corev1.TolerationOpEqual, Value: "windows", Effect: corev1.TaintEffectNoSchedule}
+	requirement := corev1.NodeSelectorRequirement{Key: "image_type", Operator: corev1.NodeSelectorOpIn, Values: []string{"windows", "windows-2022"}}
+
+	tests := []struct {
+		name        string
+		values      string
+		tolerations string
+		scheduling  resources.ObjectScheduling
+		want        []patchOperation
+		wantErr     bool
+	}{
+		{
+			name:       "ensure a single licensed value is pinned with a node selector",
+			values:     "windows",
+			scheduling: resources.ObjectScheduling{Path: "/spec"},
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/nodeSelector", Value: map[string]string{"image_type": "windows"}},
+			},
+		},
+		{
+			name:       "ensure an existing licensed node selector is not changed",
+			values:     "windows",
+			scheduling: resources.ObjectScheduling{Path: "/spec", NodeSelector: map[string]string{"image_type": "windows"}},
+			want:       []patchOperation{},
+		},
+		{
+			name:       "ensure a node selector for unlicensed nodes is a conflict",
+			values:     "windows",
+			scheduling: resources.ObjectScheduling{Path: "/spec", NodeSelector: map[string]string{"image_type": "linux"}},
+			wantErr:    true,
+		},
+		{
+			name:       "ensure multiple licensed values are pinned with node affinity",
+			values:     "windows,windows-2022",
+			scheduling: resources.ObjectScheduling{Path: "/spec/template/spec"},
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/template/spec/affinity", Value: &corev1.Affinity{
+					NodeAffinity: &corev1.NodeAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+							NodeSelectorTerms: []corev1.NodeSelectorTerm{
+								{MatchExpressions: []corev1.NodeSelectorRequirement{requirement}},
+							},
+						},
+					},
+				}},
+			},
+		},
+		{
+			name:   "ensure each existing node selector term is pinned",
+			values: "windows,windows-2022",
+			scheduling: resources.ObjectScheduling{Path: "/spec", Affinity: &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{
+							{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "zone", Operator: corev1.NodeSelectorOpExists}}},
+							{MatchFields: []corev1.NodeSelectorRequirement{{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"node"}}}},
+						},
+					},
+				},
+			}},
+			want: []patchOperation{
+				{Op: "add", Path: "/spec" + requiredNodeAffinityPath + "/nodeSelectorTerms/0/matchExpressions/-", Value: requirement},
+				{Op: "add", Path: "/spec" + requiredNodeAffinityPath + "/nodeSelectorTerms/1/matchExpressions", Value: []corev1.NodeSelectorRequirement{requirement}},
+			},
+		},
+		{
+			name:   "ensure node affinity for unlicensed nodes is a conflict",
+			values: "windows",
+			scheduling: resources.ObjectScheduling{Path: "/spec", Affinity: &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{
+							{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "image_type", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"windows"}}}},
+						},
+					},
+				},
+			}},
+			wantErr: true,
+		},
+		{
+			name:        "ensure missing tolerations are added",
+			values:      "windows",
+			tolerations: `[{"key":"os","operator":"Equal","value":"windows","effect":"NoSchedule"}]`,
+			scheduling: resources.ObjectScheduling{
+				Path:         "/spec",
+				NodeSelector: map[string]string{"image_type": "windows"},
+				Tolerations:  []corev1.Toleration{{Key: "other", Operator: corev1.TolerationOpExists}},
+			},
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/tolerations/-", Value: toleration},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := newPlacement(resources.NewNodeFilter("image_type", tt.values), tt.tolerations)
+			if err != nil {
+				t.Fatalf("newPlacement() error = %v", err)
+			}
+
+			patches, err := p.patches(tt.scheduling)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("placement.patches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			// compare the marshaled patches as the values are generic
+			got, _ := json.Marshal(patches)
+			want, _ := json.Marshal(tt.want)
+
+			if string(got) != string(want) {
+				t.Errorf("placement.patches() = %s, want %s", got, want)
+			}
+		})
+	}
+}
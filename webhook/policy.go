@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// FailureAction represents the action taken on an admission request when an error occurs while handling it.
+type FailureAction string
+
+const (
+	FailureActionAllow FailureAction = "Allow"
+	FailureActionDeny  FailureAction = "Deny"
+
+	// EnvFailurePolicyPrefix is the prefix of the environment variables which set the failure action for each error
+	// type.  For example, WEBHOOK_FAILURE_POLICY_API sets the action for ErrorTypeAPI errors.
+	EnvFailurePolicyPrefix = "WEBHOOK_FAILURE_POLICY_"
+)
+
+// defaultFailureActions are the default actions for each error type.  We fail closed for anything which prevents us
+// from knowing the answer, and fail open for requests that we were never meant to handle.
+var defaultFailureActions = map[ErrorType]FailureAction{
+	ErrorTypeDecode:               FailureActionDeny,
+	ErrorTypeUnsupportedKind:      FailureActionAllow,
+	ErrorTypeUnsupportedOperation: FailureActionAllow,
+	ErrorTypeAPI:                  FailureActionDeny,
+	ErrorTypeInternal:             FailureActionDeny,
+}
+
+// FailurePolicy decides whether an admission request is allowed or denied when an error occurs while handling it.
+type FailurePolicy struct {
+	actions map[ErrorType]FailureAction
+}
+
+// NewFailurePolicy returns a new instance of a failure policy with defaults, overridden by any environment variables.
+func NewFailurePolicy() (*FailurePolicy, error) {
+	policy := &FailurePolicy{actions: map[ErrorType]FailureAction{}}
+
+	for _, errorType := range ErrorTypes() {
+		policy.actions[errorType] = defaultFailureActions[errorType]
+
+		key := EnvFailurePolicyPrefix + envSuffix(errorType)
+
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+
+		switch action := FailureAction(value); action {
+		case FailureActionAllow, FailureActionDeny:
+			policy.actions[errorType] = action
+		default:
+			return nil, fmt.Errorf(
+				"invalid value [%s] for [%s]; must be one of [%s, %s]",
+				value,
+				key,
+				FailureActionAllow,
+				FailureActionDeny,
+			)
+		}
+	}
+
+	return policy, nil
+}
+
+// Allows returns if the failure policy allows a request which failed with a given error.
+func (policy *FailurePolicy) Allows(err error) bool {
+	return policy.actions[errorTypeOf(err)] == FailureActionAllow
+}
+
+var envSuffixPattern = regexp.MustCompile(`([a-z])([A-Z])`)
+
+// envSuffix returns the environment variable suffix for an error type (e.g. UnsupportedKind becomes
+// UNSUPPORTED_KIND).
+func envSuffix(errorType ErrorType) string {
+	return strings.ToUpper(envSuffixPattern.ReplaceAllString(string(errorType), "${1}_${2}"))
+}
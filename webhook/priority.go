@@ -0,0 +1,216 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+const (
+	// EnvReservedCapacity is the capacity held back for high priority windows instances, either as a number of vCPUs
+	// (e.g. 8) or as a percentage of the licensed capacity (e.g. 10%).  Lower priority instances may not use it.
+	EnvReservedCapacity = "WEBHOOK_RESERVED_CAPACITY"
+
+	// EnvHighPriority is the minimum priority of a high priority windows instance.
+	EnvHighPriority = "WEBHOOK_HIGH_PRIORITY"
+
+	DefaultHighPriority int32 = 1000
+
+	// priorityClassCacheTTL is the amount of time that priority classes are cached for.
+	priorityClassCacheTTL = 30 * time.Second
+)
+
+// priorityPolicy decides how much of the licensed capacity is available to a windows instance given its priority.
+type priorityPolicy struct {
+	highPriority    int32
+	reservedVCPUs   int
+	reservedPercent int
+}
+
+// newPriorityPolicy returns a new instance of a priority policy given the reserved capacity and high priority values,
+// using the defaults if they are unset.
+func newPriorityPolicy(reservedCapacity, highPriority string) (*priorityPolicy, error) {
+	policy := &priorityPolicy{highPriority: DefaultHighPriority}
+
+	if highPriority != "" {
+		value, err := strconv.ParseInt(highPriority, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value [%s] for [%s]; %w", highPriority, EnvHighPriority, err)
+		}
+
+		policy.highPriority = int32(value)
+	}
+
+	if reservedCapacity == "" {
+		return policy, nil
+	}
+
+	percent, isPercent := strings.CutSuffix(reservedCapacity, "%")
+
+	value, err := strconv.Atoi(percent)
+	if err != nil || value < 0 || (isPercent && value > 100) {
+		return nil, fmt.Errorf(
+			"invalid value [%s] for [%s]; must be a number of vCPUs or a percentage",
+			reservedCapacity,
+			EnvReservedCapacity,
+		)
+	}
+
+	if isPercent {
+		policy.reservedPercent = value
+	} else {
+		policy.reservedVCPUs = value
+	}
+
+	return policy, nil
+}
+
+// isHighPriority returns if a priority is high enough to use the reserved capacity.
+func (policy *priorityPolicy) isHighPriority(priority int32) bool {
+	return priority >= policy.highPriority
+}
+
+// reserved returns the capacity held back for high priority instances given the total licensed capacity.
+func (policy *priorityPolicy) reserved(total int) int {
+	if policy.reservedPercent > 0 {
+		return total * policy.reservedPercent / 100
+	}
+
+	return policy.reservedVCPUs
+}
+
+// priorityClassCache caches the value of each priority class by name so that priority classes, which rarely change,
+// are not listed for every admission request.  A nil cache caches nothing.
+type priorityClassCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	classes map[string]int32
+	expires time.Time
+}
+
+// newPriorityClassCache returns a new priority class cache which holds the priority classes for the given ttl.
+func newPriorityClassCache(ttl time.Duration) *priorityClassCache {
+	return &priorityClassCache{ttl: ttl}
+}
+
+// get returns the cached priority classes, unless they have expired.
+func (cache *priorityClassCache) get() (map[string]int32, bool) {
+	if cache == nil {
+		return nil, false
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.classes == nil || time.Now().After(cache.expires) {
+		return nil, false
+	}
+
+	return cache.classes, true
+}
+
+// put stores the priority classes.  They must not be modified once they are stored.
+func (cache *priorityClassCache) put(classes map[string]int32) {
+	if cache == nil {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.classes, cache.expires = classes, time.Now().Add(cache.ttl)
+}
+
+// priorityClasses returns the value of each priority class by name.
+func (wh *webhook) priorityClasses(ctx context.Context) (map[string]int32, error) {
+	if classes, found := wh.priorities.get(); found {
+		return classes, nil
+	}
+
+	classList, err := wh.KubeClient.SchedulingV1().PriorityClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, newAdmissionError(ErrorTypeAPI, "failed to list priority classes; %w", err)
+	}
+
+	classes := make(map[string]int32, len(classList.Items))
+	for i := range classList.Items {
+		classes[classList.Items[i].Name] = classList.Items[i].Value
+	}
+
+	wh.priorities.put(classes)
+
+	return classes, nil
+}
+
+// priorityOf returns the priority of a windows instance, which is the value of its priority class, or zero for
+// instances without one.  The priority annotation may lower the priority of an instance but, as it may be set by
+// anyone who may create the instance, it is capped at the value of the priority class.
+func priorityOf(instance *kubevirtv1.VirtualMachineInstance, classes map[string]int32) int32 {
+	return resources.CappedPriority(instance.GetAnnotations(), resources.PriorityAnnotation, classes[instance.Spec.PriorityClassName])
+}
+
+// preemptionCandidate represents a lower priority windows instance whose shutdown would make room for a request.
+type preemptionCandidate struct {
+	namespace string
+	name      string
+	priority  int32
+	vcpus     int
+}
+
+// String returns the candidate as it is displayed in a denial message.
+func (candidate preemptionCandidate) String() string {
+	return fmt.Sprintf("%s/%s (priority %d, %d vCPUs)", candidate.namespace, candidate.name, candidate.priority, candidate.vcpus)
+}
+
+// preemptionCandidates returns the lower priority windows instances whose shutdown would free at least the shortfall
+// of capacity, preferring the lowest priority and then the largest instances so that as few as possible are listed.
+// No candidates are returned if shutting down every lower priority instance would not make room.
+func preemptionCandidates(
+	instances resources.VirtualMachineInstances,
+	classes map[string]int32,
+	priority int32,
+	shortfall int,
+) []preemptionCandidate {
+	lower := []preemptionCandidate{}
+
+	for i := range instances {
+		candidate := preemptionCandidate{
+			namespace: instances[i].Namespace,
+			name:      instances[i].Name,
+			priority:  priorityOf(&instances[i], classes),
+			vcpus:     resources.VirtualMachineInstances{instances[i]}.SumCPU(),
+		}
+
+		if candidate.priority < priority {
+			lower = append(lower, candidate)
+		}
+	}
+
+	sort.SliceStable(lower, func(i, j int) bool {
+		if lower[i].priority != lower[j].priority {
+			return lower[i].priority < lower[j].priority
+		}
+
+		return lower[i].vcpus > lower[j].vcpus
+	})
+
+	freed := 0
+	for i := range lower {
+		freed += lower[i].vcpus
+
+		if freed >= shortfall {
+			return lower[:i+1]
+		}
+	}
+
+	return []preemptionCandidate{}
+}
@@ -0,0 +1,306 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+func Test_newPriorityPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		reserved     string
+		highPriority string
+		total        int
+		want         int
+		wantErr      bool
+	}{
+		{name: "ensure nothing is reserved by default", total: 100, want: 0},
+		{name: "ensure a number of vCPUs is reserved", reserved: "8", total: 100, want: 8},
+		{name: "ensure a percentage of capacity is reserved", reserved: "10%", total: 64, want: 6},
+		{name: "ensure a percentage above 100 is invalid", reserved: "150%", wantErr: true},
+		{name: "ensure a non-numeric reservation is invalid", reserved: "some", wantErr: true},
+		{name: "ensure a non-numeric high priority is invalid", highPriority: "high", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			policy, err := newPriorityPolicy(tt.reserved, tt.highPriority)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newPriorityPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if got := policy.reserved(tt.total); got != tt.want {
+				t.Errorf("priorityPolicy.reserved() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_preemptionCandidates(t *testing.T) {
+	t.Parallel()
+
+	instance := func(name string, cores uint32, priorityClassName string, annotations map[string]string) kubevirtv1.VirtualMachineInstance {
+		return kubevirtv1.VirtualMachineInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test", Annotations: annotations},
+			Spec: kubevirtv1.VirtualMachineInstanceSpec{
+				PriorityClassName: priorityClassName,
+				Domain:            kubevirtv1.DomainSpec{CPU: &kubevirtv1.CPU{Sockets: 1, Cores: cores, Threads: 1}},
+			},
+		}
+	}
+
+	classes := map[string]int32{"low": 10, "high": 2000}
+
+	instances := resources.VirtualMachineInstances{
+		instance("default-small", 2, "", nil),
+		instance("low-large", 8, "low", nil),
+		instance("low-annotated-high", 8, "low", map[string]string{resources.PriorityAnnotation: "5000"}),
+		instance("default-large", 4, "", nil),
+		instance("high", 16, "high", nil),
+	}
+
+	tests := []struct {
+		name      string
+		shortfall int
+		want      []string
+	}{
+		{
+			name:      "ensure the lowest priority and largest instances are listed first",
+			shortfall: 5,
+			want:      []string{"test/default-large", "test/default-small"},
+		},
+		{
+			name:      "ensure higher priorities are listed once lower priorities are exhausted",
+			shortfall: 10,
+			want:      []string{"test/default-large", "test/default-small", "test/low-large"},
+		},
+		{
+			name:      "ensure the priority annotation may not raise an instance above its priority class",
+			shortfall: 20,
+			want:      []string{"test/default-large", "test/default-small", "test/low-large", "test/low-annotated-high"},
+		},
+		{
+			name:      "ensure nothing is listed when lower priorities would not make room",
+			shortfall: 30,
+			want:      []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			candidates := preemptionCandidates(instances, classes, 2000, tt.shortfall)
+
+			got := []string{}
+			for _, candidate := range candidates {
+				got = append(got, candidate.namespace+"/"+candidate.name)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("preemptionCandidates() = %v, want %v", got, tt.want)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("preemptionCandidates() = %v, want %v", got, tt.want)
+
+					break
+				}
+			}
+		})
+	}
+}
+
+func Test_priorityOf(t *testing.T) {
+	t.Parallel()
+
+	classes := map[string]int32{"low": 10, "high": 2000}
+
+	tests := []struct {
+		name          string
+		priorityClass string
+		annotation    string
+		want          int32
+	}{
+		{
+			name:          "ensure the priority is taken from the priority class",
+			priorityClass: "high",
+			want:          2000,
+		},
+		{
+			name: "ensure an instance without a priority class has no priority",
+			want: 0,
+		},
+		{
+			name:          "ensure the annotation may lower the priority",
+			priorityClass: "high",
+			annotation:    "100",
+			want:          100,
+		},
+		{
+			name:          "ensure the annotation may not raise the priority above the priority class",
+			priorityClass: "low",
+			annotation:    "5000",
+			want:          10,
+		},
+		{
+			name:       "ensure the annotation may not raise the priority of an instance without a priority class",
+			annotation: "5000",
+			want:       0,
+		},
+		{
+			name:          "ensure an invalid annotation is ignored",
+			priorityClass: "high",
+			annotation:    "urgent",
+			want:          2000,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			instance := &kubevirtv1.VirtualMachineInstance{
+				Spec: kubevirtv1.VirtualMachineInstanceSpec{PriorityClassName: tt.priorityClass},
+			}
+
+			if tt.annotation != "" {
+				instance.Annotations = map[string]string{resources.PriorityAnnotation: tt.annotation}
+			}
+
+			if got := priorityOf(instance, classes); got != tt.want {
+				t.Errorf("priorityOf() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhook_priorityClasses(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "high"}, Value: 2000})
+
+	wh := &webhook{KubeClient: client, priorities: newPriorityClassCache(time.Minute)}
+
+	for i := 0; i < 2; i++ {
+		classes, err := wh.priorityClasses(ctx)
+		if err != nil {
+			t.Fatalf("priorityClasses() error = %v", err)
+		}
+
+		if classes["high"] != 2000 {
+			t.Errorf("priorityClasses() = %v, want the value of each class", classes)
+		}
+	}
+
+	if actions := client.Actions(); len(actions) != 1 {
+		t.Errorf("priorityClasses() made %d requests, want the classes to be cached", len(actions))
+	}
+
+	// expired classes are listed again
+	wh.priorities.expires = time.Now().Add(-time.Second)
+
+	if _, err := wh.priorityClasses(ctx); err != nil {
+		t.Fatalf("priorityClasses() error = %v", err)
+	}
+
+	if actions := client.Actions(); len(actions) != 2 {
+		t.Errorf("priorityClasses() made %d requests, want expired classes to be listed again", len(actions))
+	}
+}
+
+func TestWebhook_decide_preemption(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		visible namespaceVisibility
+		cores   uint32
+		want    string
+	}{
+		{
+			name:    "ensure candidates in visible namespaces are listed",
+			visible: func(string) bool { return true },
+			cores:   8,
+			want: "; shutting down lower priority instances would make room: " +
+				"[team-b/vm-1 (priority 0, 4 vCPUs), team-a/vm-1 (priority 0, 2 vCPUs)]",
+		},
+		{
+			name:    "ensure candidates in other namespaces are only counted",
+			visible: objectNamespace("team-a"),
+			cores:   8,
+			want: "; shutting down lower priority instances would make room: " +
+				"[team-a/vm-1 (priority 0, 2 vCPUs)] and [1] instances in other namespaces",
+		},
+		{
+			name:    "ensure candidates are not listed when none are in visible namespaces",
+			visible: objectNamespace("team-c"),
+			cores:   6,
+			want:    "; shutting down [1] lower priority instances in other namespaces would make room",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			wh := newTestAPIServer(t,
+				[]runtime.Object{
+					testNode("node-1", "windows", 8),
+					&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "high"}, Value: 2000},
+				},
+				[]runtime.Object{
+					testWindowsInstance("team-a", "vm-1", "node-1", 2),
+					testWindowsInstance("team-b", "vm-1", "node-1", 4),
+				},
+			).webhook
+
+			instance := testWindowsInstance("team-a", "vm-2", "", tt.cores)
+			instance.Spec.PriorityClassName = "high"
+
+			object, _ := json.Marshal(instance)
+
+			validator, err := resources.NewVirtualMachineInstance().Extract(
+				&admissionv1.AdmissionRequest{Object: runtime.RawExtension{Raw: object}},
+			)
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+
+			decision, _, err := wh.decide(context.Background(), validator, instance, tt.visible)
+			if err != nil {
+				t.Fatalf("decide() error = %v", err)
+			}
+
+			if decision.Allowed || !strings.Contains(decision.Message, tt.want+";") {
+				t.Errorf("decide() message = %s, want %s", decision.Message, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+// EnvQueueVirtualMachines enables queueing windows virtual machines which would exceed the licensed capacity.  Queued
+// virtual machines are admitted halted and are started by the controller once capacity is available.
+const EnvQueueVirtualMachines = "WEBHOOK_QUEUE_VIRTUAL_MACHINES"
+
+// queuePatches returns the metadata changes and patches required to queue a windows virtual machine which would
+// exceed the licensed capacity if it were started.  Only requests which start a virtual machine are considered, as
+// the instance of a virtual machine which is already running is already counted.  A virtual machine is also queued
+// when others are queued ahead of it so that they are started in order.  A queued virtual machine is ahead of any
+// which is not queued, and is only started, whether by the controller or by its owner, from the front of the queue.
+func (wh *webhook) queuePatches(ctx context.Context, op *operation) (*metadataChanges, []patchOperation, error) {
+	request := op.request.admissionRequest
+
+	if !wh.QueueVirtualMachines || request.Kind.Kind != resources.VirtualMachineType {
+		return nil, []patchOperation{}, nil
+	}
+
+	vm, oldVM, err := decodeVirtualMachines(request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// only the webhook queues virtual machines, so any queue metadata added by the request is reverted
+	restored := restoredQueueMetadata(vm, oldVM)
+
+	strategy := resources.RunStrategy(vm)
+	if !op.object.NeedsValidation().NeedsValidation || vm.Spec.Template == nil || !resources.StartsAutomatically(strategy) {
+		return restored, []patchOperation{}, nil
+	}
+
+	var queuedVM *kubevirtv1.VirtualMachine
+	if oldVM != nil {
+		if resources.StartsAutomatically(resources.RunStrategy(oldVM)) {
+			return restored, []patchOperation{}, nil
+		}
+
+		if oldVM.GetLabels()[resources.QueuedLabelKey] == resources.QueuedLabelValue {
+			queuedVM = oldVM
+		}
+	}
+
+	nodeList, err := wh.getFilteredNodes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vmInstanceList, err := wh.getFilteredVirtualMachineInstances(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	requested, available := op.object.SumCPU(), nodeList.SumCPU()-vmInstanceList.SumCPU()
+
+	ahead, err := wh.queuedAhead(ctx, queuedVM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the virtual machine may start, so clear any queue metadata left from a previous queueing
+	if requested <= available && ahead == 0 {
+		return &metadataChanges{
+			removeLabels: []string{resources.QueuedLabelKey},
+			removeAnnotations: []string{
+				resources.QueuedAtAnnotation,
+				resources.QueuedRunStrategyAnnotation,
+				resources.QueuePositionAnnotation,
+			},
+		}, []patchOperation{}, nil
+	}
+
+	// keep the original position in the queue if the virtual machine was previously queued.  The queued time of the
+	// request is never used, as it would allow a virtual machine to be backdated to the front of the queue.
+	queuedAt := time.Now().UTC().Format(time.RFC3339)
+	if oldVM != nil && oldVM.GetAnnotations()[resources.QueuedAtAnnotation] != "" {
+		queuedAt = oldVM.GetAnnotations()[resources.QueuedAtAnnotation]
+	}
+
+	msg := fmt.Sprintf(
+		"requested capacity: [%d], exceeds available capacity: [%d] or [%d] virtual machines are queued ahead of it; "+
+			"virtual machine is queued and will start once capacity is available",
+		requested, available, ahead,
+	)
+
+	wh.log(op).Msg(msg)
+	op.response.warnings = append(op.response.warnings, msg)
+
+	patches := []patchOperation{{Op: "add", Path: "/spec/runStrategy", Value: kubevirtv1.RunStrategyHalted}}
+	if vm.Spec.Running != nil {
+		patches = append(patches, patchOperation{Op: "remove", Path: "/spec/running"})
+	}
+
+	return &metadataChanges{
+		setLabels: map[string]string{resources.QueuedLabelKey: resources.QueuedLabelValue},
+		setAnnotations: map[string]string{
+			resources.QueuedAtAnnotation:          queuedAt,
+			resources.QueuedRunStrategyAnnotation: string(strategy),
+		},
+	}, patches, nil
+}
+
+// restoredQueueMetadata returns the changes which revert the queued label and queued time of a virtual machine to
+// those prior to the request, or nil if the request did not add or change them.  The queue metadata may be removed,
+// which leaves the queue, but not added or changed, as that would allow a virtual machine to jump the queue.
+func restoredQueueMetadata(vm, oldVM *kubevirtv1.VirtualMachine) *metadataChanges {
+	previous := oldVM
+	if previous == nil {
+		previous = &kubevirtv1.VirtualMachine{}
+	}
+
+	changes := &metadataChanges{setLabels: map[string]string{}, setAnnotations: map[string]string{}}
+
+	label, oldLabel := vm.GetLabels()[resources.QueuedLabelKey], previous.GetLabels()[resources.QueuedLabelKey]
+	if label != "" && label != oldLabel {
+		if oldLabel == "" {
+			changes.removeLabels = append(changes.removeLabels, resources.QueuedLabelKey)
+		} else {
+			changes.setLabels[resources.QueuedLabelKey] = oldLabel
+		}
+	}
+
+	queuedAt, oldQueuedAt := vm.GetAnnotations()[resources.QueuedAtAnnotation], previous.GetAnnotations()[resources.QueuedAtAnnotation]
+	if queuedAt != "" && queuedAt != oldQueuedAt {
+		if oldQueuedAt == "" {
+			changes.removeAnnotations = append(changes.removeAnnotations, resources.QueuedAtAnnotation)
+		} else {
+			changes.setAnnotations[resources.QueuedAtAnnotation] = oldQueuedAt
+		}
+	}
+
+	if len(changes.setLabels)+len(changes.setAnnotations)+len(changes.removeLabels)+len(changes.removeAnnotations) == 0 {
+		return nil
+	}
+
+	return changes
+}
+
+// queuedAhead returns the number of queued windows virtual machines which are started before a virtual machine, given
+// the virtual machine as it was queued, or nil if it is not queued.  A virtual machine which is not queued is behind
+// every queued virtual machine.
+func (wh *webhook) queuedAhead(ctx context.Context, queuedVM *kubevirtv1.VirtualMachine) (int, error) {
+	vmList, err := wh.VirtClient.VirtualMachine(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", resources.QueuedLabelKey, resources.QueuedLabelValue),
+	})
+	if err != nil {
+		return 0, newAdmissionError(ErrorTypeAPI, "failed to list queued virtual machines; %w", err)
+	}
+
+	if queuedVM == nil {
+		return len(vmList.Items), nil
+	}
+
+	var classes map[string]int32
+	if wh.QueueOrder == resources.QueueOrderPriority {
+		if classes, err = wh.priorityClasses(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	// order the virtual machine as it was queued, rather than as it is listed, as it may have changed since
+	vms := []*kubevirtv1.VirtualMachine{queuedVM}
+	for i := range vmList.Items {
+		if vmList.Items[i].Namespace != queuedVM.Namespace || vmList.Items[i].Name != queuedVM.Name {
+			vms = append(vms, &vmList.Items[i])
+		}
+	}
+
+	for position, vm := range resources.QueuedVirtualMachines(vms, wh.QueueOrder, classes) {
+		if vm == queuedVM {
+			return position, nil
+		}
+	}
+
+	return len(vms) - 1, nil
+}
+
+// decodeVirtualMachines decodes the virtual machine from the request and, for updates, the virtual machine prior to
+// the request.
+func decodeVirtualMachines(request *admissionv1.AdmissionRequest) (vm, oldVM *kubevirtv1.VirtualMachine, err error) {
+	vm = &kubevirtv1.VirtualMachine{}
+	if err := json.Unmarshal(request.Object.Raw, vm); err != nil {
+		return nil, nil, newAdmissionError(ErrorTypeDecode, "failed to decode virtual machine object; %w", err)
+	}
+
+	if request.Operation != admissionv1.Update {
+		return vm, nil, nil
+	}
+
+	oldVM = &kubevirtv1.VirtualMachine{}
+	if err := json.Unmarshal(request.OldObject.Raw, oldVM); err != nil {
+		return nil, nil, newAdmissionError(ErrorTypeDecode, "failed to decode old virtual machine object; %w", err)
+	}
+
+	return vm, oldVM, nil
+}
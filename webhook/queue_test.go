@@ -0,0 +1,355 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	admissionv1 "k8s.io/api/admission/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+	kubevirtfake "kubevirt.io/client-go/kubevirt/fake"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
+)
+
+func testWindowsVirtualMachine(namespace, name string, cores uint32, strategy kubevirtv1.VirtualMachineRunStrategy) *kubevirtv1.VirtualMachine {
+	instance := testWindowsInstance(namespace, name, "", cores)
+
+	return &kubevirtv1.VirtualMachine{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "kubevirt.io/v1", Kind: resources.VirtualMachineType},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: kubevirtv1.VirtualMachineSpec{
+			RunStrategy: &strategy,
+			Template:    &kubevirtv1.VirtualMachineInstanceTemplateSpec{Spec: instance.Spec},
+		},
+	}
+}
+
+func testQueuedVirtualMachine(namespace, name string, cores uint32, queuedAt string) *kubevirtv1.VirtualMachine {
+	vm := testWindowsVirtualMachine(namespace, name, cores, kubevirtv1.RunStrategyHalted)
+	vm.Labels = map[string]string{resources.QueuedLabelKey: resources.QueuedLabelValue}
+	vm.Annotations = map[string]string{
+		resources.QueuedAtAnnotation:          queuedAt,
+		resources.QueuedRunStrategyAnnotation: string(kubevirtv1.RunStrategyAlways),
+	}
+
+	return vm
+}
+
+func TestWebhook_queuePatches(t *testing.T) {
+	t.Parallel()
+
+	const queuedAt = "2024-01-01T00:00:00Z"
+
+	started := time.Now().UTC().Truncate(time.Second)
+
+	running := true
+	legacy := testWindowsVirtualMachine("team-a", "vm-2", 4, kubevirtv1.RunStrategyAlways)
+	legacy.Spec.RunStrategy, legacy.Spec.Running = nil, &running
+
+	backdated := testWindowsVirtualMachine("team-a", "vm-2", 4, kubevirtv1.RunStrategyAlways)
+	backdated.Annotations = map[string]string{resources.QueuedAtAnnotation: "1970-01-01T00:00:00Z"}
+
+	backdatedUpdate := backdated.DeepCopy()
+
+	highPriority := testQueuedVirtualMachine("team-a", "vm-2", 2, queuedAt)
+	highPriority.Spec.Template.Spec.PriorityClassName = "high"
+
+	halted := []patchOperation{{Op: "add", Path: "/spec/runStrategy", Value: kubevirtv1.RunStrategyHalted}}
+
+	tests := []struct {
+		name             string
+		disabled         bool
+		order            resources.QueueOrder
+		queued           []runtime.Object
+		vm               *kubevirtv1.VirtualMachine
+		oldVM            *kubevirtv1.VirtualMachine
+		want             []patchOperation
+		wantQueued       bool
+		wantQueuedAt     string
+		wantRunStrategy  string
+		wantRemovedQueue bool
+	}{
+		{
+			name:     "ensure a virtual machine is not queued when queueing is disabled",
+			disabled: true,
+			vm:       testWindowsVirtualMachine("team-a", "vm-2", 4, kubevirtv1.RunStrategyAlways),
+			want:     []patchOperation{},
+		},
+		{
+			name:            "ensure a virtual machine which exceeds the capacity is queued halted",
+			vm:              testWindowsVirtualMachine("team-a", "vm-2", 4, kubevirtv1.RunStrategyAlways),
+			want:            halted,
+			wantQueued:      true,
+			wantRunStrategy: string(kubevirtv1.RunStrategyAlways),
+		},
+		{
+			name:            "ensure a virtual machine which exceeds the capacity keeps its original run strategy",
+			vm:              testWindowsVirtualMachine("team-a", "vm-2", 4, kubevirtv1.RunStrategyRerunOnFailure),
+			want:            halted,
+			wantQueued:      true,
+			wantRunStrategy: string(kubevirtv1.RunStrategyRerunOnFailure),
+		},
+		{
+			name: "ensure a virtual machine which is started by the running field is queued halted",
+			vm:   legacy,
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/runStrategy", Value: kubevirtv1.RunStrategyHalted},
+				{Op: "remove", Path: "/spec/running"},
+			},
+			wantQueued:      true,
+			wantRunStrategy: string(kubevirtv1.RunStrategyAlways),
+		},
+		{
+			name:             "ensure a virtual machine which fits the capacity has any queue metadata removed",
+			vm:               testWindowsVirtualMachine("team-a", "vm-2", 2, kubevirtv1.RunStrategyAlways),
+			want:             []patchOperation{},
+			wantRemovedQueue: true,
+		},
+		{
+			name:            "ensure a virtual machine which fits the capacity is queued behind others",
+			queued:          []runtime.Object{testQueuedVirtualMachine("team-b", "vm-3", 2, queuedAt)},
+			vm:              testWindowsVirtualMachine("team-a", "vm-2", 2, kubevirtv1.RunStrategyAlways),
+			want:            halted,
+			wantQueued:      true,
+			wantRunStrategy: string(kubevirtv1.RunStrategyAlways),
+		},
+		{
+			name:             "ensure a virtual machine started from the front of the queue is not queued behind others",
+			queued:           []runtime.Object{testQueuedVirtualMachine("team-b", "vm-3", 2, "2025-01-01T00:00:00Z")},
+			vm:               testWindowsVirtualMachine("team-a", "vm-2", 2, kubevirtv1.RunStrategyAlways),
+			oldVM:            testQueuedVirtualMachine("team-a", "vm-2", 2, queuedAt),
+			want:             []patchOperation{},
+			wantRemovedQueue: true,
+		},
+		{
+			name:            "ensure a virtual machine started from behind others in the queue remains queued",
+			queued:          []runtime.Object{testQueuedVirtualMachine("team-b", "vm-3", 2, "2023-01-01T00:00:00Z")},
+			vm:              testWindowsVirtualMachine("team-a", "vm-2", 2, kubevirtv1.RunStrategyAlways),
+			oldVM:           testQueuedVirtualMachine("team-a", "vm-2", 2, queuedAt),
+			want:            halted,
+			wantQueued:      true,
+			wantQueuedAt:    queuedAt,
+			wantRunStrategy: string(kubevirtv1.RunStrategyAlways),
+		},
+		{
+			name:             "ensure a virtual machine started from the front of a priority queue is not queued behind others",
+			order:            resources.QueueOrderPriority,
+			queued:           []runtime.Object{testQueuedVirtualMachine("team-b", "vm-3", 2, "2023-01-01T00:00:00Z")},
+			vm:               testWindowsVirtualMachine("team-a", "vm-2", 2, kubevirtv1.RunStrategyAlways),
+			oldVM:            highPriority,
+			want:             []patchOperation{},
+			wantRemovedQueue: true,
+		},
+		{
+			name:            "ensure a virtual machine which is queued again keeps its original position",
+			vm:              testWindowsVirtualMachine("team-a", "vm-2", 4, kubevirtv1.RunStrategyAlways),
+			oldVM:           testQueuedVirtualMachine("team-a", "vm-2", 4, queuedAt),
+			want:            halted,
+			wantQueued:      true,
+			wantQueuedAt:    queuedAt,
+			wantRunStrategy: string(kubevirtv1.RunStrategyAlways),
+		},
+		{
+			name:            "ensure a virtual machine which is created with a backdated queued time is queued now",
+			vm:              backdated,
+			want:            halted,
+			wantQueued:      true,
+			wantRunStrategy: string(kubevirtv1.RunStrategyAlways),
+		},
+		{
+			name:            "ensure a virtual machine which is queued again with a backdated queued time keeps its original position",
+			vm:              backdatedUpdate,
+			oldVM:           testQueuedVirtualMachine("team-a", "vm-2", 4, queuedAt),
+			want:            halted,
+			wantQueued:      true,
+			wantQueuedAt:    queuedAt,
+			wantRunStrategy: string(kubevirtv1.RunStrategyAlways),
+		},
+		{
+			name:  "ensure a virtual machine which is already running is not queued",
+			vm:    testWindowsVirtualMachine("team-a", "vm-2", 4, kubevirtv1.RunStrategyAlways),
+			oldVM: testWindowsVirtualMachine("team-a", "vm-2", 4, kubevirtv1.RunStrategyAlways),
+			want:  []patchOperation{},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			wh := newTestAPIServer(t,
+				[]runtime.Object{
+					testNode("node-1", "windows", 8),
+					&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "high"}, Value: 100},
+				},
+				[]runtime.Object{testWindowsInstance("team-a", "vm-1", "node-1", 6)},
+			).webhook
+			wh.Context = context.Background()
+			wh.QueueVirtualMachines = !tt.disabled
+			wh.QueueOrder = tt.order
+
+			// a virtual machine which was queued is listed alongside the others which are queued
+			queued := tt.queued
+			if tt.oldVM != nil && tt.oldVM.Labels[resources.QueuedLabelKey] == resources.QueuedLabelValue {
+				queued = append([]runtime.Object{tt.oldVM}, queued...)
+			}
+
+			virtClient := kubevirtfake.NewSimpleClientset(queued...)
+			wh.VirtClient.(*kubecli.MockKubevirtClient).EXPECT().VirtualMachine(gomock.Any()).DoAndReturn(
+				func(namespace string) kubecli.VirtualMachineInterface {
+					return virtClient.KubevirtV1().VirtualMachines(namespace)
+				},
+			).AnyTimes()
+
+			request := &admissionv1.AdmissionRequest{
+				UID:       "test-uid",
+				Kind:      metav1.GroupVersionKind{Group: "kubevirt.io", Version: "v1", Kind: resources.VirtualMachineType},
+				Namespace: tt.vm.Namespace,
+				Operation: admissionv1.Create,
+			}
+			request.Object.Raw, _ = json.Marshal(tt.vm)
+
+			if tt.oldVM != nil {
+				request.Operation = admissionv1.Update
+				request.OldObject.Raw, _ = json.Marshal(tt.oldVM)
+			}
+
+			review, _ := json.Marshal(admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+				Request:  request,
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader(string(review)))
+
+			op, err := newOperation(httptest.NewRecorder(), r, mutateScope)
+			if err != nil {
+				t.Fatalf("newOperation() error = %v", err)
+			}
+
+			changes, got, err := wh.queuePatches(r.Context(), op)
+			if err != nil {
+				t.Fatalf("queuePatches() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queuePatches() patches = %+v, want %+v", got, tt.want)
+			}
+
+			if gotQueued := changes != nil && changes.setLabels[resources.QueuedLabelKey] == resources.QueuedLabelValue; gotQueued != tt.wantQueued {
+				t.Errorf("queuePatches() changes = %+v, want queued %v", changes, tt.wantQueued)
+			}
+
+			if tt.wantQueued {
+				if got := changes.setAnnotations[resources.QueuedRunStrategyAnnotation]; got != tt.wantRunStrategy {
+					t.Errorf("queuePatches() run strategy annotation = %q, want %q", got, tt.wantRunStrategy)
+				}
+
+				// a virtual machine which was not previously queued is queued now
+				gotQueuedAt := changes.setAnnotations[resources.QueuedAtAnnotation]
+				if at, err := time.Parse(time.RFC3339, gotQueuedAt); tt.wantQueuedAt == "" && (err != nil || at.Before(started)) {
+					t.Errorf("queuePatches() queued at annotation = %q, want a time after %s", gotQueuedAt, started)
+				} else if tt.wantQueuedAt != "" && gotQueuedAt != tt.wantQueuedAt {
+					t.Errorf("queuePatches() queued at annotation = %q, want %q", gotQueuedAt, tt.wantQueuedAt)
+				}
+
+				if len(op.response.warnings) != 1 {
+					t.Errorf("queuePatches() warnings = %v, want a warning that the virtual machine was queued", op.response.warnings)
+				}
+			}
+
+			if gotRemoved := changes != nil && len(changes.removeLabels) > 0; gotRemoved != tt.wantRemovedQueue {
+				t.Errorf("queuePatches() changes = %+v, want queue metadata removed %v", changes, tt.wantRemovedQueue)
+			}
+		})
+	}
+}
+
+func Test_restoredQueueMetadata(t *testing.T) {
+	t.Parallel()
+
+	const queuedAt = "2024-01-01T00:00:00Z"
+
+	forged := testQueuedVirtualMachine("team-a", "vm-1", 2, "1970-01-01T00:00:00Z")
+
+	unlabeled := forged.DeepCopy()
+	unlabeled.Labels = nil
+
+	tests := []struct {
+		name  string
+		vm    *kubevirtv1.VirtualMachine
+		oldVM *kubevirtv1.VirtualMachine
+		want  *metadataChanges
+	}{
+		{
+			name: "ensure a virtual machine without queue metadata is not changed",
+			vm:   testWindowsVirtualMachine("team-a", "vm-1", 2, kubevirtv1.RunStrategyHalted),
+		},
+		{
+			name: "ensure queue metadata set on create is removed",
+			vm:   forged,
+			want: &metadataChanges{
+				setLabels:         map[string]string{},
+				setAnnotations:    map[string]string{},
+				removeLabels:      []string{resources.QueuedLabelKey},
+				removeAnnotations: []string{resources.QueuedAtAnnotation},
+			},
+		},
+		{
+			name:  "ensure queue metadata added on update is removed",
+			vm:    forged,
+			oldVM: testWindowsVirtualMachine("team-a", "vm-1", 2, kubevirtv1.RunStrategyHalted),
+			want: &metadataChanges{
+				setLabels:         map[string]string{},
+				setAnnotations:    map[string]string{},
+				removeLabels:      []string{resources.QueuedLabelKey},
+				removeAnnotations: []string{resources.QueuedAtAnnotation},
+			},
+		},
+		{
+			name:  "ensure a changed queued time is restored",
+			vm:    forged,
+			oldVM: testQueuedVirtualMachine("team-a", "vm-1", 2, queuedAt),
+			want: &metadataChanges{
+				setLabels:      map[string]string{},
+				setAnnotations: map[string]string{resources.QueuedAtAnnotation: queuedAt},
+			},
+		},
+		{
+			name:  "ensure unchanged queue metadata is not changed",
+			vm:    testQueuedVirtualMachine("team-a", "vm-1", 2, queuedAt),
+			oldVM: testQueuedVirtualMachine("team-a", "vm-1", 2, queuedAt),
+		},
+		{
+			name:  "ensure a removed queued label is not restored",
+			vm:    unlabeled,
+			oldVM: testQueuedVirtualMachine("team-a", "vm-1", 2, queuedAt),
+			want: &metadataChanges{
+				setLabels:      map[string]string{},
+				setAnnotations: map[string]string{resources.QueuedAtAnnotation: queuedAt},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := restoredQueueMetadata(tt.vm, tt.oldVM); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("restoredQueueMetadata() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
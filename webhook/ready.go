@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// readinessListMaxAge is the maximum age of the last successful list of nodes and virtual machine instances
+	// before the readiness check lists them itself to verify connectivity to the API.
+	readinessListMaxAge = 30 * time.Second
+
+	// readinessTimeout is the maximum amount of time that the readiness checks may take.
+	readinessTimeout = 3 * time.Second
+)
+
+// readinessState tracks the results of the most recent requests to the API so that readiness may be determined
+// without listing from the API on every readiness check.
+type readinessState struct {
+	lastNodeList     atomic.Int64
+	lastInstanceList atomic.Int64
+	nodeCount        atomic.Int64
+}
+
+// recordNodeList records a successful list of nodes and the number of nodes in the filtered node pool.
+func (state *readinessState) recordNodeList(count int) {
+	state.nodeCount.Store(int64(count))
+	state.lastNodeList.Store(time.Now().UnixNano())
+}
+
+// recordInstanceList records a successful list of virtual machine instances.
+func (state *readinessState) recordInstanceList() {
+	state.lastInstanceList.Store(time.Now().UnixNano())
+}
+
+// ReadinessCheck represents a named check which must pass for the webhook to be ready.
+type ReadinessCheck struct {
+	Name  string
+	Check func(context.Context) error
+}
+
+// ReadinessChecks returns the checks which must pass for the webhook to be able to make decisions.
+func (wh *webhook) ReadinessChecks() []ReadinessCheck {
+	return []ReadinessCheck{
+		{Name: "api", Check: wh.checkAPI},
+		{Name: "nodes", Check: wh.checkNodes},
+	}
+}
+
+// checkAPI verifies that nodes and virtual machine instances have recently been listed successfully, listing them
+// if they have not.
+func (wh *webhook) checkAPI(ctx context.Context) error {
+	if isStale(wh.readiness.lastNodeList.Load()) {
+		if _, err := wh.getFilteredNodes(ctx); err != nil {
+			return err
+		}
+	}
+
+	if isStale(wh.readiness.lastInstanceList.Load()) {
+		if _, err := wh.getFilteredVirtualMachineInstances(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkNodes verifies that the node pool used to calculate capacity is not empty.  Without nodes in the pool every
+// windows request would be denied.
+func (wh *webhook) checkNodes(context.Context) error {
+	if wh.readiness.nodeCount.Load() == 0 {
+		return errors.New("no nodes found matching the node filter")
+	}
+
+	return nil
+}
+
+// isStale returns if a recorded time is older than the maximum age for readiness.
+func isStale(unixNano int64) bool {
+	return time.Since(time.Unix(0, unixNano)) > readinessListMaxAge
+}
@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// testCertificates is a certificate provider whose readiness is fixed.
+type testCertificates struct {
+	err error
+}
+
+func (certificates testCertificates) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return &tls.Certificate{}, certificates.err
+}
+
+func (certificates testCertificates) Ready() error {
+	return certificates.err
+}
+
+func TestServer_ReadyZ(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		nodes            []runtime.Object
+		failNodeList     bool
+		certificateErr   error
+		shuttingDown     bool
+		wantStatus       int
+		wantFailedChecks map[string]string
+	}{
+		{
+			name:       "ensure a webhook which can make decisions is ready",
+			nodes:      []runtime.Object{testNode("node-1", "windows", 8)},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:         "ensure a failed list of nodes is not ready",
+			nodes:        []runtime.Object{testNode("node-1", "windows", 8)},
+			failNodeList: true,
+			wantStatus:   http.StatusServiceUnavailable,
+			wantFailedChecks: map[string]string{
+				"api":   "failed to list nodes; api server unavailable",
+				"nodes": "no nodes found matching the node filter",
+			},
+		},
+		{
+			name:       "ensure an empty node pool is not ready",
+			nodes:      []runtime.Object{testNode("node-1", "linux", 8)},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailedChecks: map[string]string{
+				"nodes": "no nodes found matching the node filter",
+			},
+		},
+		{
+			name:           "ensure a certificate which is not ready is not ready",
+			nodes:          []runtime.Object{testNode("node-1", "windows", 8)},
+			certificateErr: errors.New("certificate has not been loaded"),
+			wantStatus:     http.StatusServiceUnavailable,
+			wantFailedChecks: map[string]string{
+				"certificate": "certificate has not been loaded",
+			},
+		},
+		{
+			name:         "ensure a server which is shutting down is not ready",
+			nodes:        []runtime.Object{testNode("node-1", "windows", 8)},
+			shuttingDown: true,
+			wantStatus:   http.StatusServiceUnavailable,
+			wantFailedChecks: map[string]string{
+				"shutdown": "server is shutting down",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			wh := newTestAPIServer(t, tt.nodes, nil).webhook
+
+			if tt.failNodeList {
+				wh.KubeClient.(*fake.Clientset).PrependReactor("list", "nodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("api server unavailable")
+				})
+			}
+
+			s := NewServer(wh, testCertificates{err: tt.certificateErr})
+			s.shuttingDown.Store(tt.shuttingDown)
+
+			recorder := httptest.NewRecorder()
+			s.ReadyZ(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("ReadyZ() status = %d, want %d; body %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			got := readinessResponse{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+				t.Fatalf("ReadyZ() returned invalid response; %v", err)
+			}
+
+			want := readinessResponse{Ready: len(tt.wantFailedChecks) == 0}
+			for _, name := range []string{"shutdown", "certificate", "api", "nodes"} {
+				message, failed := tt.wantFailedChecks[name]
+				want.Checks = append(want.Checks, readinessResult{Name: name, Ready: !failed, Message: message})
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ReadyZ() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,379 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/metrics"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/pkg/client"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/redact"
+)
+
+const (
+	// EnvRecorder enables recording of validation requests and their responses for later replay, either to a JSONL
+	// file (file) or to a bounded in-memory ring buffer (memory).
+	EnvRecorder = "WEBHOOK_RECORDER"
+
+	// EnvRecorderPath is the file that recordings are appended to when recording to a file.
+	EnvRecorderPath = "WEBHOOK_RECORDER_PATH"
+
+	// EnvRecorderMaxBytes is the maximum size of the recordings.  Recording to a file stops once the file reaches
+	// this size, while the ring buffer evicts the oldest recordings.
+	EnvRecorderMaxBytes = "WEBHOOK_RECORDER_MAX_BYTES"
+
+	// EnvRecorderBufferSize is the maximum number of recordings held by the ring buffer.
+	EnvRecorderBufferSize = "WEBHOOK_RECORDER_BUFFER_SIZE"
+
+	// EnvRecorderSampleRate is the fraction of requests which are recorded, between 0 and 1.
+	EnvRecorderSampleRate = "WEBHOOK_RECORDER_SAMPLE_RATE"
+
+	// EnvRecorderRedact is the comma-separated list of redaction rules which are applied to recordings.  See the
+	// redact package for the format of the rules.
+	EnvRecorderRedact = "WEBHOOK_RECORDER_REDACT"
+
+	DefaultRecorderPath           = "/var/lib/webhook/admission-reviews.jsonl"
+	DefaultRecorderFileMaxBytes   = 64 * 1024 * 1024
+	DefaultRecorderMemoryMaxBytes = 8 * 1024 * 1024
+	DefaultRecorderBufferSize     = 1000
+	DefaultRecorderSampleRate     = 1.0
+
+	// recordingsPath is the path of the API which returns the recordings held by the ring buffer.
+	recordingsPath = "/v1/recordings"
+)
+
+// RecorderMode represents where recordings are kept.
+type RecorderMode string
+
+const (
+	RecorderModeFile   RecorderMode = "file"
+	RecorderModeMemory RecorderMode = "memory"
+)
+
+// recorder records validation requests along with their responses as AdmissionReviews, with the response of the
+// webhook in place of the response, so that the recordings may be replayed and compared against later.
+type recorder struct {
+	mode       RecorderMode
+	path       string
+	maxBytes   int64
+	bufferSize int
+	sampleRate float64
+	redactor   *redact.Redactor
+
+	// sample returns a random number between 0 and 1 which determines whether a request is recorded.
+	sample func() float64
+
+	mu   sync.Mutex
+	size int64
+
+	// file and full are used when recording to a file.  The recorder is full once the file reaches its maximum size.
+	file *os.File
+	full bool
+
+	// buffer is used when recording to the ring buffer and holds the recordings oldest first.
+	buffer []bufferedRecording
+}
+
+// bufferedRecording represents a recording held by the ring buffer, along with the namespace of its request so that
+// it is only returned to users who may see the objects in that namespace.
+type bufferedRecording struct {
+	namespace string
+	data      []byte
+}
+
+// newRecorder returns a new instance of a recorder given its configuration, or nil if recording is disabled.  The
+// defaults are used for any values which are unset.
+func newRecorder(mode, path, maxBytes, bufferSize, sampleRate, redactRules string) (*recorder, error) {
+	if mode == "" {
+		return nil, nil
+	}
+
+	r := &recorder{
+		mode:       RecorderMode(mode),
+		path:       path,
+		bufferSize: DefaultRecorderBufferSize,
+		sampleRate: DefaultRecorderSampleRate,
+		sample:     rand.Float64,
+	}
+
+	switch r.mode {
+	case RecorderModeFile:
+		r.maxBytes = DefaultRecorderFileMaxBytes
+		if r.path == "" {
+			r.path = DefaultRecorderPath
+		}
+	case RecorderModeMemory:
+		r.maxBytes = DefaultRecorderMemoryMaxBytes
+	default:
+		return nil, fmt.Errorf("invalid value [%s] for [%s]; must be one of [%s %s]", mode, EnvRecorder, RecorderModeFile, RecorderModeMemory)
+	}
+
+	if maxBytes != "" {
+		value, err := strconv.ParseInt(maxBytes, 10, 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid value [%s] for [%s]; must be a positive number", maxBytes, EnvRecorderMaxBytes)
+		}
+
+		r.maxBytes = value
+	}
+
+	if bufferSize != "" {
+		value, err := strconv.Atoi(bufferSize)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid value [%s] for [%s]; must be a positive number", bufferSize, EnvRecorderBufferSize)
+		}
+
+		r.bufferSize = value
+	}
+
+	if sampleRate != "" {
+		value, err := strconv.ParseFloat(sampleRate, 64)
+		if err != nil || value < 0 || value > 1 {
+			return nil, fmt.Errorf("invalid value [%s] for [%s]; must be between 0 and 1", sampleRate, EnvRecorderSampleRate)
+		}
+
+		r.sampleRate = value
+	}
+
+	redactor, err := redact.NewRedactor(redactRules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for [%s]; %w", EnvRecorderRedact, err)
+	}
+
+	r.redactor = redactor
+
+	if r.mode == RecorderModeFile {
+		if r.file, err = os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600); err != nil {
+			return nil, fmt.Errorf("failed to open recorder file [%s]; %w", r.path, err)
+		}
+
+		info, err := r.file.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat recorder file [%s]; %w", r.path, err)
+		}
+
+		r.size = info.Size()
+	}
+
+	return r, nil
+}
+
+// sampled returns if a request should be recorded.
+func (r *recorder) sampled() bool {
+	return r.sampleRate >= 1 || r.sample() < r.sampleRate
+}
+
+// record records an AdmissionReview along with the AdmissionReview that was sent in response.  The recording is
+// redacted before it is kept.  It returns if the recording was kept, which it is not if it would exceed the maximum
+// size of the recordings.
+func (r *recorder) record(review, response []byte) (bool, error) {
+	recording, namespace, err := r.recording(review, response)
+	if err != nil {
+		metrics.RecorderRecords.WithLabelValues("failed").Inc()
+
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mode == RecorderModeFile {
+		return r.appendFile(recording)
+	}
+
+	return r.appendBuffer(bufferedRecording{namespace: namespace, data: recording}), nil
+}
+
+// recording returns the redacted recording of an AdmissionReview and its response as a single line, along with the
+// namespace of the request.
+func (r *recorder) recording(review, response []byte) ([]byte, string, error) {
+	recording := map[string]any{}
+
+	decoder := json.NewDecoder(bytes.NewReader(review))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&recording); err != nil {
+		return nil, "", fmt.Errorf("failed to decode admission review; %w", err)
+	}
+
+	responseReview := map[string]json.RawMessage{}
+	if err := json.Unmarshal(response, &responseReview); err != nil {
+		return nil, "", fmt.Errorf("failed to decode admission review response; %w", err)
+	}
+
+	recording["response"] = responseReview["response"]
+
+	var namespace string
+
+	if request, ok := recording["request"].(map[string]any); ok {
+		namespace, _ = request["namespace"].(string)
+
+		for _, key := range []string{"object", "oldObject"} {
+			stripRecordedMetadata(request[key])
+		}
+	}
+
+	data, err := json.Marshal(recording)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode recording; %w", err)
+	}
+
+	if data, err = r.redactor.Redact(data); err != nil {
+		return nil, "", err
+	}
+
+	return append(data, '\n'), namespace, nil
+}
+
+// stripRecordedMetadata removes the metadata of an object which is never recorded.  The last applied configuration
+// holds a copy of the entire object, which would include everything that redaction removes, while the managed
+// fields are large and have no effect on admission.
+func stripRecordedMetadata(object any) {
+	objectMap, ok := object.(map[string]any)
+	if !ok {
+		return
+	}
+
+	metadata, ok := objectMap["metadata"].(map[string]any)
+	if !ok {
+		return
+	}
+
+	delete(metadata, "managedFields")
+
+	if annotations, ok := metadata["annotations"].(map[string]any); ok {
+		delete(annotations, corev1.LastAppliedConfigAnnotation)
+	}
+}
+
+// appendFile appends a recording to the file unless it would exceed the maximum size.
+func (r *recorder) appendFile(recording []byte) (bool, error) {
+	if r.full || r.size+int64(len(recording)) > r.maxBytes {
+		r.full = true
+		metrics.RecorderRecords.WithLabelValues("dropped").Inc()
+
+		return false, nil
+	}
+
+	written, err := r.file.Write(recording)
+	r.size += int64(written)
+
+	if err != nil {
+		metrics.RecorderRecords.WithLabelValues("failed").Inc()
+
+		return false, fmt.Errorf("failed to write recording to [%s]; %w", r.path, err)
+	}
+
+	metrics.RecorderRecords.WithLabelValues("recorded").Inc()
+
+	return true, nil
+}
+
+// appendBuffer appends a recording to the ring buffer, evicting the oldest recordings to stay within the maximum
+// number and size of the recordings.  A recording which is larger than the maximum size on its own is dropped.
+func (r *recorder) appendBuffer(recording bufferedRecording) bool {
+	if int64(len(recording.data)) > r.maxBytes {
+		metrics.RecorderRecords.WithLabelValues("dropped").Inc()
+
+		return false
+	}
+
+	for len(r.buffer) > 0 && (len(r.buffer) >= r.bufferSize || r.size+int64(len(recording.data)) > r.maxBytes) {
+		r.size -= int64(len(r.buffer[0].data))
+		r.buffer[0] = bufferedRecording{}
+		r.buffer = r.buffer[1:]
+	}
+
+	r.buffer = append(r.buffer, recording)
+	r.size += int64(len(recording.data))
+
+	metrics.RecorderRecords.WithLabelValues("recorded").Inc()
+
+	return true
+}
+
+// recordedNamespaces returns the namespaces of the requests of the recordings held by the ring buffer.
+func (r *recorder) recordedNamespaces() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	namespaces := make([]string, 0, len(r.buffer))
+	for _, recording := range r.buffer {
+		namespaces = append(namespaces, recording.namespace)
+	}
+
+	return namespaces
+}
+
+// recordings returns the recordings held by the ring buffer whose requests are in the visible namespaces as JSONL,
+// oldest first.  All recordings are returned if the visible namespaces are nil.
+func (r *recorder) recordings(visible map[string]bool) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := []byte{}
+
+	for _, recording := range r.buffer {
+		if visible == nil || visible[recording.namespace] {
+			result = append(result, recording.data...)
+		}
+	}
+
+	return result
+}
+
+// close closes the file that recordings are appended to, if any.
+func (r *recorder) close() error {
+	if r == nil || r.file == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
+
+// recordingWriter captures the response written by an admission handler so that it may be recorded.
+type recordingWriter struct {
+	http.ResponseWriter
+
+	body bytes.Buffer
+}
+
+// Write writes the response and captures it.
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+
+	return w.ResponseWriter.Write(data)
+}
+
+// Recordings returns the recordings held by the ring buffer as JSONL, oldest first.  It is not found unless the
+// recorder is recording to the ring buffer.  Only the recordings of requests in namespaces where the user may get
+// virtual machine instances are returned, as recordings contain entire objects.
+func (wh *webhook) Recordings(w http.ResponseWriter, r *http.Request, userInfo authenticationv1.UserInfo) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, client.ErrorResponse{Error: "only GET is supported"})
+		return
+	}
+
+	if wh.recorder == nil || wh.recorder.mode != RecorderModeMemory {
+		writeJSON(w, http.StatusNotFound, client.ErrorResponse{Error: "recordings are not held in memory"})
+
+		return
+	}
+
+	visible := wh.visibleNamespaces(r.Context(), userInfo, "get", wh.recorder.recordedNamespaces())
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	w.Write(wh.recorder.recordings(visible))
+}
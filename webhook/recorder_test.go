@@ -0,0 +1,354 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/redact"
+)
+
+func Test_newRecorder(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		mode       string
+		maxBytes   string
+		bufferSize string
+		sampleRate string
+		redact     string
+		wantNil    bool
+		wantErr    bool
+	}{
+		{
+			name:    "ensure recording is disabled by default",
+			wantNil: true,
+		},
+		{
+			name: "ensure the ring buffer uses the defaults",
+			mode: "memory",
+		},
+		{
+			name:    "ensure an invalid mode returns an error",
+			mode:    "syslog",
+			wantErr: true,
+		},
+		{
+			name:     "ensure an invalid maximum size returns an error",
+			mode:     "memory",
+			maxBytes: "0",
+			wantErr:  true,
+		},
+		{
+			name:       "ensure an invalid buffer size returns an error",
+			mode:       "memory",
+			bufferSize: "many",
+			wantErr:    true,
+		},
+		{
+			name:       "ensure a sample rate above one returns an error",
+			mode:       "memory",
+			sampleRate: "1.5",
+			wantErr:    true,
+		},
+		{
+			name:    "ensure an invalid redaction rule returns an error",
+			mode:    "memory",
+			redact:  "a.b.c",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := newRecorder(tt.mode, "", tt.maxBytes, tt.bufferSize, tt.sampleRate, tt.redact)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newRecorder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && (got == nil) != tt.wantNil {
+				t.Errorf("newRecorder() = %v, wantNil %v", got, tt.wantNil)
+			}
+		})
+	}
+}
+
+func testRecorderReview(uid string) ([]byte, []byte) {
+	review := fmt.Sprintf(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":%q,`+
+		`"object":{"spec":{"volumes":[{"cloudInitNoCloud":{"userData":"password: secret"}}]}}}}`, uid)
+	response := fmt.Sprintf(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","response":{"uid":%q,"allowed":true}}`, uid)
+
+	return []byte(review), []byte(response)
+}
+
+func TestRecorder_record(t *testing.T) {
+	t.Parallel()
+
+	review, response := testRecorderReview("uid-1")
+
+	r, err := newRecorder("memory", "", "", "", "", redact.DefaultRules)
+	if err != nil {
+		t.Fatalf("newRecorder() error = %v", err)
+	}
+
+	if _, err := r.record(review, response); err != nil {
+		t.Fatalf("record() error = %v", err)
+	}
+
+	got := string(r.recordings(nil))
+
+	if strings.Contains(got, "password: secret") {
+		t.Errorf("recordings() = %s, want cloud-init user data redacted", got)
+	}
+
+	if !strings.Contains(got, `"response":{"allowed":true,"uid":"uid-1"}`) {
+		t.Errorf("recordings() = %s, want the response of the webhook", got)
+	}
+
+	if !strings.HasSuffix(got, "\n") || strings.Count(got, "\n") != 1 {
+		t.Errorf("recordings() = %q, want a single line", got)
+	}
+
+	if _, err := r.record([]byte("not json"), response); err == nil {
+		t.Errorf("record() error = nil, want an error for an invalid review")
+	}
+}
+
+func TestRecorder_recording(t *testing.T) {
+	t.Parallel()
+
+	review := []byte(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"uid-1",` +
+		`"namespace":"team-a","object":{"metadata":{"generation":9007199254740993,"managedFields":[{"manager":"kubectl"}],` +
+		`"annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{\"userData\":\"hunter2\"}","app":"vm-1"}}},` +
+		`"oldObject":{"metadata":{"managedFields":[{"manager":"kubectl"}],` +
+		`"annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{\"userData\":\"hunter2\"}"}}}}}`)
+	_, response := testRecorderReview("uid-1")
+
+	r, err := newRecorder("memory", "", "", "", "", "none")
+	if err != nil {
+		t.Fatalf("newRecorder() error = %v", err)
+	}
+
+	recording, namespace, err := r.recording(review, response)
+	if err != nil {
+		t.Fatalf("recording() error = %v", err)
+	}
+
+	if namespace != "team-a" {
+		t.Errorf("recording() namespace = %s, want team-a", namespace)
+	}
+
+	for _, missing := range []string{"hunter2", "last-applied-configuration", "managedFields"} {
+		if strings.Contains(string(recording), missing) {
+			t.Errorf("recording() = %s, want %s removed", recording, missing)
+		}
+	}
+
+	for _, want := range []string{`"app":"vm-1"`, `"generation":9007199254740993`} {
+		if !strings.Contains(string(recording), want) {
+			t.Errorf("recording() = %s, want %s kept", recording, want)
+		}
+	}
+}
+
+func TestRecorder_ringBuffer(t *testing.T) {
+	t.Parallel()
+
+	review, response := testRecorderReview("uid-0")
+
+	r, err := newRecorder("memory", "", "", "3", "", "none")
+	if err != nil {
+		t.Fatalf("newRecorder() error = %v", err)
+	}
+
+	recording, _, err := r.recording(review, response)
+	if err != nil {
+		t.Fatalf("recording() error = %v", err)
+	}
+
+	// allow room for two recordings, plus the longer uid of the last recording in both its request and response
+	r.maxBytes = int64(2*len(recording) + 2)
+
+	for i := 0; i < 5; i++ {
+		review, response := testRecorderReview(fmt.Sprintf("uid-%d", i))
+		if i == 4 {
+			review, response = testRecorderReview("uid-44")
+		}
+
+		if kept, err := r.record(review, response); err != nil || !kept {
+			t.Fatalf("record() = %v, %v, want recording kept", kept, err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(r.recordings(nil))), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("recordings() returned %d recordings, want 2", len(lines))
+	}
+
+	if !strings.Contains(lines[0], `"uid-3"`) || !strings.Contains(lines[1], `"uid-44"`) {
+		t.Errorf("recordings() = %v, want the newest recordings oldest first", lines)
+	}
+
+	if r.size != int64(len(lines[0])+len(lines[1])+2) {
+		t.Errorf("size = %d, want the size of the held recordings", r.size)
+	}
+
+	r.maxBytes = 10
+	if kept, _ := r.record(review, response); kept {
+		t.Errorf("record() kept a recording larger than the maximum size")
+	}
+}
+
+func TestRecorder_file(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "reviews.jsonl")
+	review, response := testRecorderReview("uid-1")
+
+	r, err := newRecorder("file", path, "", "", "", "")
+	if err != nil {
+		t.Fatalf("newRecorder() error = %v", err)
+	}
+
+	recording, _, err := r.recording(review, response)
+	if err != nil {
+		t.Fatalf("recording() error = %v", err)
+	}
+
+	// allow room for exactly two recordings
+	r.maxBytes = int64(2 * len(recording))
+
+	for i, want := range []bool{true, true, false, false} {
+		if kept, err := r.record(review, response); err != nil || kept != want {
+			t.Errorf("record() %d = %v, %v, want %v", i, kept, err, want)
+		}
+	}
+
+	if err := r.close(); err != nil {
+		t.Fatalf("close() error = %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read recordings; %v", err)
+	}
+
+	if !bytes.Equal(got, append(recording, recording...)) {
+		t.Errorf("file = %s, want two recordings", got)
+	}
+
+	// an existing file counts towards the maximum size
+	r, err = newRecorder("file", path, fmt.Sprint(len(got)), "", "", "")
+	if err != nil {
+		t.Fatalf("newRecorder() error = %v", err)
+	}
+	defer r.close()
+
+	if kept, _ := r.record(review, response); kept {
+		t.Errorf("record() appended to a full file")
+	}
+}
+
+func TestRecorder_sampled(t *testing.T) {
+	t.Parallel()
+
+	r, err := newRecorder("memory", "", "", "", "0.25", "")
+	if err != nil {
+		t.Fatalf("newRecorder() error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		sample float64
+		want   bool
+	}{
+		{sample: 0.1, want: true},
+		{sample: 0.25, want: false},
+		{sample: 0.9, want: false},
+	} {
+		sample := tt.sample
+		r.sample = func() float64 { return sample }
+
+		if got := r.sampled(); got != tt.want {
+			t.Errorf("sampled() with sample %v = %v, want %v", tt.sample, got, tt.want)
+		}
+	}
+}
+
+func TestWebhook_Recordings(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAPIServer(t, nil, nil)
+
+	rec, err := newRecorder("memory", "", "", "", "", "")
+	if err != nil {
+		t.Fatalf("newRecorder() error = %v", err)
+	}
+
+	s.webhook.recorder = rec
+
+	for _, namespace := range []string{"team-a", "team-b", "team-a"} {
+		review, response := testRecorderReview(namespace)
+		review = bytes.Replace(review, []byte(`"request":{`), []byte(`"request":{"namespace":"`+namespace+`",`), 1)
+
+		if _, err := rec.record(review, response); err != nil {
+			t.Fatalf("record() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+		want       map[string]int
+	}{
+		{
+			name:       "ensure a user who may get instances in all namespaces sees all recordings",
+			token:      testToken,
+			wantStatus: http.StatusOK,
+			want:       map[string]int{"team-a": 2, "team-b": 1},
+		},
+		{
+			name:       "ensure a user only sees the recordings of the namespaces they may get instances in",
+			token:      testNamespaceToken,
+			wantStatus: http.StatusOK,
+			want:       map[string]int{"team-a": 2, "team-b": 0},
+		},
+		{
+			name:       "ensure a user who may not access the api is forbidden",
+			token:      testForbiddenToken,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			request := httptest.NewRequest(http.MethodGet, recordingsPath, nil)
+			request.Header.Set("Authorization", "Bearer "+tt.token)
+
+			recorder := httptest.NewRecorder()
+			s.apiHandler(s.webhook.Recordings).ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("Recordings() status = %d, want %d; body %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			for namespace, want := range tt.want {
+				if got := strings.Count(recorder.Body.String(), `"namespace":"`+namespace+`"`); got != want {
+					t.Errorf("Recordings() returned %d recordings for %s, want %d", got, namespace, want)
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,6 @@
 package webhook
 
 import (
-	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 
@@ -12,6 +10,10 @@ import (
 type request struct {
 	admissionRequest *admissionv1.AdmissionRequest
 	admissionReview  *admissionv1.AdmissionReview
+
+	// apiVersion is the version of the AdmissionReview as it was received.  The request is always converted to the
+	// internal v1 representation, but the response must be returned in the version it arrived in.
+	apiVersion string
 }
 
 // newRequest creates a new request object.
@@ -19,24 +21,22 @@ func newRequest(r *http.Request) (*request, error) {
 	// read in the request
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read request body; %w", err)
+		return nil, newAdmissionError(ErrorTypeDecode, "failed to read request body; %w", err)
 	}
 	defer r.Body.Close()
 
-	var admissionReview admissionv1.AdmissionReview
-	if err := json.Unmarshal(body, &admissionReview); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal admission review; %w", err)
+	admissionReview, apiVersion, err := decodeAdmissionReview(body)
+	if err != nil {
+		return nil, err
 	}
 
-	// we only care about create operations for now
-	// TODO: handle update in case create was bypassed somehow
-	admissionRequest := admissionReview.Request
-	if admissionRequest.Operation != admissionv1.Create {
-		return nil, fmt.Errorf("unsupported operation [%s]; only create supported", admissionRequest.Operation)
+	if admissionReview.Request == nil {
+		return nil, newAdmissionError(ErrorTypeDecode, "admission review is missing request")
 	}
 
 	return &request{
-		admissionRequest: admissionRequest,
-		admissionReview:  &admissionReview,
+		admissionRequest: admissionReview.Request,
+		admissionReview:  admissionReview,
+		apiVersion:       apiVersion,
 	}, nil
 }
@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_newRequest_roundTrip(t *testing.T) {
+	t.Parallel()
+
+	dryRun := true
+	object := runtime.RawExtension{Raw: []byte(`{"kind":"VirtualMachineInstance","metadata":{"name":"test"}}`)}
+	kind := metav1.GroupVersionKind{Group: "kubevirt.io", Version: "v1", Kind: "VirtualMachineInstance"}
+
+	tests := []struct {
+		name    string
+		review  any
+		version string
+	}{
+		{
+			name:    "ensure a v1 admission review is answered in v1",
+			version: admissionv1.SchemeGroupVersion.String(),
+			review: &admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: admissionv1.SchemeGroupVersion.String(), Kind: admissionReviewKind},
+				Request: &admissionv1.AdmissionRequest{
+					UID:       "test-uid",
+					Kind:      kind,
+					Name:      "test",
+					Namespace: "test-namespace",
+					Operation: admissionv1.Create,
+					Object:    object,
+					DryRun:    &dryRun,
+				},
+			},
+		},
+		{
+			name:    "ensure a v1beta1 admission review is answered in v1beta1",
+			version: admissionv1beta1.SchemeGroupVersion.String(),
+			review: &admissionv1beta1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: admissionv1beta1.SchemeGroupVersion.String(), Kind: admissionReviewKind},
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       "test-uid",
+					Kind:      kind,
+					Name:      "test",
+					Namespace: "test-namespace",
+					Operation: admissionv1beta1.Create,
+					Object:    object,
+					DryRun:    &dryRun,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			body, err := json.Marshal(tt.review)
+			if err != nil {
+				t.Fatalf("failed to marshal admission review; %v", err)
+			}
+
+			// decode the request into the internal representation
+			req, err := newRequest(httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+			if err != nil {
+				t.Fatalf("newRequest() error = %v", err)
+			}
+
+			if req.apiVersion != tt.version {
+				t.Errorf("newRequest() apiVersion = %s, want %s", req.apiVersion, tt.version)
+			}
+
+			got := req.admissionRequest
+			if got.UID != "test-uid" || got.Kind != kind || got.Name != "test" || got.Namespace != "test-namespace" ||
+				got.Operation != admissionv1.Create || !bytes.Equal(got.Object.Raw, object.Raw) ||
+				got.DryRun == nil || !*got.DryRun {
+				t.Errorf("newRequest() request = %+v, want fields from original review", got)
+			}
+
+			// send the response and ensure it is returned in the original version
+			recorder := httptest.NewRecorder()
+			resp := &response{allowed: false, uid: got.UID, writer: recorder, apiVersion: req.apiVersion}
+			resp.send(http.StatusForbidden, "denied")
+
+			typeMeta := metav1.TypeMeta{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &typeMeta); err != nil {
+				t.Fatalf("failed to unmarshal response type; %v", err)
+			}
+
+			if typeMeta.APIVersion != tt.version || typeMeta.Kind != admissionReviewKind {
+				t.Errorf("response.send() type = %+v, want %s %s", typeMeta, tt.version, admissionReviewKind)
+			}
+
+			// both versions share the same response fields
+			review := admissionv1.AdmissionReview{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+				t.Fatalf("failed to unmarshal response; %v", err)
+			}
+
+			if review.Response == nil || review.Response.UID != "test-uid" || review.Response.Allowed ||
+				review.Response.Result.Code != http.StatusForbidden || review.Response.Result.Message != "denied" {
+				t.Errorf("response.send() response = %+v, want denied response for test-uid", review.Response)
+			}
+		})
+	}
+}
+
+func Test_decodeAdmissionReview_unsupportedVersion(t *testing.T) {
+	t.Parallel()
+
+	_, _, err := decodeAdmissionReview([]byte(`{"apiVersion":"admission.k8s.io/v2","kind":"AdmissionReview"}`))
+	if errorTypeOf(err) != ErrorTypeDecode {
+		t.Errorf("decodeAdmissionReview() error = %v, want decode error", err)
+	}
+}
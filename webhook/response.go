@@ -1,7 +1,6 @@
 package webhook
 
 import (
-	"encoding/json"
 	"net/http"
 
 	admissionv1 "k8s.io/api/admission/v1"
@@ -14,21 +13,47 @@ type response struct {
 	allowed bool
 	uid     types.UID
 	writer  http.ResponseWriter
-	review  *admissionv1.AdmissionReview
+
+	// patch is an optional JSON patch which is returned to mutate the object.
+	patch []byte
+
+	// warnings are optional warnings which are returned to the client, whether or not the request is allowed.
+	warnings []string
+
+	// apiVersion is the version of the AdmissionReview to respond with.  It defaults to v1 when unset, such as when
+	// the request could not be decoded.
+	apiVersion string
+
+	// review is the AdmissionReview which was sent in response to the request.
+	review *admissionv1.AdmissionReview
 }
 
-// send sends a response.
-func (r *response) send(message string) {
-	r.review.Response = &admissionv1.AdmissionResponse{
-		Allowed: r.allowed,
-		UID:     r.uid,
-		Result: &metav1.Status{
-			Message: message,
-			Code:    http.StatusOK,
+// send sends a response with a given status code.  A new AdmissionReview is always returned so that a
+// well-formed response is sent even when the request could not be decoded.
+func (r *response) send(code int32, message string) {
+	r.review = &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionv1.SchemeGroupVersion.String(),
+			Kind:       admissionReviewKind,
 		},
+		Response: &admissionv1.AdmissionResponse{
+			Allowed: r.allowed,
+			UID:     r.uid,
+			Result: &metav1.Status{
+				Message: message,
+				Code:    code,
+			},
+			Warnings: r.warnings,
+		},
+	}
+
+	if len(r.patch) > 0 {
+		patchType := admissionv1.PatchTypeJSONPatch
+		r.review.Response.Patch = r.patch
+		r.review.Response.PatchType = &patchType
 	}
 
-	responseBody, _ := json.Marshal(r.review)
+	responseBody, _ := encodeAdmissionReview(r.review.Response, r.apiVersion)
 	r.writer.Header().Set("Content-Type", "application/json")
 	r.writer.Write(responseBody)
 }
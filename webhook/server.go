@@ -0,0 +1,333 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/scottd018/rosa-windows-overcommit-webhook/metrics"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/pkg/client"
+)
+
+const (
+	serverAddress = ":8443"
+
+	// maxRequestBodyBytes is the maximum size of an AdmissionReview that is accepted by the server.  The API server
+	// limits objects to roughly 3MiB and an AdmissionReview may contain both the object and the old object.
+	maxRequestBodyBytes = 7 * 1024 * 1024
+
+	readHeaderTimeout = 5 * time.Second
+	readTimeout       = 10 * time.Second
+	writeTimeout      = 15 * time.Second
+	idleTimeout       = 60 * time.Second
+
+	// shutdownDelay is the amount of time between failing readiness and shutting down the server.  This gives the
+	// endpoints for the service time to stop routing to the server before it stops accepting connections.
+	shutdownDelay = 10 * time.Second
+
+	// shutdownTimeout is the amount of time that in-flight requests are given to drain once the server is shut down.
+	shutdownTimeout = 15 * time.Second
+)
+
+// CertificateProvider represents an object which provides the certificate served by the server.
+type CertificateProvider interface {
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	Ready() error
+}
+
+// Server represents the http server which serves the webhook.
+type Server struct {
+	webhook      *webhook
+	server       *http.Server
+	checks       []ReadinessCheck
+	shuttingDown atomic.Bool
+}
+
+// NewServer returns a new instance of a server object which serves the webhook using the certificate returned
+// from the certificate provider.
+func NewServer(wh *webhook, certificates CertificateProvider) *Server {
+	s := &Server{webhook: wh}
+
+	s.checks = append([]ReadinessCheck{
+		{Name: "shutdown", Check: s.checkShutdown},
+		{Name: "certificate", Check: func(context.Context) error { return certificates.Ready() }},
+	}, wh.ReadinessChecks()...)
+
+	mux := http.NewServeMux()
+	mux.Handle("/validate", s.admissionHandler(s.recorded(wh.Validate)))
+	mux.Handle("/mutate", s.admissionHandler(wh.Mutate))
+	mux.Handle("/validate-node", s.admissionHandler(wh.ValidateNode))
+	mux.Handle(client.CapacityPath, s.apiHandler(wh.Capacity))
+	mux.Handle(client.CheckPath, s.apiHandler(wh.Check))
+	mux.Handle(client.ExplainPath, s.apiHandler(wh.Explain))
+	mux.Handle(recordingsPath, s.apiHandler(wh.Recordings))
+	mux.HandleFunc("/healthz", wh.HealthZ)
+	mux.HandleFunc("/readyz", s.ReadyZ)
+	mux.Handle("/metrics", metrics.Handler())
+
+	s.server = &http.Server{
+		Addr:              serverAddress,
+		Handler:           mux,
+		TLSConfig:         &tls.Config{GetCertificate: certificates.GetCertificate, MinVersion: tls.VersionTLS12},
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+	}
+
+	return s
+}
+
+// Run starts the server and blocks until the context is cancelled, at which point readiness is failed and the server
+// is gracefully shut down, draining any in-flight requests.
+func (s *Server) Run(ctx context.Context) error {
+	errs := make(chan error, 1)
+
+	go func() {
+		s.webhook.Logger.Info().Msgf("Starting webhook server on %s", serverAddress)
+
+		if err := s.server.ListenAndServeTLS("", ""); !errors.Is(err, http.ErrServerClosed) {
+			errs <- err
+		}
+
+		close(errs)
+	}()
+
+	go s.webhook.checkpointLoop(ctx)
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("webhook server failed; %w", err)
+	case <-ctx.Done():
+	}
+
+	// fail readiness and give the service time to stop routing to us prior to shutting down
+	s.shuttingDown.Store(true)
+	s.webhook.Logger.Info().Msgf("shutting down webhook server in %s", shutdownDelay)
+	time.Sleep(shutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := s.server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to gracefully shutdown webhook server; %w", err)
+	}
+
+	s.webhook.Logger.Info().Msg("webhook server shutdown complete")
+
+	if err := s.webhook.recorder.close(); err != nil {
+		s.webhook.Logger.Error().Err(err).Msg("failed to close recorder")
+	}
+
+	// checkpoint the audit records of any drained requests before they are closed
+	s.webhook.checkpointAudit(context.Background())
+
+	if s.webhook.auditor != nil {
+		if err := s.webhook.auditor.Close(); err != nil {
+			s.webhook.Logger.Error().Err(err).Msg("failed to close audit logger")
+		}
+	}
+
+	return <-errs
+}
+
+// readinessResult represents the result of a single readiness check.
+type readinessResult struct {
+	Name    string `json:"name"`
+	Ready   bool   `json:"ready"`
+	Message string `json:"message,omitempty"`
+}
+
+// readinessResponse represents the response of the readiness endpoint.
+type readinessResponse struct {
+	Ready  bool              `json:"ready"`
+	Checks []readinessResult `json:"checks"`
+}
+
+// ReadyZ implements a readiness check that verifies that the webhook is able to make decisions.  It returns the
+// result of each individual check and fails once the server has begun shutting down.
+func (s *Server) ReadyZ(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	result := readinessResponse{Ready: true, Checks: make([]readinessResult, len(s.checks))}
+
+	for i, check := range s.checks {
+		result.Checks[i] = readinessResult{Name: check.Name, Ready: true}
+
+		if err := check.Check(ctx); err != nil {
+			result.Ready = false
+			result.Checks[i].Ready = false
+			result.Checks[i].Message = err.Error()
+		}
+	}
+
+	code := http.StatusOK
+	if !result.Ready {
+		code = http.StatusServiceUnavailable
+
+		s.webhook.Logger.Debug().Interface("checks", result.Checks).Msg("readiness check failed")
+	}
+
+	responseBody, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(responseBody)
+}
+
+// checkShutdown fails once the server has begun shutting down so that the service stops routing to us.
+func (s *Server) checkShutdown(context.Context) error {
+	if s.shuttingDown.Load() {
+		return errors.New("server is shutting down")
+	}
+
+	return nil
+}
+
+// admissionHandler wraps an admission handler so that the request body is limited in size, and so that a request
+// body which cannot be read, or a panic while handling the request, still returns a well-formed AdmissionReview to
+// the API server whose outcome is decided by the failure policy.
+func (s *Server) admissionHandler(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				s.webhook.fail(
+					recoveredOperation(w, body),
+					newAdmissionError(ErrorTypeDecode, "request body exceeds %d bytes", maxRequestBodyBytes),
+				)
+
+				return
+			}
+
+			s.webhook.fail(recoveredOperation(w, body), newAdmissionError(ErrorTypeDecode, "failed to read request body; %w", err))
+
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				s.webhook.Logger.Error().
+					Str("path", r.URL.Path).
+					Interface("panic", recovered).
+					Msg("recovered from panic while handling admission request")
+
+				s.webhook.fail(
+					recoveredOperation(w, body),
+					newAdmissionError(ErrorTypeInternal, "internal error while handling admission request: %v", recovered),
+				)
+			}
+		}()
+
+		next(w, r)
+	})
+}
+
+// recorded wraps an admission handler so that a sample of the requests are recorded along with their responses.  The
+// request body must already be buffered by the admission handler so that it may be read again.
+func (s *Server) recorded(next http.HandlerFunc) http.HandlerFunc {
+	rec := s.webhook.recorder
+	if rec == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !rec.sampled() {
+			metrics.RecorderRecords.WithLabelValues("skipped").Inc()
+			next(w, r)
+
+			return
+		}
+
+		review, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.webhook.fail(recoveredOperation(w, review), newAdmissionError(ErrorTypeDecode, "failed to read request body; %w", err))
+
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(review))
+
+		writer := &recordingWriter{ResponseWriter: w}
+		next(writer, r)
+
+		if _, err := rec.record(review, writer.body.Bytes()); err != nil {
+			s.webhook.Logger.Error().Err(err).Msg("failed to record admission request")
+		}
+	}
+}
+
+// apiHandler wraps an API handler so that the request is authenticated and authorized and the request body is limited
+// in size, and so that a panic while handling the request still returns a well-formed error.
+func (s *Server) apiHandler(next apiHandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.webhook.authenticate(r)
+		if err != nil {
+			s.webhook.Logger.Debug().Err(err).Str("path", r.URL.Path).Msg("failed to authenticate api request")
+
+			w.Header().Set("WWW-Authenticate", `Bearer realm="windows-overcommit-webhook"`)
+			writeJSON(w, http.StatusUnauthorized, client.ErrorResponse{Error: "unauthorized"})
+
+			return
+		}
+
+		allowed, err := s.webhook.authorize(r, *user)
+		if err != nil {
+			s.webhook.Logger.Error().Err(err).Str("path", r.URL.Path).Msg("failed to authorize api request")
+			writeJSON(w, http.StatusInternalServerError, client.ErrorResponse{Error: "failed to authorize request"})
+
+			return
+		}
+
+		if !allowed {
+			s.webhook.Logger.Info().Str("path", r.URL.Path).Str("user", user.Username).Msg("forbidden api request")
+			writeJSON(w, http.StatusForbidden, client.ErrorResponse{
+				Error: fmt.Sprintf("user [%s] may not %s [%s]", user.Username, apiVerb(r.Method), r.URL.Path),
+			})
+
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				s.webhook.Logger.Error().
+					Str("path", r.URL.Path).
+					Interface("panic", recovered).
+					Msg("recovered from panic while handling api request")
+
+				writeJSON(w, http.StatusInternalServerError, client.ErrorResponse{Error: "internal error while handling api request"})
+			}
+		}()
+
+		s.webhook.Logger.Info().Str("path", r.URL.Path).Str("user", user.Username).Msg("received api request")
+
+		next(w, r, *user)
+	})
+}
+
+// recoveredOperation returns an operation for a request which could not be handled, for example because its handler
+// panicked or its body could not be read, so that a response may still be sent.  The request body is decoded on a best-effort basis so that the response carries the request UID.
+func recoveredOperation(w http.ResponseWriter, body []byte) *operation {
+	op := &operation{response: &response{writer: w}}
+
+	review, apiVersion, err := decodeAdmissionReview(body)
+	if err == nil && review.Request != nil {
+		op.response.uid = review.Request.UID
+		op.response.apiVersion = apiVersion
+	}
+
+	return op
+}
@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+func TestServer_admissionHandler(t *testing.T) {
+	t.Parallel()
+
+	failurePolicy, err := NewFailurePolicy()
+	if err != nil {
+		t.Fatalf("NewFailurePolicy() error = %v", err)
+	}
+
+	s := &Server{webhook: &webhook{Logger: zerolog.Nop(), FailurePolicy: failurePolicy}}
+
+	panics := s.admissionHandler(func(http.ResponseWriter, *http.Request) {
+		panic("test panic")
+	})
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   int32
+		wantUID    string
+	}{
+		{
+			name:       "ensure a panic returns a denied admission review with the request uid",
+			body:       `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"test-uid"}}`,
+			wantStatus: http.StatusOK,
+			wantCode:   http.StatusInternalServerError,
+			wantUID:    "test-uid",
+		},
+		{
+			name:       "ensure a panic with an undecodable body returns a denied admission review",
+			body:       `not json`,
+			wantStatus: http.StatusOK,
+			wantCode:   http.StatusInternalServerError,
+		},
+		{
+			name:       "ensure a request body exceeding the maximum size returns a denied admission review",
+			body:       `{"pad":"` + strings.Repeat("a", maxRequestBodyBytes) + `"}`,
+			wantStatus: http.StatusOK,
+			wantCode:   http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			panics.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewBufferString(tt.body)))
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("admissionHandler() status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+
+			review := admissionv1.AdmissionReview{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+				t.Fatalf("admissionHandler() returned invalid admission review; %v", err)
+			}
+
+			if review.Response == nil || review.Response.Allowed {
+				t.Fatalf("admissionHandler() response = %+v, want denied", review.Response)
+			}
+
+			if got := review.Response.Result.Code; got != tt.wantCode {
+				t.Errorf("admissionHandler() code = %d, want %d", got, tt.wantCode)
+			}
+
+			if got := string(review.Response.UID); got != tt.wantUID {
+				t.Errorf("admissionHandler() uid = %s, want %s", got, tt.wantUID)
+			}
+		})
+	}
+}
+
+func TestServer_recorded(t *testing.T) {
+	t.Parallel()
+
+	rec, err := newRecorder("memory", "", "", "", "", "")
+	if err != nil {
+		t.Fatalf("newRecorder() error = %v", err)
+	}
+
+	s := &Server{webhook: &webhook{Logger: zerolog.Nop(), recorder: rec}}
+
+	review, response := testRecorderReview("test-uid")
+
+	handler := s.admissionHandler(s.recorded(func(w http.ResponseWriter, r *http.Request) {
+		// the handler must still be able to read the request body
+		body := new(bytes.Buffer)
+		body.ReadFrom(r.Body)
+
+		if body.String() != string(review) {
+			t.Errorf("handler body = %s, want %s", body, review)
+		}
+
+		w.Write(response)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(review)))
+
+	if got := recorder.Body.String(); got != string(response) {
+		t.Errorf("recorded() response = %s, want %s", got, response)
+	}
+
+	if got := string(rec.recordings(nil)); !strings.Contains(got, `"uid":"test-uid"`) || !strings.Contains(got, `"allowed":true`) {
+		t.Errorf("recordings() = %s, want the request and its response", got)
+	}
+}
@@ -5,41 +5,149 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/rs/zerolog"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
+	kubevirtv1 "kubevirt.io/api/core/v1"
 	"kubevirt.io/client-go/kubecli"
 
+	"github.com/scottd018/rosa-windows-overcommit-webhook/audit"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/clients"
+	"github.com/scottd018/rosa-windows-overcommit-webhook/redact"
 	"github.com/scottd018/rosa-windows-overcommit-webhook/resources"
 )
 
 // webhook represents a webhook object.
 type webhook struct {
-	Context    context.Context
-	KubeClient *kubernetes.Clientset
-	VirtClient kubecli.KubevirtClient
-	NodeFilter resources.NodeFilter
-	Logger     zerolog.Logger
+	Context       context.Context
+	KubeClient    kubernetes.Interface
+	VirtClient    kubecli.KubevirtClient
+	NodeFilter    resources.NodeFilter
+	FailurePolicy *FailurePolicy
+	CountByLabel  bool
+	Logger        zerolog.Logger
+
+	NodeValidationMode   NodeValidationMode
+	QueueVirtualMachines bool
+	QueueOrder           resources.QueueOrder
+	Priority             *priorityPolicy
+	Explainer            *explainer
+
+	readiness  readinessState
+	decisions  *decisionCache
+	priorities *priorityClassCache
+	placement  *placement
+	recorder   *recorder
+
+	tokenReviews  *reviewCache[authenticationv1.UserInfo]
+	accessReviews *reviewCache[bool]
+
+	debugRedactor *redact.Redactor
+
+	auditor       *audit.Logger
+	checkpoints   *checkpointer
+	policy        string
+	policyVersion string
 }
 
 // NewWebhook returns a new instance of a webhook object.
 func NewWebhook() (*webhook, error) {
-	// create the kubernetes client alongside the virtualization client
-	config, err := rest.InClusterConfig()
+	c, err := clients.NewInCluster()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create in-cluster config; %w", err)
+		return nil, err
 	}
 
-	kubeClient, err := kubernetes.NewForConfig(config)
+	return NewWebhookForClients(c.KubeClient, c.VirtClient)
+}
+
+// NewWebhookForClients returns a new instance of a webhook object which uses the given clients, for example those of
+// a cluster snapshot.  It is otherwise configured from the environment in the same way as NewWebhook.
+func NewWebhookForClients(kubeClient kubernetes.Interface, virtClient kubecli.KubevirtClient) (*webhook, error) {
+	failurePolicy, err := NewFailurePolicy()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create failure policy; %w", err)
+	}
+
+	decisionCacheTTL := DefaultDecisionCacheTTL
+	if value := os.Getenv(EnvDecisionCacheTTL); value != "" {
+		if decisionCacheTTL, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid value [%s] for [%s]; %w", value, EnvDecisionCacheTTL, err)
+		}
+	}
+
+	nodeFilter := resources.NewNodeFilter(os.Getenv(resources.EnvLabelKey), os.Getenv(resources.EnvLabelValues))
+
+	// only pin windows instances to the licensed nodes when requested
+	var windowsPlacement *placement
+	if os.Getenv(EnvPinWindowsInstances) == "true" {
+		if windowsPlacement, err = newPlacement(nodeFilter, os.Getenv(EnvNodeTolerations)); err != nil {
+			return nil, fmt.Errorf("invalid value for [%s]; %w", EnvNodeTolerations, err)
+		}
+	}
+
+	nodeValidationMode, err := newNodeValidationMode(os.Getenv(EnvNodeValidationMode))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create kubernetes client; %w", err)
+		return nil, err
 	}
 
-	virtClient, err := kubecli.GetKubevirtClientFromRESTConfig(config)
+	queueOrder, err := resources.NewQueueOrder(os.Getenv(resources.EnvQueueOrder))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create kubevirt client; %w", err)
+		return nil, err
+	}
+
+	priority, err := newPriorityPolicy(os.Getenv(EnvReservedCapacity), os.Getenv(EnvHighPriority))
+	if err != nil {
+		return nil, err
+	}
+
+	explainer, err := newExplainer(os.Getenv(EnvExplainTop), os.Getenv(EnvExplainByInstance))
+	if err != nil {
+		return nil, err
+	}
+
+	recorder, err := newRecorder(
+		os.Getenv(EnvRecorder),
+		os.Getenv(EnvRecorderPath),
+		os.Getenv(EnvRecorderMaxBytes),
+		os.Getenv(EnvRecorderBufferSize),
+		os.Getenv(EnvRecorderSampleRate),
+		os.Getenv(EnvRecorderRedact),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	debugRedactor, err := newDebugRedactor(os.Getenv(EnvDebugRedact))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for [%s]; %w", EnvDebugRedact, err)
+	}
+
+	auditor, err := newAuditLogger(
+		os.Getenv(EnvAudit),
+		os.Getenv(EnvAuditPath),
+		os.Getenv(EnvAuditMaxBytes),
+		os.Getenv(EnvAuditMaxBackups),
+		os.Getenv(EnvAuditChain) == "true",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// only checkpoint the head of the audit records when they are chained
+	var checkpoints *checkpointer
+	if auditor != nil && os.Getenv(EnvAuditChain) == "true" {
+		checkpoints, err = newCheckpointer(
+			kubeClient,
+			os.Getenv(EnvAuditCheckpointName),
+			os.Getenv(EnvAuditCheckpointInterval),
+			os.Getenv(EnvAuditPath),
+		)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	logLevel := zerolog.InfoLevel
@@ -48,90 +156,124 @@ func NewWebhook() (*webhook, error) {
 	}
 
 	// create and run the webhook
-	return &webhook{
-		Context:    context.Background(),
-		KubeClient: kubeClient,
-		VirtClient: virtClient,
-		NodeFilter: resources.NewNodeFilter(os.Getenv(resources.EnvLabelKey), os.Getenv(resources.EnvLabelValues)),
-		Logger:     zerolog.New(os.Stdout).Level(logLevel),
-	}, nil
+	wh := &webhook{
+		Context:       context.Background(),
+		KubeClient:    kubeClient,
+		VirtClient:    virtClient,
+		NodeFilter:    nodeFilter,
+		FailurePolicy: failurePolicy,
+		CountByLabel:  os.Getenv(EnvCountByLabel) == "true",
+		Logger:        zerolog.New(os.Stdout).Level(logLevel),
+		decisions:     newDecisionCache(decisionCacheTTL),
+		priorities:    newPriorityClassCache(priorityClassCacheTTL),
+		tokenReviews:  newReviewCache[authenticationv1.UserInfo](reviewCacheTTL),
+		accessReviews: newReviewCache[bool](reviewCacheTTL),
+		placement:     windowsPlacement,
+		recorder:      recorder,
+		debugRedactor: debugRedactor,
+		auditor:       auditor,
+		checkpoints:   checkpoints,
+		policyVersion: os.Getenv(EnvPolicyVersion),
+
+		NodeValidationMode:   nodeValidationMode,
+		QueueVirtualMachines: os.Getenv(EnvQueueVirtualMachines) == "true",
+		QueueOrder:           queueOrder,
+		Priority:             priority,
+		Explainer:            explainer,
+	}
+
+	wh.policy = wh.policyHash()
+
+	return wh, nil
 }
 
 // Validate runs the validation logic for the webhook.
 func (wh *webhook) Validate(w http.ResponseWriter, r *http.Request) {
+	var (
+		instance *kubevirtv1.VirtualMachineInstance
+		decision *Decision
+		cached   bool
+	)
+
 	// create the operation object
 	op, err := NewOperation(w, r)
+
+	// audit the decision with whatever was known when it was made
+	defer func() { wh.audit(op, instance, decision, cached) }()
+
 	if err != nil {
-		wh.respond(op, err.Error(), true)
+		wh.fail(op, err)
+		return
 	}
 	wh.log(op).Msg("received validation request")
-	wh.debug(op).Msgf("OBJECT: %+v", op.object)
+	wh.debugObject(op)
+
+	// replay the original decision if this is a retry of a request we have already decided
+	if previous, found := wh.decisions.get(op.response.uid); found {
+		wh.log(op).Msg("replaying cached decision for retried request")
+		op.response.allowed = previous.allowed
+		op.response.send(previous.code, previous.message)
+		cached = true
+
+		return
+	}
 
 	// return immediately if we do not need validation
 	validationResult := op.object.NeedsValidation()
 	if !validationResult.NeedsValidation {
-		wh.respond(op, fmt.Sprintf("skipping validation, reason [%s]", validationResult.Reason), true)
+		wh.respond(op, http.StatusOK, fmt.Sprintf("skipping validation, reason [%s]", validationResult.Reason), true)
 		return
 	}
 
 	wh.log(op).Msgf("validating request for reason [%s]", validationResult.Reason)
 
-	// get the requested capacity from the request
-	requested := op.object.SumCPU()
-
-	// get the virtual machine instance list from the cluster and the current used capacity
-	vmInstanceList, err := wh.getFilteredVirtualMachineInstances()
+	instance, err = decodeInstance(op.request.admissionRequest.Kind.Kind, op.request.admissionRequest.Object.Raw)
 	if err != nil {
-		wh.respond(op, err.Error(), true)
+		wh.fail(op, err)
 		return
 	}
-	used := vmInstanceList.SumCPU()
 
-	// // return if we found an instance in the cluster matching this name
-	// // TODO: this likely needs to be handled differently for an UPDATE request
-	// for i := 0; i < len(vmInstanceList); i++ {
-	// 	if vmInstanceList[i].GetName() == op.object.GetName() && vmInstanceList[i].GetNamespace() == op.object.GetNamespace() {
-	// 		wh.respond(op, "skipping validation", true)
-	// 		return
-	// 	}
-	// }
+	ctx, cancel := context.WithTimeout(r.Context(), decisionTimeout)
+	defer cancel()
 
-	// get the node list from the cluster and the total capacity
-	nodeList, err := wh.getFilteredNodes()
+	// the denial is seen by anyone who may see the object, rather than only by the requester
+	decision, topConsumers, err := wh.decide(ctx, op.object, instance, objectNamespace(op.object.GetNamespace()))
 	if err != nil {
-		wh.respond(op, err.Error(), true)
+		wh.fail(op, err)
 		return
 	}
-	total := nodeList.SumCPU()
-
-	available := total - used
-
 	wh.log(op).
-		Int("total", total).
-		Int("available", available).
-		Int("requested", requested).
-		Int("used", used).
+		Int("total", decision.Total).
+		Int("available", decision.Available).
+		Int("requested", decision.Requested).
+		Int("used", decision.Used).
+		Int("reserved", decision.Reserved).
+		Int32("priority", decision.Priority).
+		Bool("dry_run", op.isDryRun()).
 		Msg("capacity values")
 
-	// ensure the requested capacity would not exceed the available capacity
-	if requested > available {
-		msg := fmt.Sprintf("requested capacity: [%d], exceeds available capacity: [%d]; currently used [%d]",
-			requested,
-			available,
-			used,
-		)
+	if !decision.Allowed {
+		wh.log(op).Interface("top_consumers", topConsumers).Msg("top consumers of capacity")
+
 		op.response.allowed = false
-		wh.respond(op, msg, true)
+		wh.respond(op, decision.Code, decision.Message, true)
+
+		return
+	}
+
+	// let dry run requests know what would remain if the request were real
+	if op.isDryRun() {
+		wh.respond(op, http.StatusOK, fmt.Sprintf("request success (dry run); remaining capacity: [%d]", decision.Available-decision.Requested), true)
 
 		return
 	}
 
-	wh.respond(op, "request success", false)
+	wh.respond(op, http.StatusOK, "request success", false)
 }
 
 const statusOkMessage = `{"msg": "server is healthy"}`
 
-// HealthZ implements a simple health check that returns a 200 ok response.
+// HealthZ implements a simple liveness check that returns a 200 ok response.  See the server ReadyZ for readiness.
 func (wh *webhook) HealthZ(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -147,55 +289,98 @@ func (wh *webhook) HealthZ(w http.ResponseWriter, r *http.Request) {
 
 // log logs an info message.
 func (wh *webhook) log(op *operation) *zerolog.Event {
-	return wh.Logger.Info().
-		Str("uid", string(op.response.uid)).
-		Str("kind", op.object.GetObjectKind().GroupVersionKind().Kind).
-		Str("name", op.object.GetName()).
-		Str("namespace", op.object.GetNamespace())
+	return withOperation(wh.Logger.Info(), op)
 }
 
 // debug logs a debug message.
 func (wh *webhook) debug(op *operation) *zerolog.Event {
-	return wh.Logger.Debug().
-		Str("uid", string(op.response.uid)).
+	return withOperation(wh.Logger.Debug(), op)
+}
+
+// withOperation adds the fields which identify an operation to a log event.  The operation may only be partially
+// populated if the request failed before the object was extracted.
+func withOperation(event *zerolog.Event, op *operation) *zerolog.Event {
+	event = event.Str("uid", string(op.response.uid))
+
+	if op.object == nil {
+		return event
+	}
+
+	return event.
 		Str("kind", op.object.GetObjectKind().GroupVersionKind().Kind).
 		Str("name", op.object.GetName()).
 		Str("namespace", op.object.GetNamespace())
 }
 
 // respond sends a response for a webhook operation, optionally logging if requested.
-func (wh *webhook) respond(op *operation, msg string, logToStdout bool) {
+func (wh *webhook) respond(op *operation, code int32, msg string, logToStdout bool) {
 	if logToStdout {
 		wh.log(op).Msgf("returning with message: [%s]", msg)
 	}
 
-	op.response.send(msg)
+	// dry run requests must not have side effects
+	if !op.isDryRun() {
+		wh.decisions.put(op.response.uid, op.response.allowed, code, msg)
+	}
+
+	op.response.send(code, msg)
+}
+
+// fail sends a response for a webhook operation which failed with an error.  Whether the request is allowed
+// or denied is determined by the failure policy for the type of error.
+func (wh *webhook) fail(op *operation, err error) {
+	errorType := errorTypeOf(err)
+
+	op.response.allowed = wh.FailurePolicy.Allows(err)
+
+	code := errorType.code()
+	if op.response.allowed {
+		code = http.StatusOK
+	}
+
+	wh.log(op).
+		Str("error_type", string(errorType)).
+		Bool("allowed", op.response.allowed).
+		Msgf("request failed: %v", err)
+
+	op.response.send(code, fmt.Sprintf("[%s] %v", errorType, err))
 }
 
 // getFilteredNodes returns a list of filtered nodes that exist in the cluster.
-func (wh *webhook) getFilteredNodes() (resources.Nodes, error) {
-	nodeList, err := wh.KubeClient.CoreV1().Nodes().List(wh.Context, metav1.ListOptions{})
+func (wh *webhook) getFilteredNodes(ctx context.Context) (resources.Nodes, error) {
+	nodeList, err := wh.KubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to list nodes; %v", err)
+		return nil, newAdmissionError(ErrorTypeAPI, "failed to list nodes; %w", err)
 	}
 
 	var nodes resources.Nodes = nodeList.Items
 
-	return nodes.Filter(wh.NodeFilter), nil
+	filtered := nodes.Filter(wh.NodeFilter)
+	wh.readiness.recordNodeList(len(filtered))
+
+	return filtered, nil
 }
 
 // getFilteredVirtualMachineInstances returns a list of filtered virtual machine instances that exist in the cluster.
 // we need to gather both virtual machines and virtual machine instances in the case that an instance is not yet
 // created from a virtual machine object.  then we can merge the two together.
-func (wh *webhook) getFilteredVirtualMachineInstances() (resources.VirtualMachineInstances, error) {
-	vmInstancesAll, err := wh.VirtClient.VirtualMachineInstance("").List(wh.Context, metav1.ListOptions{})
+func (wh *webhook) getFilteredVirtualMachineInstances(ctx context.Context) (resources.VirtualMachineInstances, error) {
+	// only list the labeled instances when counting by label
+	listOptions := metav1.ListOptions{}
+	if wh.CountByLabel {
+		listOptions.LabelSelector = fmt.Sprintf("%s=%s", resources.WindowsLabelKey, resources.WindowsLabelValue)
+	}
+
+	vmInstancesAll, err := wh.VirtClient.VirtualMachineInstance("").List(ctx, listOptions)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list virtual machine instances; %v", err)
+		return nil, newAdmissionError(ErrorTypeAPI, "failed to list virtual machine instances; %w", err)
 	}
 
+	wh.readiness.recordInstanceList()
+
 	// convert our list to our internal resource and filter
 	instancesFiltered := resources.VirtualMachineInstances(vmInstancesAll.Items).Filter(
-		&resources.VirtualMachineInstancesFilter{},
+		&resources.VirtualMachineInstancesFilter{ByLabel: wh.CountByLabel},
 	)
 
 	filtered := instancesFiltered